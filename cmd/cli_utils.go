@@ -2,8 +2,11 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
+	"strings"
 
 	"pixelridgesoftworks.com/AllPac/pkg/logger"
 	"pixelridgesoftworks.com/AllPac/pkg/packagemanager"
@@ -12,6 +15,7 @@ import (
 func handleUpdateError(updateOption string, err error) {
     if err != nil {
         fmt.Printf("Error occurred during '%s' update: %v\n", updateOption, err)
+        printPGPHint(err)
     } else {
         fmt.Printf("Update '%s' completed successfully.\n", updateOption)
     }
@@ -29,11 +33,198 @@ func handleVersion(args []string) {
 }
 
 func handleRepair(args []string) {
-    // Assuming GetPkgListPath() returns a string path
-    pkgListPath, _ := packagemanager.GetPkgListPath()
+    assumeYes, _ := extractFlag(args, "--yes")
 
-    err :=packagemanager.InitializePkgListFile(pkgListPath)
+    plan, err := packagemanager.PlanPackageListRepair()
     if err != nil {
-        logger.Errorf("Error initializing version file: %v", err)
+        fmt.Printf("Error repairing package list: %v\n", err)
+        return
+    }
+
+    fmt.Printf("The current package list was backed up to %s\n", plan.BackupPath)
+    if plan.Corrupt {
+        fmt.Printf("The package list was corrupt, %d entries could be salvaged.\n", len(plan.Current))
+    }
+
+    // A corrupt file is always rewritten, even if the salvaged entries already match the system
+    if len(plan.Changes) == 0 && !plan.Corrupt {
+        fmt.Println("The package list is already consistent with the system, nothing to repair.")
+        return
+    }
+
+    printPackageListChanges(plan.Changes)
+
+    if !assumeYes && !packagemanager.ConfirmAction("Write the repaired package list?") {
+        fmt.Println("Repair aborted, the package list was left untouched.")
+        return
+    }
+
+    if err := packagemanager.ApplyPackageListRepair(plan); err != nil {
+        fmt.Printf("Error writing repaired package list: %v\n", err)
+        return
+    }
+    fmt.Printf("Package list repaired, %d packages are now managed by AllPac.\n", len(plan.Repaired))
+}
+
+// prints the differences between two package lists in a diff-like format
+func printPackageListChanges(changes []packagemanager.PackageListChange) {
+    for _, change := range changes {
+        switch {
+        case change.Old == nil:
+            fmt.Printf("+ %s (%s %s)\n", change.Name, change.New.Source, change.New.Version)
+        case change.New == nil:
+            fmt.Printf("- %s (%s %s)\n", change.Name, change.Old.Source, change.Old.Version)
+        default:
+            fmt.Printf("~ %s (%s %s -> %s %s)\n", change.Name, change.Old.Source, change.Old.Version, change.New.Source, change.New.Version)
+        }
+    }
+}
+
+// prints what a dry run would do
+func printDryRunPlan(plan *packagemanager.DryRunPlan) {
+    if len(plan.Commands) == 0 {
+        fmt.Println("Dry run: no commands would be run.")
+    } else {
+        fmt.Println("Dry run: the following commands would be run:")
+        for _, command := range plan.Commands {
+            var quoted []string
+            for _, variable := range command.Env {
+                quoted = append(quoted, packagemanager.ShellQuote(variable))
+            }
+            for _, arg := range command.Args {
+                quoted = append(quoted, packagemanager.ShellQuote(arg))
+            }
+            if command.Dir != "" {
+                fmt.Printf("  (in %s) %s\n", command.Dir, strings.Join(quoted, " "))
+            } else {
+                fmt.Printf("  %s\n", strings.Join(quoted, " "))
+            }
+        }
+    }
+
+    if len(plan.Changes) == 0 {
+        fmt.Println("The package list would not change.")
+    } else {
+        fmt.Println("Planned package list changes:")
+        printPackageListChanges(plan.Changes)
+    }
+
+    for _, note := range plan.Notes {
+        fmt.Printf("Note: %s\n", note)
+    }
+}
+
+// prints a value as indented JSON
+func printJSON(value interface{}) {
+    data, err := json.MarshalIndent(value, "", "  ")
+    if err != nil {
+        fmt.Printf("Error encoding JSON: %v\n", err)
+        return
+    }
+    fmt.Println(string(data))
+}
+
+// prints a titled block of detail fields, continuing multi-line values under their key
+func printDetailFields(title string, fields []packagemanager.DetailField) {
+    if len(fields) == 0 {
+        return
+    }
+
+    fmt.Println(title)
+    for _, field := range fields {
+        lines := strings.Split(field.Value, "\n")
+        fmt.Printf("  %-16s: %s\n", field.Key, lines[0])
+        for _, line := range lines[1:] {
+            fmt.Printf("  %-16s  %s\n", "", line)
+        }
+    }
+}
+
+// formats a size in bytes for display
+func formatSize(size int64) string {
+    if size <= 0 {
+        return "-"
+    }
+
+    units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+    value := float64(size)
+    unit := 0
+    for value >= 1024 && unit < len(units)-1 {
+        value /= 1024
+        unit++
+    }
+    return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// removes the given flag from the arguments and reports whether it was present
+func extractFlag(args []string, flag string) (bool, []string) {
+    found := false
+    var remaining []string
+    for _, arg := range args {
+        if arg == flag {
+            found = true
+            continue
+        }
+        remaining = append(remaining, arg)
+    }
+    return found, remaining
+}
+
+// removes an option and its value from the arguments, accepting both "--option value" and "--option=value"
+func extractOption(args []string, option string) (string, []string) {
+    value := ""
+    var remaining []string
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        if arg == option && i+1 < len(args) {
+            value = args[i+1]
+            i++
+            continue
+        }
+        if strings.HasPrefix(arg, option+"=") {
+            value = strings.TrimPrefix(arg, option+"=")
+            continue
+        }
+        remaining = append(remaining, arg)
+    }
+    return value, remaining
+}
+
+// explains how to get the keys an AUR package needs when its sources couldn't be verified
+func printPGPHint(err error) {
+    var pgpErr *packagemanager.PGPVerificationError
+    if !errors.As(err, &pgpErr) || len(pgpErr.MissingKeys) == 0 {
+        return
+    }
+    fmt.Printf("Import the keys with 'gpg --recv-keys %s' after checking them, or set pgp_keyserver or pgp_key_dir in ~/.allpac/config.json.\n", strings.Join(pgpErr.MissingKeys, " "))
+}
+
+// parses a comma separated list of AUR build steps to skip, like "review,install"
+func parseSkippedSteps(value string) map[string]bool {
+    skip := make(map[string]bool)
+    for _, step := range strings.Split(value, ",") {
+        if step = strings.ToLower(strings.TrimSpace(step)); step != "" {
+            skip[step] = true
+        }
+    }
+    return skip
+}
+
+// prints what every step of an AUR build did
+func printAURBuildReport(report *packagemanager.AURBuildReport) {
+    if report == nil || len(report.Steps) == 0 {
+        return
+    }
+
+    fmt.Printf("Steps for %s:\n", report.Package)
+    for _, step := range report.Steps {
+        status := "done"
+        if step.Skipped {
+            status = "skipped"
+        }
+        fmt.Printf("  %-8s %-8s %s\n", step.Step, status, step.Detail)
+    }
+    if !report.Installed && report.Version != "" {
+        fmt.Printf("%s %s was built but not installed, install it with 'allpac install %s --skip fetch,build'.\n", report.Package, report.Version, report.Package)
     }
 }
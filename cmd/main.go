@@ -11,6 +11,9 @@ import (
     "pixelridgesoftworks.com/AllPac/pkg/toolcheck"
 	"path/filepath"
     "regexp"
+    "sort"
+    "strconv"
+    "time"
 )
 
 func main() {
@@ -21,7 +24,7 @@ func main() {
     }
 
     if len(os.Args) < 2 {
-        fmt.Println("Expected 'update', 'install', 'uninstall', 'search', 'rebuild', 'clean-aur', or 'toolcheck' subcommands")
+        fmt.Println("Expected 'update', 'install', 'uninstall', 'search', 'rebuild', 'downgrade', 'clean-aur', 'adopt', 'check', 'state', 'sync', 'lock', 'export', 'import', 'list', 'info', 'hold', 'unhold', 'trust', 'untrust', 'outdated', or 'toolcheck' subcommands")
         os.Exit(1)
     }
 
@@ -41,12 +44,42 @@ func main() {
         handleRebuild(args)
     case "clean-aur":
         handleCleanAur(args)
+    case "downgrade":
+        handleDowngrade(args)
     case "toolcheck":
         handleToolCheck(args)
     case "version":
         handleVersion(args)
     case "repair":
         handleRepair(args)
+    case "adopt":
+        handleAdopt(args)
+    case "check":
+        handleCheck(args)
+    case "state":
+        handleState(args)
+    case "sync":
+        handleSync(args)
+    case "lock":
+        handleLock(args)
+    case "list":
+        handleList(args)
+    case "info":
+        handleInfo(args)
+    case "outdated":
+        handleOutdated(args)
+    case "hold":
+        handleHold(args, true)
+    case "unhold":
+        handleHold(args, false)
+    case "trust":
+        handleTrust(args, true)
+    case "untrust":
+        handleTrust(args, false)
+    case "export":
+        handleExport(args)
+    case "import":
+        handleImport(args)
     default:
         fmt.Printf("Unknown subcommand: %s\n", command)
         os.Exit(1)
@@ -54,6 +87,9 @@ func main() {
 }
 
 func handleUpdate(args []string) {
+    dryRun, args := extractFlag(args, "--dry-run")
+    devel, args := extractFlag(args, "--devel")
+
     if len(args) == 0 {
         fmt.Println("You must specify an update option: 'everything', 'snaps', 'aur', 'arch', 'flats', or a specific package name.")
         return
@@ -65,25 +101,44 @@ func handleUpdate(args []string) {
     }
 
     updateFuncs := map[string]func() error{
-        "everything": packagemanager.UpdateAllPackages,
+        "everything": func() error { return packagemanager.UpdateAllPackages(devel) },
         "snaps":       func() error { return packagemanager.UpdateSnapPackages() },
-        "aur":        func() error { return packagemanager.UpdateAURPackages() },
+        "aur":        func() error {
+            if devel {
+                return packagemanager.UpdateAURDevelPackages()
+            }
+            return packagemanager.UpdateAURPackages()
+        },
         "arch":       func() error { return packagemanager.UpdatePacmanPackages() },
         "flats":      func() error { return packagemanager.UpdateFlatpakPackages() },
     }
 
     updateOption := args[0]
+    if dryRun {
+        plan, err := packagemanager.PlanUpdate(updateOption, devel)
+        if err != nil {
+            fmt.Printf("Error planning '%s' update: %v\n", updateOption, err)
+            return
+        }
+        printDryRunPlan(plan)
+        return
+    }
+
     if updateFunc, ok := updateFuncs[updateOption]; ok {
         err := updateFunc()
         handleUpdateError(updateOption, err)
     } else {
-        err := packagemanager.UpdatePackageByName(updateOption)
+        err := packagemanager.UpdatePackageByName(updateOption, devel)
         handleUpdateError(updateOption, err)
     }
 }
 
 // handles the install command for packages
 func handleInstall(args []string) {
+    dryRun, args := extractFlag(args, "--dry-run")
+    skipValue, args := extractOption(args, "--skip")
+    skip := parseSkippedSteps(skipValue)
+
     if len(args) == 0 {
         fmt.Println("You must specify at least one package name.")
         return
@@ -109,14 +164,15 @@ func handleInstall(args []string) {
         "Snap":   packagemanager.InstallPackageSnap,
         "Flatpak": packagemanager.InstallPackageFlatpak,
         "AUR": func(pkgName string) error {
-            _, err := packagemanager.CloneAndInstallFromAUR(fmt.Sprintf("https://aur.archlinux.org/%s.git", pkgName), false)
+            report, err := packagemanager.RunAURBuild(pkgName, packagemanager.AURBuildOptions{Skip: skip})
+            printAURBuildReport(report)
             return err
         },
     }
 
     for _, result := range searchResults {
         fmt.Printf("Searching for package: %s\n", result.PackageName)
-        exactMatches := filterExactMatches(result.PackageName, result.Results)
+        exactMatches, candidates := resolveInstallMatches(result.PackageName, result.Results)
 
         if len(exactMatches) == 0 {
             fmt.Println("No exact matches found for package.")
@@ -138,19 +194,124 @@ func handleInstall(args []string) {
             }
         }
 
-        fmt.Printf("Installing %s from %s...\n", result.PackageName, selectedSource)
-        if installFunc, ok := installFuncs[selectedSource]; ok {
-            if err := installFunc(result.PackageName); err != nil {
-                fmt.Printf("Error installing package %s from %s: %v\n", result.PackageName, selectedSource, err)
+        // Names resolved through provides or groups install the concrete package the user picks
+        packageName := result.PackageName
+        var group *packagemanager.InstallCandidate
+        if sourceCandidates, resolved := candidates[selectedSource]; resolved {
+            candidate := chooseInstallCandidate(sourceCandidates)
+            if candidate == nil {
+                continue
+            }
+            packageName = candidate.Name
+            if candidate.Match == packagemanager.MatchGroup {
+                group = candidate
+            }
+        }
+
+        if dryRun {
+            var plan *packagemanager.DryRunPlan
+            var err error
+            if group != nil {
+                plan, err = packagemanager.PlanInstallGroup(*group)
             } else {
-                fmt.Printf("Package %s installed successfully from %s.\n", result.PackageName, selectedSource)
+                plan, err = packagemanager.PlanInstall(packageName, strings.ToLower(selectedSource))
+            }
+            if err != nil {
+                fmt.Printf("Error planning installation of %s: %v\n", packageName, err)
+                continue
+            }
+            printDryRunPlan(plan)
+            continue
+        }
+
+        fmt.Printf("Installing %s from %s...\n", packageName, selectedSource)
+        if group != nil {
+            if err := packagemanager.InstallPacmanGroup(*group); err != nil {
+                fmt.Printf("Error installing group %s from %s: %v\n", packageName, selectedSource, err)
+            } else {
+                fmt.Printf("Group %s installed successfully from %s.\n", packageName, selectedSource)
+            }
+        } else if installFunc, ok := installFuncs[selectedSource]; ok {
+            if err := installFunc(packageName); err != nil {
+                fmt.Printf("Error installing package %s from %s: %v\n", packageName, selectedSource, err)
+                printPGPHint(err)
+            } else {
+                fmt.Printf("Package %s installed successfully from %s.\n", packageName, selectedSource)
             }
         } else {
-            fmt.Printf("Unknown source for package %s\n", result.PackageName)
+            fmt.Printf("Unknown source for package %s\n", packageName)
         }
     }
 }
 
+// finds the sources a package can be installed from. Pacman and the AUR are resolved from their package
+// metadata, which understands provides and groups, the other sources are matched on their search results
+func resolveInstallMatches(packageName string, sourceResults []packagemanager.SourceResult) ([]packagemanager.SourceResult, map[string][]packagemanager.InstallCandidate) {
+    var matches []packagemanager.SourceResult
+    candidates := make(map[string][]packagemanager.InstallCandidate)
+
+    for _, sourceResult := range sourceResults {
+        var resolved []packagemanager.InstallCandidate
+        var err error
+        switch sourceResult.Source {
+        case "Pacman":
+            resolved, err = packagemanager.ResolvePacmanCandidates(packageName)
+        case "AUR":
+            resolved, err = packagemanager.ResolveAURCandidates(packageName)
+        default:
+            matches = append(matches, filterExactMatches(packageName, []packagemanager.SourceResult{sourceResult})...)
+            continue
+        }
+
+        if err != nil {
+            fmt.Printf("Error resolving %s in %s: %v\n", packageName, sourceResult.Source, err)
+            continue
+        }
+        if len(resolved) == 0 {
+            continue
+        }
+
+        var results []string
+        for _, candidate := range resolved {
+            results = append(results, formatInstallCandidate(packageName, candidate))
+        }
+        matches = append(matches, packagemanager.SourceResult{Source: sourceResult.Source, Results: results})
+        candidates[sourceResult.Source] = resolved
+    }
+    return matches, candidates
+}
+
+// formats an install candidate for display, saying how it satisfies the requested name
+func formatInstallCandidate(packageName string, candidate packagemanager.InstallCandidate) string {
+    switch candidate.Match {
+    case packagemanager.MatchProvides:
+        return fmt.Sprintf("%s %s (provides %s) - %s", candidate.Name, candidate.Version, packageName, candidate.Description)
+    case packagemanager.MatchGroup:
+        return fmt.Sprintf("%s (%s: %s)", candidate.Name, candidate.Description, strings.Join(candidate.Members, " "))
+    default:
+        return fmt.Sprintf("%s %s - %s", candidate.Name, candidate.Version, candidate.Description)
+    }
+}
+
+// lets the user pick one of several install candidates, returning nil for an invalid choice
+func chooseInstallCandidate(candidates []packagemanager.InstallCandidate) *packagemanager.InstallCandidate {
+    if len(candidates) == 1 {
+        return &candidates[0]
+    }
+
+    for i, candidate := range candidates {
+        fmt.Printf("%d: %s %s\n", i, candidate.Name, candidate.Version)
+    }
+    fmt.Print("Select the package number to install: ")
+    var choice int
+    fmt.Scan(&choice)
+    if choice < 0 || choice >= len(candidates) {
+        fmt.Println("Invalid selection. Skipping package.")
+        return nil
+    }
+    return &candidates[choice]
+}
+
 func getSelectedSource(exactMatches []packagemanager.SourceResult) string {
     if len(exactMatches) == 1 {
         return exactMatches[0].Source
@@ -190,6 +351,8 @@ func isExactMatch(packageName, result string) bool {
 
 // handles the uninstall command for packages
 func handleUninstall(args []string) {
+    dryRun, args := extractFlag(args, "--dry-run")
+
     if len(args) == 0 {
         fmt.Println("You must specify at least one package name.")
         return
@@ -204,6 +367,16 @@ func handleUninstall(args []string) {
         packageNames[i] = strings.TrimSpace(pkg)
     }
 
+    if dryRun {
+        plan, err := packagemanager.PlanUninstall(packageNames)
+        if err != nil {
+            fmt.Printf("Error planning uninstallation: %v\n", err)
+            return
+        }
+        printDryRunPlan(plan)
+        return
+    }
+
     // Call the function to uninstall the packages
     err := packagemanager.UninstallPackages(packageNames)
     if err != nil {
@@ -252,6 +425,10 @@ func handleSearch(args []string) {
 
 // handles the rebuild command for an AUR package
 func handleRebuild(args []string) {
+    dryRun, args := extractFlag(args, "--dry-run")
+    noBuild, args := extractFlag(args, "--no-build")
+    skipValue, args := extractOption(args, "--skip")
+
     if len(args) == 0 {
         fmt.Println("You must specify the name of an AUR package to rebuild.")
         return
@@ -270,30 +447,698 @@ func handleRebuild(args []string) {
         return
     }
 
-    cacheDir := filepath.Join(os.Getenv("HOME"), ".allpac", "cache", packageName)
-    if err := os.RemoveAll(cacheDir); err != nil {
-        fmt.Printf("Error removing old build directory: %v\n", err)
+    if dryRun {
+        var plan *packagemanager.DryRunPlan
+        if noBuild {
+            plan, err = packagemanager.PlanInstallCachedPackage(packageName, "")
+        } else {
+            plan, err = packagemanager.PlanRebuild(packageName)
+        }
+        if err != nil {
+            fmt.Printf("Error planning rebuild of %s: %v\n", packageName, err)
+            return
+        }
+        printDryRunPlan(plan)
         return
     }
 
-    err = packagemanager.RebuildAndReinstallAURPackage(packageName)
+    // With --no-build the recorded version is reinstalled from the package cache
+    if noBuild {
+        err = packagemanager.ReinstallCachedAURPackage(packageName)
+    } else {
+        var report *packagemanager.AURBuildReport
+        report, err = packagemanager.RebuildAURPackage(packageName, parseSkippedSteps(skipValue))
+        printAURBuildReport(report)
+    }
     if err != nil {
         fmt.Printf("Error rebuilding package %s: %v\n", packageName, err)
+        printPGPHint(err)
     } else {
-        fmt.Printf("Package %s rebuilt and reinstalled successfully.\n", packageName)
+        fmt.Printf("Package %s rebuilt successfully.\n", packageName)
+    }
+}
+
+// handles the downgrade command, installing an earlier version from a package cache
+func handleDowngrade(args []string) {
+    dryRun, args := extractFlag(args, "--dry-run")
+
+    if len(args) == 0 {
+        fmt.Println("You must specify a package name, and the version to downgrade to.")
+        return
+    }
+    packageName := args[0]
+
+    // Without a version, show which versions of an AUR package are cached
+    if len(args) < 2 {
+        cached, err := packagemanager.ListCachedAURPackages(packageName)
+        if err != nil {
+            fmt.Printf("Error reading the package cache: %v\n", err)
+            return
+        }
+        if len(cached) == 0 {
+            fmt.Printf("No built versions of %s are cached.\n", packageName)
+            return
+        }
+        fmt.Printf("Cached versions of %s:\n", packageName)
+        for _, pkg := range cached {
+            fmt.Printf("  %-24s %-10s %s\n", pkg.Version, formatSize(pkg.Size), pkg.Time.Format("2006-01-02 15:04"))
+        }
+        return
+    }
+    version := args[1]
+
+    if dryRun {
+        plan, err := packagemanager.PlanInstallCachedPackage(packageName, version)
+        if err != nil {
+            fmt.Printf("Error planning downgrade of %s: %v\n", packageName, err)
+            return
+        }
+        printDryRunPlan(plan)
+        return
+    }
+
+    if err := packagemanager.DowngradePackage(packageName, version); err != nil {
+        fmt.Printf("Error downgrading %s: %v\n", packageName, err)
+        return
     }
+    fmt.Printf("Package %s is now at version %s. Hold it with 'allpac hold %s' to keep updates from replacing it.\n", packageName, version, packageName)
 }
 
-// handles the cleaning of AUR cache
+// handles the cleaning of AUR cache. With --keep or --max-age, only the cached package archives are pruned
 func handleCleanAur(args []string) {
-    // Call the function to clear the AUR cache
-    err := packagemanager.ClearAllPacCache()
+    all, args := extractFlag(args, "--all")
+    orphans, args := extractFlag(args, "--orphans")
+    buildTrees, args := extractFlag(args, "--build-trees")
+    keepValue, args := extractOption(args, "--keep")
+    maxAgeValue, _ := extractOption(args, "--max-age")
+
+    if all {
+        // Call the function to clear the AUR cache
+        err := packagemanager.ClearAllPacCache()
+        if err != nil {
+            fmt.Printf("Error clearing AllPac cache: %v\n", err)
+            return
+        }
+
+        fmt.Println("AllPac cache cleared successfully.")
+        return
+    }
+
+    // Without a policy, only report what the caches hold
+    if !orphans && !buildTrees && keepValue == "" && maxAgeValue == "" {
+        printAURCacheReport()
+        return
+    }
+
+    keep, maxAgeDays := 0, 0
+    var err error
+    if keepValue != "" {
+        if keep, err = strconv.Atoi(keepValue); err != nil || keep < 0 {
+            fmt.Printf("Invalid --keep value %q, expected a number of builds.\n", keepValue)
+            return
+        }
+    }
+    if maxAgeValue != "" {
+        if maxAgeDays, err = strconv.Atoi(maxAgeValue); err != nil || maxAgeDays < 0 {
+            fmt.Printf("Invalid --max-age value %q, expected a number of days.\n", maxAgeValue)
+            return
+        }
+    }
+
+    var freed int64
+    if orphans {
+        removed, err := packagemanager.RemoveOrphanedAURClones()
+        for _, removal := range removed {
+            fmt.Printf("Removed clone %s (%s)\n", removal.Name, formatSize(removal.Size))
+            freed += removal.Size
+        }
+        if err != nil {
+            fmt.Printf("Error removing orphaned clones: %v\n", err)
+            return
+        }
+    }
+
+    if buildTrees {
+        removed, err := packagemanager.RemoveAURBuildTrees()
+        for _, removal := range removed {
+            fmt.Printf("Removed %s/%s (%s)\n", removal.Name, filepath.Base(removal.Path), formatSize(removal.Size))
+            freed += removal.Size
+        }
+        if err != nil {
+            fmt.Printf("Error removing build trees: %v\n", err)
+            return
+        }
+    }
+
+    if keepValue != "" || maxAgeValue != "" {
+        removed, err := packagemanager.PruneAURPackageCache(keep, time.Duration(maxAgeDays)*24*time.Hour)
+        for _, pkg := range removed {
+            fmt.Printf("Removed %s %s (%s)\n", pkg.Name, pkg.Version, formatSize(pkg.Size))
+            freed += pkg.Size
+        }
+        if err != nil {
+            fmt.Printf("Error pruning the package cache: %v\n", err)
+            return
+        }
+    }
+
+    fmt.Printf("Freed %s.\n", formatSize(freed))
+}
+
+// prints the disk space every package takes in AllPac's caches
+func printAURCacheReport() {
+    report, err := packagemanager.AURCacheReport()
+    if err != nil {
+        fmt.Printf("Error reading the AllPac cache: %v\n", err)
+        return
+    }
+    if len(report) == 0 {
+        fmt.Println("The AllPac cache is empty.")
+        return
+    }
+
+    var clones, buildTrees, packages int64
+    fmt.Printf("%-30s %10s %12s %12s %7s %10s\n", "PACKAGE", "CLONE", "BUILD TREES", "PACKAGES", "BUILDS", "TOTAL")
+    for _, usage := range report {
+        name := usage.Name
+        if !usage.Managed {
+            name += " (orphaned)"
+        }
+        fmt.Printf("%-30s %10s %12s %12s %7d %10s\n", name, formatSize(usage.CloneSize), formatSize(usage.BuildTreeSize),
+            formatSize(usage.PackagesSize), usage.Builds, formatSize(usage.Total()))
+        clones += usage.CloneSize
+        buildTrees += usage.BuildTreeSize
+        packages += usage.PackagesSize
+    }
+    fmt.Printf("%-30s %10s %12s %12s %7s %10s\n", "TOTAL", formatSize(clones), formatSize(buildTrees),
+        formatSize(packages), "", formatSize(clones+buildTrees+packages))
+}
+
+// handles the adopt command, importing already installed packages into the package list
+func handleAdopt(args []string) {
+    assumeYes, args := extractFlag(args, "--yes")
+
+    // Any remaining arguments limit adoption to the given sources
+    sources := make(map[string]bool)
+    for _, source := range args {
+        sources[strings.ToLower(source)] = true
+    }
+
+    candidates, err := packagemanager.FindAdoptablePackages()
+    if err != nil {
+        fmt.Printf("Error finding installed packages: %v\n", err)
+        return
+    }
+
+    var toAdopt []packagemanager.SystemPackage
+    for _, pkg := range candidates {
+        if len(sources) == 0 || sources[pkg.Source] {
+            toAdopt = append(toAdopt, pkg)
+        }
+    }
+
+    if len(toAdopt) == 0 {
+        fmt.Println("No installed packages found that are not already managed by AllPac.")
+        return
+    }
+
+    fmt.Println("The following packages will be adopted:")
+    for _, pkg := range toAdopt {
+        fmt.Printf("  %-8s %s %s\n", pkg.Source, pkg.Name, pkg.Version)
+    }
+
+    if !assumeYes && !packagemanager.ConfirmAction(fmt.Sprintf("Adopt %d packages into AllPac?", len(toAdopt))) {
+        fmt.Println("Adoption aborted.")
+        return
+    }
+
+    if err := packagemanager.AdoptPackages(toAdopt); err != nil {
+        fmt.Printf("Error adopting packages: %v\n", err)
+        return
+    }
+    fmt.Printf("%d packages adopted successfully.\n", len(toAdopt))
+}
+
+// handles the check command, reporting drift between the package list and the system
+func handleCheck(args []string) {
+    fix, _ := extractFlag(args, "--fix")
+
+    issues, err := packagemanager.CheckPackageDrift()
+    if err != nil {
+        fmt.Printf("Error checking packages: %v\n", err)
+        return
+    }
+
+    if len(issues) == 0 {
+        fmt.Println("The package list matches the installed packages.")
+        return
+    }
+
+    for _, issue := range issues {
+        switch issue.Kind {
+        case packagemanager.DriftMissing:
+            fmt.Printf("%s: recorded as %s %s but not installed\n", issue.Name, issue.Recorded.Source, issue.Recorded.Version)
+        case packagemanager.DriftVersion:
+            fmt.Printf("%s: recorded version %s but %s is installed\n", issue.Name, issue.Recorded.Version, issue.Installed.Version)
+        case packagemanager.DriftSource:
+            fmt.Printf("%s: recorded from %s but installed from %s\n", issue.Name, issue.Recorded.Source, issue.Installed.Source)
+        }
+    }
+
+    if !fix {
+        fmt.Printf("%d packages have drifted. Run 'allpac check --fix' to update the package list.\n", len(issues))
+        return
+    }
+
+    if err := packagemanager.FixPackageDrift(issues); err != nil {
+        fmt.Printf("Error fixing package list: %v\n", err)
+        return
+    }
+    fmt.Printf("Package list updated for %d packages.\n", len(issues))
+}
+
+// handles the state command, listing and restoring package list backups
+func handleState(args []string) {
+    if len(args) == 0 {
+        fmt.Println("You must specify a state option: 'backups' or 'restore <id>'.")
+        return
+    }
+
+    switch args[0] {
+    case "backups":
+        backups, err := packagemanager.ListBackups()
+        if err != nil {
+            fmt.Printf("Error listing backups: %v\n", err)
+            return
+        }
+        if len(backups) == 0 {
+            fmt.Println("No package list backups found.")
+            return
+        }
+        for _, backup := range backups {
+            fmt.Printf("%-20s %s  %d bytes\n", backup.ID, backup.Time.Format("2006-01-02 15:04:05"), backup.Size)
+        }
+    case "restore":
+        if len(args) < 2 {
+            fmt.Println("You must specify the ID of the backup to restore, see 'allpac state backups'.")
+            return
+        }
+        if err := packagemanager.RestoreBackup(args[1]); err != nil {
+            fmt.Printf("Error restoring backup: %v\n", err)
+            return
+        }
+        fmt.Printf("Package list restored from backup %s.\n", args[1])
+    default:
+        fmt.Printf("Unknown state option: %s\n", args[0])
+    }
+}
+
+// handles the sync command, converging the system with a manifest
+func handleSync(args []string) {
+    dryRun, args := extractFlag(args, "--dry-run")
+    prune, args := extractFlag(args, "--prune")
+    assumeYes, args := extractFlag(args, "--yes")
+    locked, args := extractFlag(args, "--locked")
+
+    if locked {
+        handleLockedSync(args, dryRun, assumeYes)
+        return
+    }
+
+    manifestPath := "allpac.json"
+    if len(args) > 0 {
+        manifestPath = args[0]
+    }
+
+    manifest, err := packagemanager.ReadManifest(manifestPath)
+    if err != nil {
+        fmt.Printf("Error reading manifest: %v\n", err)
+        return
+    }
+
+    plan, err := packagemanager.PlanSync(manifest, prune)
     if err != nil {
-        fmt.Printf("Error clearing AllPac cache: %v\n", err)
+        fmt.Printf("Error planning sync: %v\n", err)
+        return
+    }
+
+    if len(plan.Steps) == 0 {
+        fmt.Printf("The system is in sync with %s.\n", manifestPath)
+        return
+    }
+
+    fmt.Println("Sync plan:")
+    for _, step := range plan.Steps {
+        switch step.Action {
+        case packagemanager.SyncReplace:
+            fmt.Printf("  replace %s (%s -> %s)\n", step.Package.Name, step.Current.Source, step.Source)
+        case packagemanager.SyncPin:
+            fmt.Printf("  pin     %s (%s %s -> %s)\n", step.Package.Name, step.Source, step.Current.Version, step.Package.Version)
+        default:
+            fmt.Printf("  %-7s %s (%s)\n", step.Action, step.Package.Name, step.Source)
+        }
+    }
+    fmt.Printf("%d packages are already in sync.\n", len(plan.Unchanged))
+
+    if dryRun {
         return
     }
 
-    fmt.Println("AllPac cache cleared successfully.")
+    if !assumeYes && !packagemanager.ConfirmAction("Apply this plan?") {
+        fmt.Println("Sync aborted.")
+        return
+    }
+
+    if err := packagemanager.ApplySync(plan, assumeYes); err != nil {
+        fmt.Printf("Error syncing packages: %v\n", err)
+        return
+    }
+    fmt.Println("System synced successfully.")
+}
+
+// handles sync --locked, installing the exact versions pinned in a lockfile
+func handleLockedSync(args []string, dryRun, assumeYes bool) {
+    lockfilePath := "allpac.lock"
+    if len(args) > 0 {
+        lockfilePath = args[0]
+    }
+
+    lockfile, err := packagemanager.ReadLockfile(lockfilePath)
+    if err != nil {
+        fmt.Printf("Error reading lockfile: %v\n", err)
+        return
+    }
+
+    pending, err := packagemanager.PlanLockedSync(lockfile)
+    if err != nil {
+        fmt.Printf("Error planning sync: %v\n", err)
+        return
+    }
+
+    if len(pending) == 0 {
+        fmt.Printf("The system matches %s.\n", lockfilePath)
+        return
+    }
+
+    fmt.Println("The following packages will be installed at their locked versions:")
+    for _, pkg := range pending {
+        fmt.Printf("  %-8s %s %s\n", pkg.Source, pkg.Name, pkg.Version)
+    }
+
+    if dryRun {
+        return
+    }
+
+    if !assumeYes && !packagemanager.ConfirmAction("Install these packages?") {
+        fmt.Println("Sync aborted.")
+        return
+    }
+
+    if err := packagemanager.ApplyLockedSync(pending, assumeYes); err != nil {
+        fmt.Printf("Error syncing packages: %v\n", err)
+        os.Exit(1)
+    }
+    fmt.Println("System synced to the lockfile successfully.")
+}
+
+// handles the lock command, writing the exact version of every managed package to a lockfile
+func handleLock(args []string) {
+    lockfilePath := "allpac.lock"
+    if len(args) > 0 {
+        lockfilePath = args[0]
+    }
+
+    lockfile, err := packagemanager.GenerateLockfile()
+    if err != nil {
+        fmt.Printf("Error generating lockfile: %v\n", err)
+        return
+    }
+
+    if err := packagemanager.WriteLockfile(lockfilePath, lockfile); err != nil {
+        fmt.Printf("Error writing lockfile: %v\n", err)
+        return
+    }
+    fmt.Printf("Locked %d packages in %s.\n", len(lockfile.Packages), lockfilePath)
+}
+
+// handles the export command, writing every managed package to a portable manifest
+func handleExport(args []string) {
+    includeVersions, args := extractFlag(args, "--versions")
+    format, args := extractOption(args, "--format")
+
+    outputPath := ""
+    if len(args) > 0 {
+        outputPath = args[0]
+    }
+    if format == "" {
+        format = packagemanager.ManifestFormatForPath(outputPath)
+    }
+
+    manifest, err := packagemanager.ExportManifest(includeVersions)
+    if err != nil {
+        fmt.Printf("Error exporting packages: %v\n", err)
+        return
+    }
+
+    data, err := packagemanager.EncodeManifest(manifest, format)
+    if err != nil {
+        fmt.Printf("Error exporting packages: %v\n", err)
+        return
+    }
+
+    // Without an output file the export goes to stdout, so it can be piped elsewhere
+    if outputPath == "" {
+        fmt.Print(string(data))
+        return
+    }
+
+    if err := os.WriteFile(outputPath, data, 0644); err != nil {
+        fmt.Printf("Error writing export file: %v\n", err)
+        return
+    }
+    fmt.Printf("Exported %d packages to %s.\n", len(manifest.Entries()), outputPath)
+}
+
+// handles the import command, installing the packages of an exported manifest that are missing
+func handleImport(args []string) {
+    assumeYes, args := extractFlag(args, "--yes")
+
+    if len(args) == 0 {
+        fmt.Println("You must specify the file to import.")
+        return
+    }
+
+    manifest, err := packagemanager.ReadManifest(args[0])
+    if err != nil {
+        fmt.Printf("Error reading import file: %v\n", err)
+        return
+    }
+
+    report, err := packagemanager.ImportManifest(manifest, assumeYes)
+    if err != nil {
+        fmt.Printf("Error importing packages: %v\n", err)
+    }
+    if report == nil {
+        return
+    }
+
+    fmt.Printf("Installed: %d, already managed: %d, already installed and adopted: %d\n", len(report.Installed), len(report.Skipped), len(report.Adopted))
+    var failed []string
+    for name := range report.Failed {
+        failed = append(failed, name)
+    }
+    sort.Strings(failed)
+    for _, name := range failed {
+        fmt.Printf("Failed to install %s: %v\n", name, report.Failed[name])
+    }
+    if len(report.Unresolved) > 0 {
+        fmt.Printf("Could not find these packages in their recorded source: %s\n", strings.Join(report.Unresolved, ", "))
+    }
+}
+
+// handles the list command, showing every package managed by AllPac
+func handleList(args []string) {
+    asJSON, args := extractFlag(args, "--json")
+    heldOnly, args := extractFlag(args, "--held")
+    explicitOnly, args := extractFlag(args, "--explicit")
+    depsOnly, args := extractFlag(args, "--deps")
+    source, args := extractOption(args, "--source")
+    sortBy, _ := extractOption(args, "--sort")
+
+    if sortBy != "" && sortBy != "name" && sortBy != "date" && sortBy != "size" {
+        fmt.Println("You can only sort by 'name', 'date' or 'size'.")
+        return
+    }
+    source = strings.ToLower(source)
+    if source != "" && source != "pacman" && source != "aur" && source != "snap" && source != "flatpak" {
+        fmt.Println("You can only list the sources 'pacman', 'aur', 'snap' or 'flatpak'.")
+        return
+    }
+
+    packages, err := packagemanager.ListManagedPackages(sortBy)
+    if err != nil {
+        fmt.Printf("Error listing packages: %v\n", err)
+        return
+    }
+
+    var filtered []packagemanager.ManagedPackage
+    for _, pkg := range packages {
+        if source != "" && pkg.Source != source {
+            continue
+        }
+        if (heldOnly && !pkg.Held) || (explicitOnly && !pkg.Explicit) || (depsOnly && pkg.Explicit) {
+            continue
+        }
+        filtered = append(filtered, pkg)
+    }
+
+    if asJSON {
+        printJSON(filtered)
+        return
+    }
+
+    if len(filtered) == 0 {
+        fmt.Println("No managed packages match.")
+        return
+    }
+
+    fmt.Printf("%-40s %-8s %-24s %-10s %-10s %s\n", "NAME", "SOURCE", "VERSION", "INSTALLED", "SIZE", "FLAGS")
+    for _, pkg := range filtered {
+        var flags []string
+        if pkg.Held {
+            flags = append(flags, "held")
+        }
+        if !pkg.Explicit {
+            flags = append(flags, "dependency")
+        }
+
+        installed := "-"
+        if !pkg.InstallDate.IsZero() {
+            installed = pkg.InstallDate.Format("2006-01-02")
+        }
+        fmt.Printf("%-40s %-8s %-24s %-10s %-10s %s\n", pkg.Name, pkg.Source, pkg.Version, installed, formatSize(pkg.Size), strings.Join(flags, ","))
+    }
+}
+
+// handles the info command, showing recorded and live details of a managed package
+func handleInfo(args []string) {
+    asJSON, args := extractFlag(args, "--json")
+
+    if len(args) == 0 {
+        fmt.Println("You must specify a package name.")
+        return
+    }
+
+    details, err := packagemanager.GetPackageDetails(args[0])
+    if err != nil {
+        fmt.Printf("Error getting package details: %v\n", err)
+        return
+    }
+
+    if asJSON {
+        printJSON(details)
+        return
+    }
+
+    fmt.Println("Recorded by AllPac:")
+    fmt.Printf("  %-16s: %s\n", "Source", details.Recorded.Source)
+    fmt.Printf("  %-16s: %s\n", "Version", details.Recorded.Version)
+    fmt.Printf("  %-16s: %t\n", "Held", details.Recorded.Held)
+    if details.Recorded.Source == "aur" {
+        fmt.Printf("  %-16s: %t\n", "Trusted", details.Recorded.Trusted)
+    }
+    if details.Recorded.InstalledAt != "" {
+        fmt.Printf("  %-16s: %s\n", "Installed At", details.Recorded.InstalledAt)
+    }
+    if details.Recorded.Commit != "" {
+        fmt.Printf("  %-16s: %s\n", "Built Commit", details.Recorded.Commit)
+    }
+
+    printDetailFields(fmt.Sprintf("Installed (%s):", details.Recorded.Source), details.Live)
+    printDetailFields("AUR:", details.AUR)
+}
+
+// how old the pacman sync databases may get before outdated notes it
+const staleSyncDatabaseAge = 24 * time.Hour
+
+// handles the outdated command, listing available updates without applying them.
+// like pacman's checkupdates, it exits with 0 when updates are available, 2 when there are none and 1 on errors
+func handleOutdated(args []string) {
+    outdated, err := packagemanager.FindOutdatedPackages()
+    if err != nil {
+        fmt.Printf("Error checking for updates: %v\n", err)
+        os.Exit(1)
+    }
+
+    // Pacman packages are checked against the sync databases as they are, so say when they're old
+    if age, err := packagemanager.PacmanSyncDatabaseAge(); err == nil && age > staleSyncDatabaseAge {
+        fmt.Fprintf(os.Stderr, "The pacman sync databases were last refreshed %d days ago, so newer repository updates may be missing. Run 'allpac update arch' to refresh them.\n", int(age.Hours()/24))
+    }
+
+    updatesAvailable := false
+    for _, pkg := range outdated {
+        held := ""
+        if pkg.Held {
+            held = "[held]"
+        } else {
+            updatesAvailable = true
+        }
+        fmt.Printf("%-40s %-8s %s -> %s %s\n", pkg.Name, pkg.Source, pkg.InstalledVersion, pkg.AvailableVersion, held)
+    }
+
+    if !updatesAvailable {
+        os.Exit(2)
+    }
+}
+
+// handles the hold and unhold commands
+func handleHold(args []string, hold bool) {
+    if len(args) == 0 {
+        fmt.Println("You must specify at least one package name.")
+        return
+    }
+
+    for _, packageName := range args {
+        var err error
+        if hold {
+            err = packagemanager.HoldPackage(packageName)
+        } else {
+            err = packagemanager.UnholdPackage(packageName)
+        }
+
+        if err != nil {
+            fmt.Printf("Error updating hold on %s: %v\n", packageName, err)
+        } else if hold {
+            fmt.Printf("Package %s is now held and will be skipped by updates.\n", packageName)
+        } else {
+            fmt.Printf("Package %s is no longer held.\n", packageName)
+        }
+    }
+}
+
+// handles the trust and untrust commands for AUR packages
+func handleTrust(args []string, trust bool) {
+    if len(args) == 0 {
+        fmt.Println("You must specify at least one package name.")
+        return
+    }
+
+    for _, packageName := range args {
+        var err error
+        if trust {
+            err = packagemanager.TrustPackage(packageName)
+        } else {
+            err = packagemanager.UntrustPackage(packageName)
+        }
+
+        if err != nil {
+            fmt.Printf("Error updating trust of %s: %v\n", packageName, err)
+        } else if trust {
+            fmt.Printf("Package %s is now trusted and will be built without a review.\n", packageName)
+        } else {
+            fmt.Printf("Package %s is no longer trusted.\n", packageName)
+        }
+    }
 }
 
 // prompts the user to select a source for installation
@@ -0,0 +1,261 @@
+package alpmdb
+
+// This package is responsible for reading pacman's databases directly, without spawning pacman.
+// It understands the same files libalpm does: the local database of installed packages, one directory
+// with a desc file per package, and the sync databases, one tar archive per repository.
+// It only ever reads, anything that changes the system still goes through pacman itself
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// the database path pacman uses unless pacman.conf says otherwise
+const DefaultDBPath = "/var/lib/pacman"
+
+// the pacman configuration pacman reads unless it's given --config
+const DefaultConfPath = "/etc/pacman.conf"
+
+// the repository name given to packages from the local database
+const LocalRepository = "local"
+
+// ErrNotFound is returned when a package isn't in the database that was searched
+var ErrNotFound = errors.New("package not found")
+
+// InstallReason records why a package was installed
+type InstallReason int
+
+const (
+    // the package was installed explicitly
+    ReasonExplicit InstallReason = 0
+    // the package was installed as a dependency of another package
+    ReasonDependency InstallReason = 1
+)
+
+// Package represents a package entry of a pacman database
+type Package struct {
+    Name          string
+    Base          string
+    Version       string
+    Description   string
+    URL           string
+    Arch          string
+    Packager      string
+    Licenses      []string
+    Groups        []string
+    Provides      []string
+    Depends       []string
+    OptDepends    []string
+    MakeDepends   []string
+    CheckDepends  []string
+    Conflicts     []string
+    Replaces      []string
+    DownloadSize  int64
+    InstalledSize int64
+    BuildDate     time.Time
+    // only set for installed packages
+    InstallDate time.Time
+    Reason      InstallReason
+    // only set for packages from a sync database
+    Filename string
+    // the sync database the package comes from, or LocalRepository for installed packages
+    Repository string
+}
+
+// Database represents the packages of a single database
+type Database struct {
+    Name     string
+    Packages []*Package
+    byName   map[string]*Package
+}
+
+// returns the package with the given name, or nil if the database doesn't have it
+func (db *Database) Package(name string) *Package {
+    return db.byName[name]
+}
+
+// returns the packages that provide the given name, either by being called that or through their provides
+func (db *Database) Providers(name string) []*Package {
+    var providers []*Package
+    for _, pkg := range db.Packages {
+        if pkg.Name == name || pkg.ProvidesName(name) {
+            providers = append(providers, pkg)
+        }
+    }
+    return providers
+}
+
+// returns the packages that are members of the given group
+func (db *Database) Group(name string) []*Package {
+    var members []*Package
+    for _, pkg := range db.Packages {
+        for _, group := range pkg.Groups {
+            if group == name {
+                members = append(members, pkg)
+                break
+            }
+        }
+    }
+    return members
+}
+
+func newDatabase(name string, packages []*Package) *Database {
+    db := &Database{Name: name, Packages: packages, byName: make(map[string]*Package, len(packages))}
+    for _, pkg := range packages {
+        pkg.Repository = name
+        db.byName[pkg.Name] = pkg
+    }
+    return db
+}
+
+// reports whether the package provides the given name, ignoring the provided version
+func (p *Package) ProvidesName(name string) bool {
+    for _, provide := range p.Provides {
+        if provideName, _ := SplitDependency(provide); provideName == name {
+            return true
+        }
+    }
+    return false
+}
+
+// splits a dependency like "python>=3.11" or "sh" into its name and version constraint.
+// Optional dependencies have their description stripped
+func SplitDependency(dependency string) (string, string) {
+    if name, _, found := strings.Cut(dependency, ": "); found {
+        dependency = name
+    }
+    if index := strings.IndexAny(dependency, "<>="); index >= 0 {
+        return dependency[:index], dependency[index:]
+    }
+    return dependency, ""
+}
+
+// Handle reads the databases below a pacman database path. Parsed sync databases are kept
+// until their file changes, so repeated lookups don't read the archives again
+type Handle struct {
+    // the database directory, DefaultDBPath on a normal system
+    DBPath string
+    // the sync databases in the order pacman searches them. When empty, every database
+    // in the sync directory is used in alphabetical order
+    Repositories []string
+
+    mutex     sync.Mutex
+    syncCache map[string]cachedDatabase
+}
+
+type cachedDatabase struct {
+    modTime time.Time
+    size    int64
+    db      *Database
+}
+
+// creates a handle for the databases below the given database path
+func NewHandle(dbPath string) *Handle {
+    return &Handle{DBPath: dbPath, syncCache: make(map[string]cachedDatabase)}
+}
+
+// parses a desc file (or the depends file of older sync databases) into the given package
+func parseDesc(reader io.Reader, pkg *Package) error {
+    scanner := bufio.NewScanner(reader)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+    section := ""
+    for scanner.Scan() {
+        line := scanner.Text()
+        if line == "" {
+            section = ""
+            continue
+        }
+        if strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%") && len(line) > 2 {
+            section = line
+            continue
+        }
+
+        switch section {
+        case "%NAME%":
+            pkg.Name = line
+        case "%BASE%":
+            pkg.Base = line
+        case "%VERSION%":
+            pkg.Version = line
+        case "%DESC%":
+            pkg.Description = line
+        case "%URL%":
+            pkg.URL = line
+        case "%ARCH%":
+            pkg.Arch = line
+        case "%PACKAGER%":
+            pkg.Packager = line
+        case "%FILENAME%":
+            pkg.Filename = line
+        case "%LICENSE%":
+            pkg.Licenses = append(pkg.Licenses, line)
+        case "%GROUPS%":
+            pkg.Groups = append(pkg.Groups, line)
+        case "%PROVIDES%":
+            pkg.Provides = append(pkg.Provides, line)
+        case "%DEPENDS%":
+            pkg.Depends = append(pkg.Depends, line)
+        case "%OPTDEPENDS%":
+            pkg.OptDepends = append(pkg.OptDepends, line)
+        case "%MAKEDEPENDS%":
+            pkg.MakeDepends = append(pkg.MakeDepends, line)
+        case "%CHECKDEPENDS%":
+            pkg.CheckDepends = append(pkg.CheckDepends, line)
+        case "%CONFLICTS%":
+            pkg.Conflicts = append(pkg.Conflicts, line)
+        case "%REPLACES%":
+            pkg.Replaces = append(pkg.Replaces, line)
+        case "%CSIZE%":
+            pkg.DownloadSize = parseInt(line)
+        case "%ISIZE%", "%SIZE%":
+            pkg.InstalledSize = parseInt(line)
+        case "%BUILDDATE%":
+            pkg.BuildDate = parseTimestamp(line)
+        case "%INSTALLDATE%":
+            pkg.InstallDate = parseTimestamp(line)
+        case "%REASON%":
+            pkg.Reason = InstallReason(parseInt(line))
+        }
+    }
+
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("error parsing package description: %v", err)
+    }
+    return nil
+}
+
+func parseInt(value string) int64 {
+    parsed, err := strconv.ParseInt(value, 10, 64)
+    if err != nil {
+        return 0
+    }
+    return parsed
+}
+
+func parseTimestamp(value string) time.Time {
+    seconds := parseInt(value)
+    if seconds == 0 {
+        return time.Time{}
+    }
+    return time.Unix(seconds, 0)
+}
+
+// splits a database entry name like "linux-6.6.10.arch1-1" into the package name and version
+func splitEntryName(entry string) (string, string) {
+    relIndex := strings.LastIndex(entry, "-")
+    if relIndex <= 0 {
+        return entry, ""
+    }
+    verIndex := strings.LastIndex(entry[:relIndex], "-")
+    if verIndex <= 0 {
+        return entry, ""
+    }
+    return entry[:verIndex], entry[verIndex+1:]
+}
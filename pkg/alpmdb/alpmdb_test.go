@@ -0,0 +1,154 @@
+package alpmdb
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseDesc(t *testing.T) {
+    tests := []struct {
+        name string
+        desc string
+        want Package
+    }{
+        {
+            name: "sync entry",
+            desc: "%FILENAME%\ngit-2.43.0-1-x86_64.pkg.tar.zst\n\n%NAME%\ngit\n\n%VERSION%\n2.43.0-1\n\n" +
+                "%CSIZE%\n6743130\n\n%ISIZE%\n35282945\n\n%BUILDDATE%\n1700514189\n\n" +
+                "%DEPENDS%\ncurl\nperl>=5.14.0\n\n%OPTDEPENDS%\ntk: gitk and git gui\n\n",
+            want: Package{
+                Name:          "git",
+                Version:       "2.43.0-1",
+                Filename:      "git-2.43.0-1-x86_64.pkg.tar.zst",
+                DownloadSize:  6743130,
+                InstalledSize: 35282945,
+                BuildDate:     time.Unix(1700514189, 0),
+                Depends:       []string{"curl", "perl>=5.14.0"},
+                OptDepends:    []string{"tk: gitk and git gui"},
+            },
+        },
+        {
+            name: "local entry",
+            desc: "%NAME%\nzsh\n\n%VERSION%\n5.9-4\n\n%SIZE%\n8117861\n\n%INSTALLDATE%\n1700600000\n\n%REASON%\n1\n\n" +
+                "%GROUPS%\nbase\nshells\n\n%LICENSE%\ncustom\n",
+            want: Package{
+                Name:          "zsh",
+                Version:       "5.9-4",
+                InstalledSize: 8117861,
+                InstallDate:   time.Unix(1700600000, 0),
+                Reason:        ReasonDependency,
+                Groups:        []string{"base", "shells"},
+                Licenses:      []string{"custom"},
+            },
+        },
+        {
+            name: "unknown sections and bad numbers",
+            desc: "%NAME%\nfoo\n\n%VALIDATION%\npgp\n\n%CSIZE%\nlots\n\n%BUILDDATE%\n0\n\n%REPLACES%\nbar\n",
+            want: Package{Name: "foo", Replaces: []string{"bar"}},
+        },
+        {
+            name: "values before any section",
+            desc: "stray\n%NAME%\nfoo\n",
+            want: Package{Name: "foo"},
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var pkg Package
+            if err := parseDesc(strings.NewReader(test.desc), &pkg); err != nil {
+                t.Fatalf("parseDesc() error = %v", err)
+            }
+            if !reflect.DeepEqual(pkg, test.want) {
+                t.Errorf("parseDesc() = %+v, want %+v", pkg, test.want)
+            }
+        })
+    }
+}
+
+func TestReadSyncArchive(t *testing.T) {
+    archives := []string{"test.db.tar", "test.db.tar.gz", "test.db.tar.bz2", "test.db.tar.xz", "test.db.tar.zst"}
+
+    for _, archive := range archives {
+        t.Run(archive, func(t *testing.T) {
+            packages, err := readSyncArchive(filepath.Join("testdata", archive))
+            if err != nil {
+                t.Fatalf("readSyncArchive() error = %v", err)
+            }
+
+            var names []string
+            for _, pkg := range packages {
+                names = append(names, pkg.Name+" "+pkg.Version)
+            }
+            want := []string{"git 2.43.0-1", "pacman 6.0.2-9", "zsh 5.9-4"}
+            if !reflect.DeepEqual(names, want) {
+                t.Fatalf("readSyncArchive() packages = %q, want %q", names, want)
+            }
+
+            git := packages[0]
+            if git.Base != "git" || git.DownloadSize != 6743130 || !reflect.DeepEqual(git.Provides, []string{"git-core"}) {
+                t.Errorf("readSyncArchive() git = %+v", git)
+            }
+            // Older databases keep the dependencies of zsh in a separate depends file
+            zsh := packages[2]
+            if !reflect.DeepEqual(zsh.Depends, []string{"pcre", "gdbm"}) || !reflect.DeepEqual(zsh.Conflicts, []string{"zsh-git"}) {
+                t.Errorf("readSyncArchive() zsh depends = %q, conflicts = %q", zsh.Depends, zsh.Conflicts)
+            }
+        })
+    }
+}
+
+func TestReadSyncArchiveCorrupt(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "broken.db")
+    // A zstd frame header followed by garbage
+    if err := os.WriteFile(path, []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x58, 0xff, 0xff, 0xff}, 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := readSyncArchive(path); err == nil {
+        t.Error("readSyncArchive() of a corrupt database succeeded")
+    }
+}
+
+func TestSplitEntryName(t *testing.T) {
+    tests := []struct {
+        entry, name, version string
+    }{
+        {"linux-6.6.10.arch1-1", "linux", "6.6.10.arch1-1"},
+        {"python-requests-2.31.0-1", "python-requests", "2.31.0-1"},
+        {"noversion", "noversion", ""},
+        {"half-1", "half-1", ""},
+    }
+    for _, test := range tests {
+        name, version := splitEntryName(test.entry)
+        if name != test.name || version != test.version {
+            t.Errorf("splitEntryName(%q) = %q, %q, want %q, %q", test.entry, name, version, test.name, test.version)
+        }
+    }
+}
+
+func TestParsePacmanConfig(t *testing.T) {
+    conf := "#\n# /etc/pacman.conf\n#\n[options]\n#DBPath = /commented/out\nRootDir = /\nDBPath  = /srv/pacman/db/\n" +
+        "HoldPkg = pacman glibc\nCheckSpace\n\n[core]\nInclude = /etc/pacman.d/mirrorlist\n\n" +
+        "[extra]\nDBPath = /not/an/option\nInclude = /etc/pacman.d/mirrorlist\n\n#[multilib]\n"
+
+    config, err := parsePacmanConfig(strings.NewReader(conf))
+    if err != nil {
+        t.Fatalf("parsePacmanConfig() error = %v", err)
+    }
+    if config.DBPath != "/srv/pacman/db/" {
+        t.Errorf("parsePacmanConfig() DBPath = %q, want %q", config.DBPath, "/srv/pacman/db/")
+    }
+    if want := []string{"core", "extra"}; !reflect.DeepEqual(config.Repositories, want) {
+        t.Errorf("parsePacmanConfig() repositories = %q, want %q", config.Repositories, want)
+    }
+
+    config, err = parsePacmanConfig(strings.NewReader("[options]\nArchitecture = auto\n[core]\n"))
+    if err != nil || config.DBPath != "" {
+        t.Errorf("parsePacmanConfig() without DBPath = %+v, %v", config, err)
+    }
+}
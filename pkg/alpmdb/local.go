@@ -0,0 +1,77 @@
+package alpmdb
+
+// This file is responsible for reading the local database, which records the installed packages
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+)
+
+// returns the directory of the local database
+func (h *Handle) localDir() string {
+    return filepath.Join(h.DBPath, "local")
+}
+
+// reads every installed package
+func (h *Handle) LocalDatabase() (*Database, error) {
+    entries, err := os.ReadDir(h.localDir())
+    if err != nil {
+        return nil, fmt.Errorf("error reading the local database: %v", err)
+    }
+
+    var packages []*Package
+    for _, entry := range entries {
+        if !entry.IsDir() {
+            continue
+        }
+        pkg, err := h.readLocalEntry(entry.Name())
+        if err != nil {
+            return nil, err
+        }
+        packages = append(packages, pkg)
+    }
+
+    sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+    return newDatabase(LocalRepository, packages), nil
+}
+
+// reads a single installed package, without parsing the rest of the local database
+func (h *Handle) LocalPackage(name string) (*Package, error) {
+    entries, err := os.ReadDir(h.localDir())
+    if err != nil {
+        return nil, fmt.Errorf("error reading the local database: %v", err)
+    }
+
+    // Entries are named after the package and its version, so we only need to read the matching one
+    for _, entry := range entries {
+        if entryName, _ := splitEntryName(entry.Name()); entry.IsDir() && entryName == name {
+            pkg, err := h.readLocalEntry(entry.Name())
+            if err != nil {
+                return nil, err
+            }
+            pkg.Repository = LocalRepository
+            return pkg, nil
+        }
+    }
+    return nil, ErrNotFound
+}
+
+// reads the desc file of a local database entry
+func (h *Handle) readLocalEntry(entry string) (*Package, error) {
+    file, err := os.Open(filepath.Join(h.localDir(), entry, "desc"))
+    if err != nil {
+        return nil, fmt.Errorf("error reading the local database entry %s: %v", entry, err)
+    }
+    defer file.Close()
+
+    pkg := &Package{}
+    if err := parseDesc(file, pkg); err != nil {
+        return nil, fmt.Errorf("error reading the local database entry %s: %v", entry, err)
+    }
+    if pkg.Name == "" {
+        pkg.Name, pkg.Version = splitEntryName(entry)
+    }
+    return pkg, nil
+}
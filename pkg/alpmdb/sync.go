@@ -0,0 +1,245 @@
+package alpmdb
+
+// This file is responsible for reading the sync databases, the tar archives pacman downloads for
+// every repository. They are usually compressed with gzip or zstd, and every compression repo-add
+// supports is decompressed in Go, so reading them doesn't depend on any command line tools
+
+import (
+    "archive/tar"
+    "bufio"
+    "bytes"
+    "compress/bzip2"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/xz"
+    "pixelridgesoftworks.com/AllPac/pkg/zstd"
+)
+
+// returns the directory of the sync databases
+func (h *Handle) syncDir() string {
+    return filepath.Join(h.DBPath, "sync")
+}
+
+// returns the names of the sync databases to read, in the order pacman searches them
+func (h *Handle) repositoryNames() ([]string, error) {
+    if len(h.Repositories) > 0 {
+        return h.Repositories, nil
+    }
+
+    matches, err := filepath.Glob(filepath.Join(h.syncDir(), "*.db"))
+    if err != nil {
+        return nil, err
+    }
+
+    var names []string
+    for _, match := range matches {
+        names = append(names, strings.TrimSuffix(filepath.Base(match), ".db"))
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// reads every sync database, skipping repositories that were never synced
+func (h *Handle) SyncDatabases() ([]*Database, error) {
+    names, err := h.repositoryNames()
+    if err != nil {
+        return nil, fmt.Errorf("error listing the sync databases: %v", err)
+    }
+
+    var databases []*Database
+    for _, name := range names {
+        db, err := h.SyncDatabase(name)
+        if os.IsNotExist(err) {
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        databases = append(databases, db)
+    }
+    return databases, nil
+}
+
+// reads the sync database of a single repository
+func (h *Handle) SyncDatabase(name string) (*Database, error) {
+    dbPath := filepath.Join(h.syncDir(), name+".db")
+    info, err := os.Stat(dbPath)
+    if err != nil {
+        return nil, err
+    }
+
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    if h.syncCache == nil {
+        h.syncCache = make(map[string]cachedDatabase)
+    }
+    if cached, ok := h.syncCache[name]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+        return cached.db, nil
+    }
+
+    packages, err := readSyncArchive(dbPath)
+    if err != nil {
+        return nil, fmt.Errorf("error reading the sync database %s: %v", name, err)
+    }
+
+    db := newDatabase(name, packages)
+    h.syncCache[name] = cachedDatabase{modTime: info.ModTime(), size: info.Size(), db: db}
+    return db, nil
+}
+
+// finds a package in the sync databases, where the first repository that has it wins.
+// The name may be prefixed with a repository, like "extra/git"
+func (h *Handle) SyncPackage(name string) (*Package, error) {
+    if repository, packageName, found := strings.Cut(name, "/"); found {
+        db, err := h.SyncDatabase(repository)
+        if os.IsNotExist(err) {
+            return nil, ErrNotFound
+        } else if err != nil {
+            return nil, err
+        }
+        if pkg := db.Package(packageName); pkg != nil {
+            return pkg, nil
+        }
+        return nil, ErrNotFound
+    }
+
+    databases, err := h.SyncDatabases()
+    if err != nil {
+        return nil, err
+    }
+    for _, db := range databases {
+        if pkg := db.Package(name); pkg != nil {
+            return pkg, nil
+        }
+    }
+    return nil, ErrNotFound
+}
+
+// reads the packages of a sync database archive
+func readSyncArchive(dbPath string) ([]*Package, error) {
+    reader, err := openDecompressed(dbPath)
+    if err != nil {
+        return nil, err
+    }
+
+    // Every package is a directory holding a desc file, older databases split the dependencies into a depends file
+    entries := make(map[string]*Package)
+    var order []string
+    archive := tar.NewReader(reader)
+    for {
+        header, err := archive.Next()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        if header.Typeflag != tar.TypeReg {
+            continue
+        }
+
+        entry, file := path.Split(header.Name)
+        if file != "desc" && file != "depends" {
+            continue
+        }
+        entry = strings.TrimSuffix(entry, "/")
+
+        pkg, exists := entries[entry]
+        if !exists {
+            pkg = &Package{}
+            entries[entry] = pkg
+            order = append(order, entry)
+        }
+        if err := parseDesc(archive, pkg); err != nil {
+            return nil, err
+        }
+    }
+
+    packages := make([]*Package, 0, len(order))
+    for _, entry := range order {
+        pkg := entries[entry]
+        if pkg.Name == "" {
+            pkg.Name, pkg.Version = splitEntryName(entry)
+        }
+        packages = append(packages, pkg)
+    }
+    return packages, nil
+}
+
+// opens a database archive, decompressing it based on its magic bytes
+func openDecompressed(dbPath string) (io.Reader, error) {
+    data, err := os.ReadFile(dbPath)
+    if err != nil {
+        return nil, err
+    }
+
+    switch {
+    case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+        return gzip.NewReader(bytes.NewReader(data))
+    case bytes.HasPrefix(data, []byte("BZh")):
+        return bzip2.NewReader(bytes.NewReader(data)), nil
+    case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+        return decompressWith(dbPath, data, zstd.Decompress)
+    case bytes.HasPrefix(data, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+        return decompressWith(dbPath, data, xz.Decompress)
+    default:
+        // An uncompressed tar archive
+        return bytes.NewReader(data), nil
+    }
+}
+
+// decompresses a whole file at once with one of the in-memory decompressors
+func decompressWith(filePath string, data []byte, decompress func([]byte) ([]byte, error)) (io.Reader, error) {
+    decompressed, err := decompress(data)
+    if err != nil {
+        return nil, fmt.Errorf("error decompressing %s: %v", filePath, err)
+    }
+    return bytes.NewReader(decompressed), nil
+}
+
+// PacmanConfig represents the settings of pacman.conf that say where the databases are
+type PacmanConfig struct {
+    // the DBPath option, empty when pacman.conf leaves it at DefaultDBPath
+    DBPath string
+    // the repositories in the order pacman searches them
+    Repositories []string
+}
+
+// reads pacman.conf. Every section apart from [options] is a repository
+func ReadPacmanConfig(confPath string) (*PacmanConfig, error) {
+    file, err := os.Open(confPath)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return parsePacmanConfig(file)
+}
+
+func parsePacmanConfig(reader io.Reader) (*PacmanConfig, error) {
+    config := &PacmanConfig{}
+    section := ""
+    scanner := bufio.NewScanner(reader)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+            section = strings.TrimSpace(line[1 : len(line)-1])
+            if section != "options" && section != "" {
+                config.Repositories = append(config.Repositories, section)
+            }
+            continue
+        }
+        key, value, _ := strings.Cut(line, "=")
+        if section == "options" && strings.TrimSpace(key) == "DBPath" {
+            config.DBPath = strings.TrimSpace(value)
+        }
+    }
+    return config, scanner.Err()
+}
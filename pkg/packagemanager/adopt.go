@@ -0,0 +1,241 @@
+package packagemanager
+
+// This file is responsible for finding packages that are already installed on the system
+// and adopting them into the package list, so AllPac can manage packages it didn't install itself
+
+import (
+    "fmt"
+    "os/exec"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// SystemPackage represents a package installed on the system, as reported by its backend
+type SystemPackage struct {
+    Name    string
+    Source  string
+    Version string
+}
+
+// lists the packages installed on the system across every available backend.
+// when explicitOnly is set, pacman packages that were only installed as dependencies are left out
+func ListSystemPackages(explicitOnly bool) ([]SystemPackage, error) {
+    var packages []SystemPackage
+
+    pacmanPackages, err := listPacmanSystemPackages(explicitOnly)
+    if err != nil {
+        return nil, err
+    }
+    packages = append(packages, pacmanPackages...)
+
+    // Flatpak and Snap are optional, so we only query them if they are available
+    if _, err := exec.LookPath("flatpak"); err == nil {
+        flatpakPackages, err := listFlatpakSystemPackages()
+        if err != nil {
+            return nil, err
+        }
+        packages = append(packages, flatpakPackages...)
+    }
+
+    if _, err := exec.LookPath("snap"); err == nil {
+        snapPackages, err := listSnapSystemPackages()
+        if err != nil {
+            return nil, err
+        }
+        packages = append(packages, snapPackages...)
+    }
+
+    sort.Slice(packages, func(i, j int) bool {
+        if packages[i].Source != packages[j].Source {
+            return packages[i].Source < packages[j].Source
+        }
+        return packages[i].Name < packages[j].Name
+    })
+
+    return packages, nil
+}
+
+// lists the installed packages that are not yet in the package list
+func FindAdoptablePackages() ([]SystemPackage, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    installed, err := ListSystemPackages(true)
+    if err != nil {
+        return nil, err
+    }
+
+    var adoptable []SystemPackage
+    for _, pkg := range installed {
+        if _, exists := pkgList[pkg.Name]; !exists {
+            adoptable = append(adoptable, pkg)
+        }
+    }
+    return adoptable, nil
+}
+
+// records the given system packages in the package list
+func AdoptPackages(packages []SystemPackage) error {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+
+    var adopted []SystemPackage
+    for _, pkg := range packages {
+        if _, exists := pkgList[pkg.Name]; exists {
+            logger.Infof("Package %s is already in the package list, skipping adoption", pkg.Name)
+            continue
+        }
+        pkgList[pkg.Name] = PackageInfo{
+            Source:  pkg.Source,
+            Version: pkg.Version,
+        }
+        adopted = append(adopted, pkg)
+        logger.Infof("Adopted package %s (%s %s)", pkg.Name, pkg.Source, pkg.Version)
+    }
+
+    if err := writePackageList(pkgList); err != nil {
+        return err
+    }
+
+    for _, pkg := range adopted {
+        appendHistory("adopt", pkg.Name, pkg.Source, pkg.Version)
+    }
+    return nil
+}
+
+// lists the pacman packages, separating repository packages from foreign (AUR) ones
+func listPacmanSystemPackages(explicitOnly bool) ([]SystemPackage, error) {
+    query := "-Q"
+    if explicitOnly {
+        query = "-Qe"
+    }
+
+    installed, err := queryPacmanPackages(query)
+    if err != nil {
+        return nil, err
+    }
+
+    // Foreign packages are not in any sync database, so we assume they came from the AUR
+    foreign, err := queryPacmanPackages("-Qm")
+    if err != nil {
+        return nil, err
+    }
+
+    var packages []SystemPackage
+    for name, version := range installed {
+        if _, isForeign := foreign[name]; isForeign {
+            continue
+        }
+        packages = append(packages, SystemPackage{Name: name, Source: "pacman", Version: version})
+    }
+    for name, version := range foreign {
+        packages = append(packages, SystemPackage{Name: name, Source: "aur", Version: version})
+    }
+    return packages, nil
+}
+
+// runs a pacman query and returns the reported packages mapped to their versions
+func queryPacmanPackages(query string) (map[string]string, error) {
+    packages, err := queryAlpmPackages(query)
+    if err == nil {
+        return packages, nil
+    }
+    logger.Warnf("unable to read the pacman databases, asking pacman instead: %v", err)
+
+    cmd := exec.Command("pacman", query)
+    output, err := cmd.CombinedOutput()
+
+    // pacman exits with an error when a query matches nothing, which is not an error for us
+    if err != nil && len(output) == 0 {
+        return map[string]string{}, nil
+    } else if err != nil {
+        logger.Errorf("error querying pacman packages: %s, %v", output, err)
+        return nil, fmt.Errorf("error querying pacman packages: %s, %v", output, err)
+    }
+
+    packages = make(map[string]string)
+    for _, line := range strings.Split(string(output), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) >= 2 {
+            packages[fields[0]] = fields[1]
+        }
+    }
+    return packages, nil
+}
+
+// lists the installed Flatpak applications
+func listFlatpakSystemPackages() ([]SystemPackage, error) {
+    cmd := exec.Command("flatpak", "list", "--app", "--columns=application,version")
+    output, err := cmd.CombinedOutput()
+    if err != nil {
+        logger.Errorf("error listing Flatpak packages: %s, %v", output, err)
+        return nil, fmt.Errorf("error listing Flatpak packages: %s, %v", output, err)
+    }
+
+    var packages []SystemPackage
+    for _, line := range strings.Split(string(output), "\n") {
+        columns := strings.Split(line, "\t")
+        name := strings.TrimSpace(columns[0])
+        if name == "" || name == "Application ID" {
+            continue
+        }
+
+        version := ""
+        if len(columns) >= 2 {
+            version = strings.TrimSpace(columns[1])
+        }
+        packages = append(packages, SystemPackage{Name: name, Source: "flatpak", Version: version})
+    }
+    return packages, nil
+}
+
+// lists the installed Snap packages, leaving out the bases and snapd itself
+func listSnapSystemPackages() ([]SystemPackage, error) {
+    cmd := exec.Command("snap", "list")
+    output, err := cmd.CombinedOutput()
+    if err != nil {
+        // snap list fails when nothing is installed yet
+        if strings.Contains(string(output), "No snaps are installed") {
+            return nil, nil
+        }
+        logger.Errorf("error listing Snap packages: %s, %v", output, err)
+        return nil, fmt.Errorf("error listing Snap packages: %s, %v", output, err)
+    }
+
+    var packages []SystemPackage
+    lines := strings.Split(string(output), "\n")
+    for i, line := range lines {
+        // The first line is the column header
+        if i == 0 {
+            continue
+        }
+        // Columns are: Name Version Rev Tracking Publisher Notes
+        fields := strings.Fields(line)
+        if len(fields) < 2 {
+            continue
+        }
+        if len(fields) >= 6 && isSnapSystemNote(fields[5]) {
+            continue
+        }
+        packages = append(packages, SystemPackage{Name: fields[0], Source: "snap", Version: fields[1]})
+    }
+    return packages, nil
+}
+
+// checks if the notes column of snap list marks a snap as part of the snap system itself
+func isSnapSystemNote(notes string) bool {
+    for _, note := range strings.Split(notes, ",") {
+        switch note {
+        case "base", "core", "snapd":
+            return true
+        }
+    }
+    return false
+}
@@ -2,12 +2,13 @@ package packagemanager
 
 import (
     "fmt"
-	"sync"
+	"sort"
 	"pixelridgesoftworks.com/AllPac/pkg/logger"
 )
 
-// UpdateAllPackages updates all packages on the system
-func UpdateAllPackages() error {
+// UpdateAllPackages updates all packages on the system. With devel, VCS packages whose upstream
+// moved since they were built are rebuilt too
+func UpdateAllPackages(devel bool) error {
     pkgList, err := readPackageList()
     if err != nil {
 		logger.Errorf("Failed to load config: %v", err)
@@ -33,8 +34,8 @@ func UpdateAllPackages() error {
         logger.Errorf("Error updating Flatpak packages: %v\n", err)
     }
 
-    // Update AUR packages (can be done concurrently)
-    updateAURPackagesConcurrently(aurPackages)
+    // Update AUR packages one at a time, since every build may stop for a review
+    updateAURPackagesInOrder(aurPackages, devel)
 
     fmt.Println("All packages have been updated.")
 	logger.Info("All packages have been updated.")
@@ -112,17 +113,12 @@ func checkIfPackageNeedsUpdate(name, source string) (bool, error) {
     return currentVersion != latestVersion, nil
 }
 
-// updateAURPackagesConcurrently updates AUR packages using concurrency
-func updateAURPackagesConcurrently(packageNames []string) {
-    var wg sync.WaitGroup
+// updateAURPackagesInOrder updates AUR packages one after another, so their reviews and builds don't interleave
+func updateAURPackagesInOrder(packageNames []string, devel bool) {
+    sort.Strings(packageNames)
     for _, pkgName := range packageNames {
-        wg.Add(1)
-        go func(name string) {
-            defer wg.Done()
-            if err := UpdateAURPackages(name); err != nil {
-                logger.Errorf("Error updating AUR package %s: %v\n", name, err)
-            }
-        }(pkgName)
+        if err := updateAURPackages(devel, []string{pkgName}); err != nil {
+            logger.Errorf("Error updating AUR package %s: %v\n", pkgName, err)
+        }
     }
-    wg.Wait()
 }
@@ -0,0 +1,113 @@
+package packagemanager
+
+// This file is responsible for reading pacman's databases directly, which is a lot faster than spawning
+// pacman for every lookup. If the databases can't be read, every lookup falls back to pacman itself
+
+import (
+    "errors"
+    "sync"
+    "pixelridgesoftworks.com/AllPac/pkg/alpmdb"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+var (
+    alpmHandleOnce sync.Once
+    alpmHandle     *alpmdb.Handle
+)
+
+// returns the shared database handle, so parsed sync databases are reused across lookups
+func getAlpmHandle() *alpmdb.Handle {
+    alpmHandleOnce.Do(func() {
+        config, _ := ReadConfig()
+        confPath := config.PacmanConfPath
+        if confPath == "" {
+            confPath = alpmdb.DefaultConfPath
+        }
+        pacmanConfig, err := alpmdb.ReadPacmanConfig(confPath)
+        if err != nil {
+            logger.Warnf("unable to read %s, using the default database path and every sync database: %v", confPath, err)
+            pacmanConfig = &alpmdb.PacmanConfig{}
+        }
+
+        // pacman_db_path overrides the DBPath of pacman.conf
+        dbPath := config.PacmanDBPath
+        if dbPath == "" {
+            dbPath = pacmanConfig.DBPath
+        }
+        if dbPath == "" {
+            dbPath = alpmdb.DefaultDBPath
+        }
+
+        alpmHandle = alpmdb.NewHandle(dbPath)
+        alpmHandle.Repositories = pacmanConfig.Repositories
+    })
+    return alpmHandle
+}
+
+// looks up a package in the sync databases. found is false when the package doesn't exist,
+// err is only set when the databases couldn't be read and pacman should be asked instead
+func lookupSyncPackage(packageName string) (pkg *alpmdb.Package, found bool, err error) {
+    pkg, err = getAlpmHandle().SyncPackage(packageName)
+    if errors.Is(err, alpmdb.ErrNotFound) {
+        return nil, false, nil
+    } else if err != nil {
+        logger.Warnf("unable to read the sync databases, asking pacman instead: %v", err)
+        return nil, false, err
+    }
+    return pkg, true, nil
+}
+
+// looks up an installed package in the local database, with the same results as lookupSyncPackage
+func lookupLocalPackage(packageName string) (pkg *alpmdb.Package, found bool, err error) {
+    pkg, err = getAlpmHandle().LocalPackage(packageName)
+    if errors.Is(err, alpmdb.ErrNotFound) {
+        return nil, false, nil
+    } else if err != nil {
+        logger.Warnf("unable to read the local database, asking pacman instead: %v", err)
+        return nil, false, err
+    }
+    return pkg, true, nil
+}
+
+// answers the pacman queries AllPac uses (-Q, -Qe and -Qm) from the databases, mapping package names to versions
+func queryAlpmPackages(query string) (map[string]string, error) {
+    handle := getAlpmHandle()
+    local, err := handle.LocalDatabase()
+    if err != nil {
+        return nil, err
+    }
+
+    var syncDatabases []*alpmdb.Database
+    if query == "-Qm" {
+        if syncDatabases, err = handle.SyncDatabases(); err != nil {
+            return nil, err
+        }
+    }
+
+    packages := make(map[string]string)
+    for _, pkg := range local.Packages {
+        switch query {
+        case "-Qe":
+            if pkg.Reason != alpmdb.ReasonExplicit {
+                continue
+            }
+        case "-Qm":
+            // Foreign packages are the ones no sync database knows about
+            if isInSyncDatabases(syncDatabases, pkg.Name) {
+                continue
+            }
+        }
+        packages[pkg.Name] = pkg.Version
+    }
+    return packages, nil
+}
+
+// reports whether any of the given sync databases has the package
+func isInSyncDatabases(databases []*alpmdb.Database, packageName string) bool {
+    for _, db := range databases {
+        if db.Package(packageName) != nil {
+            return true
+        }
+    }
+    return false
+}
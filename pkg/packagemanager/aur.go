@@ -2,19 +2,35 @@ package packagemanager
 
 import (
     "fmt"
-    "os/exec"
 	"os"
 	"path/filepath"
 	"pixelridgesoftworks.com/AllPac/pkg/logger"
 )
 
+// returns the git URL of the AUR repository for a package
+func aurRepoURL(packageName string) string {
+    return fmt.Sprintf("https://aur.archlinux.org/%s.git", packageName)
+}
+
 // AURPackageInfo represents the package information from the AUR
 type AURPackageInfo struct {
-    Version string `json:"Version"`
+    Name        string `json:"Name"`
+    PackageBase string `json:"PackageBase"`
+    Version     string `json:"Version"`
 }
 
 // UpdateAURPackages updates specified AUR packages or all if no specific package is provided
 func UpdateAURPackages(packageNames ...string) error {
+    return updateAURPackages(false, packageNames)
+}
+
+// UpdateAURDevelPackages updates AUR packages like UpdateAURPackages, and also rebuilds the VCS packages
+// among them whose upstream moved since they were built
+func UpdateAURDevelPackages(packageNames ...string) error {
+    return updateAURPackages(true, packageNames)
+}
+
+func updateAURPackages(devel bool, packageNames []string) error {
     pkgList, err := ReadPackageList()
     if err != nil {
         logger.Errorf("error reading package list: %v", err)
@@ -30,7 +46,7 @@ func UpdateAURPackages(packageNames ...string) error {
         }
     }
 
-    for _, packageName := range packageNames {
+    for _, packageName := range filterHeldPackages(pkgList, packageNames) {
         aurInfo, err := fetchAURPackageInfo(packageName)
         if err != nil {
             logger.Errorf("error fetching AUR package info for %s: %v", packageName, err)
@@ -38,23 +54,45 @@ func UpdateAURPackages(packageNames ...string) error {
         }
 
         installedInfo, ok := pkgList[packageName]
-        if !ok || installedInfo.Version != aurInfo.Version {
-            _, err := CloneAndInstallFromAUR("https://aur.archlinux.org/" + packageName + ".git", true)
+        vcs := ok && isVCSPackage(packageName, installedInfo)
+        needsUpdate := !ok || aurVersionNeedsUpdate(installedInfo, aurInfo.Version, vcs)
+
+        // The AUR version of a VCS package rarely changes, so ask upstream instead
+        if !needsUpdate && devel && vcs {
+            changed, reason, err := vcsUpstreamChanged(packageName, installedInfo)
             if err != nil {
-                logger.Errorf("error updating AUR package %s: %v", packageName, err)
-                return fmt.Errorf("error updating AUR package %s: %v", packageName, err)
+                logger.Warnf("unable to check the upstream of %s, skipping it: %v", packageName, err)
+                continue
             }
+            if changed {
+                fmt.Printf("Rebuilding %s: %s\n", packageName, reason)
+                logger.Infof("Rebuilding %s: %s", packageName, reason)
+                needsUpdate = true
+            }
+        }
 
-            // Update the package list with the new version
-            if err := UpdatePackageInList(packageName, "aur", aurInfo.Version); err != nil {
-                logger.Errorf("error updating package list for %s: %v", packageName, err)
-                return fmt.Errorf("error updating package list for %s: %v", packageName, err)
+        if needsUpdate {
+            _, err := CloneAndInstallFromAUR("https://aur.archlinux.org/" + packageName + ".git", true)
+            if err != nil {
+                logger.Errorf("error updating AUR package %s: %v", packageName, err)
+                // Wrapped, so a PGPVerificationError still reaches the caller
+                return fmt.Errorf("error updating AUR package %s: %w", packageName, err)
             }
         }
     }
     return nil
 }
 
+// reports whether the version in the AUR is an update of an installed package. A VCS package is installed
+// with the version its pkgver() computed from upstream, which is usually ahead of the version in the AUR,
+// so it's only rebuilt when the AUR version is actually newer. Upstream changes are left to update --devel
+func aurVersionNeedsUpdate(pkgInfo PackageInfo, aurVersion string, vcs bool) bool {
+    if vcs {
+        return vercmp(aurVersion, pkgInfo.Version) > 0
+    }
+    return pkgInfo.Version != aurVersion
+}
+
 // UninstallAURPackage uninstalls a specified AUR package
 func UninstallAURPackage(packageName string) error {
     // Read the current package list
@@ -71,7 +109,7 @@ func UninstallAURPackage(packageName string) error {
     }
 
     // Uninstalling an AUR package is typically done with pacman
-    cmd := exec.Command("sudo", "pacman", "-Rns", "--noconfirm", packageName)
+    cmd := newCommand(pacmanRemoveCommand(packageName))
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error uninstalling AUR package: %s, %v", output, err)
         return fmt.Errorf("error uninstalling AUR package: %s, %v", output, err)
@@ -103,37 +141,46 @@ func ClearAllPacCache() error {
     }
 
     // Optionally, recreate the cache directory after clearing it
-    return os.MkdirAll(cacheDir, 0755)
+    return mkdirAllForBuildUser(cacheDir)
 }
 
-// getCacheDir returns the path to the ~/.allpac/cache/ directory
+// getCacheDir returns the path to the ~/.allpac/cache/ directory of the build user, who owns the clones
 func getCacheDir() (string, error) {
-    userHomeDir, err := os.UserHomeDir()
+    buildUser, err := getBuildUser()
     if err != nil {
-		logger.Errorf("An error has occured:", err)
         return "", err
     }
-    return filepath.Join(userHomeDir, ".allpac", "cache"), nil
+    return filepath.Join(buildUser.HomeDir, ".allpac", "cache"), nil
 }
 
 // RebuildAndReinstallAURPackage rebuilds and reinstalls the specified AUR package
 func RebuildAndReinstallAURPackage(packageName string) error {
+    _, err := RebuildAURPackage(packageName, nil)
+    return err
+}
+
+// rebuilds an AUR package from the latest commit, skipping the given steps, and reports what every step did
+func RebuildAURPackage(packageName string, skip map[string]bool) (*AURBuildReport, error) {
     // Read the package list
     pkgList, err := readPackageList()
     if err != nil {
 		logger.Errorf("error reading package list: %v", err)
-        return fmt.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
     }
 
     // Check if the package is in the list and is an AUR package
     pkgInfo, found := pkgList[packageName]
     if !found || pkgInfo.Source != "aur" {
 		logger.Errorf("package %s is not found or not an AUR package", packageName)
-        return fmt.Errorf("package %s is not found or not an AUR package", packageName)
+        return nil, fmt.Errorf("package %s is not found or not an AUR package", packageName)
     }
 
     // Rebuild and reinstall the package
-    _, err = CloneAndInstallFromAUR(fmt.Sprintf("https://aur.archlinux.org/%s.git", packageName), false)
-    logger.Errorf("An error has occured:", err)
-	return err
+    // The persistent clone is fetched and reset, so the rebuild starts from the latest commit
+    report, err := RunAURBuild(packageName, AURBuildOptions{Skip: skip})
+    if err != nil {
+        logger.Errorf("error rebuilding AUR package %s: %v", packageName, err)
+        return report, fmt.Errorf("error rebuilding AUR package %s: %w", packageName, err)
+    }
+    return report, nil
 }
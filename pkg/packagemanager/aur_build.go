@@ -0,0 +1,290 @@
+package packagemanager
+
+// This file is responsible for the steps of building and installing an AUR package. Each step runs on its own:
+// fetch brings the clone up to date, review shows the build files, build runs makepkg (or makechrootpkg)
+// without installing and moves the archives into the package cache, and install runs pacman -U on them.
+// Any step can be skipped, and the package list only changes once the archives are actually installed
+
+import (
+    "fmt"
+    "path/filepath"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the steps of an AUR build, in the order they run
+const (
+    AURStepFetch   = "fetch"
+    AURStepReview  = "review"
+    AURStepBuild   = "build"
+    AURStepInstall = "install"
+)
+
+// AURBuildSteps lists the steps of an AUR build in the order they run
+var AURBuildSteps = []string{AURStepFetch, AURStepReview, AURStepBuild, AURStepInstall}
+
+// AURBuildOptions controls how an AUR package is built and installed
+type AURBuildOptions struct {
+    // the commit to build, the latest one when empty
+    Commit string
+    // don't ask before upgrading the system, building or installing
+    SkipConfirmation bool
+    // the steps to skip. Skipping fetch builds the clone as it is, skipping build installs the cached
+    // archives of the commit that's checked out, and skipping install only builds and caches the package
+    Skip map[string]bool
+}
+
+// AURStepResult represents what a single step of an AUR build did
+type AURStepResult struct {
+    Step    string
+    Skipped bool
+    Detail  string
+}
+
+// AURBuildReport represents what an AUR build did
+type AURBuildReport struct {
+    Package string
+    // the commit of the AUR repository that was built
+    Commit string
+    // the version of the package, as installed or as built when it wasn't installed
+    Version string
+    // the archives in the package cache
+    Archives  []string
+    Installed bool
+    // the upstream commits of the git sources when the package was built, by source
+    VCS       map[string]string
+    Steps     []AURStepResult
+}
+
+func (r *AURBuildReport) addStep(step string, skipped bool, format string, args ...interface{}) {
+    detail := fmt.Sprintf(format, args...)
+    r.Steps = append(r.Steps, AURStepResult{Step: step, Skipped: skipped, Detail: detail})
+    if skipped {
+        logger.Infof("Skipped the %s step of %s: %s", step, r.Package, detail)
+    } else {
+        logger.Infof("Finished the %s step of %s: %s", step, r.Package, detail)
+    }
+}
+
+// fetches, reviews, builds and installs an AUR package, skipping the steps the options ask for,
+// and reports what every step did. The report is returned up to the step that failed
+func RunAURBuild(packageName string, options AURBuildOptions) (*AURBuildReport, error) {
+    report := &AURBuildReport{Package: packageName}
+    for step := range options.Skip {
+        if !isAURBuildStep(step) {
+            logger.Errorf("unknown AUR build step %q, expected one of %s", step, strings.Join(AURBuildSteps, ", "))
+            return report, fmt.Errorf("unknown AUR build step %q, expected one of %s", step, strings.Join(AURBuildSteps, ", "))
+        }
+    }
+
+    // Building needs up to date dependencies and installing must not cause a partial upgrade,
+    // so the system is upgraded first, unless it already happened during this run
+    if !isSystemUpgraded() && (!options.Skip[AURStepBuild] || !options.Skip[AURStepInstall]) {
+        if !options.SkipConfirmation && !ConfirmAction("Do you want to update the system before proceeding? (skipping this step may result in partial updates, and break your system)") {
+            logger.Warnf("user aborted the system update")
+            return report, fmt.Errorf("user aborted the system update")
+        }
+
+        if _, err := ensureSystemUpgraded(); err != nil {
+            return report, err
+        }
+    }
+
+    // Everything below builds in the home of the build user, which is the current user unless we run as root
+    usr, err := getBuildUser()
+    if err != nil {
+        return report, err
+    }
+
+    // Split packages share the repository and the clone of their package base
+    pkgBase := aurPackageBase(packageName)
+    repoURL := aurRepoURL(pkgBase)
+    cloneDir := aurCloneDir(usr.HomeDir, pkgBase)
+
+    if !options.SkipConfirmation && !options.Skip[AURStepBuild] && !ConfirmAction("Do you want to download and build package from " + repoURL + "?") {
+        logger.Warnf("user aborted the action")
+        return report, fmt.Errorf("user aborted the action")
+    }
+
+    if options.Skip[AURStepFetch] {
+        if !isGitRepository(cloneDir) {
+            logger.Errorf("%s has never been fetched, so there is no clone to build", packageName)
+            return report, fmt.Errorf("%s has never been fetched, so there is no clone to build", packageName)
+        }
+        report.addStep(AURStepFetch, true, "using the clone in %s as it is", cloneDir)
+    } else {
+        // Fetch the repository, checking out the exact commit we were asked for instead of the latest one
+        if err := syncAURClone(repoURL, cloneDir, options.Commit); err != nil {
+            return report, err
+        }
+        report.addStep(AURStepFetch, false, "updated %s", cloneDir)
+    }
+
+    report.Commit, err = gitHeadCommit(cloneDir)
+    if err != nil {
+        return report, err
+    }
+
+    // Nothing in the build files runs when the build is skipped, so there is nothing to review either
+    if options.Skip[AURStepReview] || options.Skip[AURStepBuild] {
+        report.addStep(AURStepReview, true, "the build files of %s were not reviewed", shortCommit(report.Commit))
+    } else {
+        if err := reviewAURBuildFiles(packageName, cloneDir); err != nil {
+            return report, err
+        }
+        report.addStep(AURStepReview, false, "accepted the build files of %s", shortCommit(report.Commit))
+    }
+
+    if options.Skip[AURStepBuild] {
+        report.Archives, err = cachedArchivesOfCommit(packageName, cloneDir, report.Commit)
+        if err != nil {
+            return report, err
+        }
+        report.addStep(AURStepBuild, true, "using %d cached archives built from %s", len(report.Archives), shortCommit(report.Commit))
+    } else {
+        // Remember where upstream is before makepkg checks it out, so update --devel can tell when it moves.
+        // If upstream moves during the build, the next update --devel rebuilds once too often instead of
+        // missing a change, and not knowing at all only means the next update --devel rebuilds the package
+        vcsHeads, err := vcsUpstreamHeads(packageName, cloneDir)
+        if err != nil {
+            logger.Warnf("unable to record the upstream commits of %s: %v", packageName, err)
+        }
+
+        report.Archives, err = buildAURArchives(packageName, cloneDir, usr.HomeDir, report.Commit)
+        if err != nil {
+            return report, err
+        }
+        report.VCS = vcsHeads
+        report.addStep(AURStepBuild, false, "built %d archives from %s", len(report.Archives), shortCommit(report.Commit))
+    }
+    report.Version = archiveVersion(packageName, report.Archives)
+
+    if options.Skip[AURStepInstall] {
+        report.addStep(AURStepInstall, true, "the archives are kept in the package cache")
+        return report, nil
+    }
+
+    // The package is built but nothing is installed yet, so this is where the user decides
+    if !options.SkipConfirmation && !ConfirmAction("Do you want to install the built package " + packageName + " " + report.Version + "?") {
+        logger.Warnf("user aborted the installation")
+        return report, fmt.Errorf("user aborted the installation")
+    }
+
+    if err := installBuiltArchives(packageName, report.Archives); err != nil {
+        return report, err
+    }
+    report.Installed = true
+
+    // Record the full installed version, falling back to the version of the archive
+    if version, err := GetPacmanInstalledVersion(packageName); err == nil {
+        report.Version = version
+    }
+    if report.Version == "" {
+        logger.Errorf("unable to tell which version of %s was installed", packageName)
+        return report, fmt.Errorf("unable to tell which version of %s was installed", packageName)
+    }
+
+    if err := LogInstallation(packageName, "aur", report.Version); err != nil {
+        logger.Errorf("error logging installation")
+        return report, fmt.Errorf("error logging installation: %v", err)
+    }
+
+    // A cached build has no upstream commits, so it is rebuilt by the next update --devel
+    err = updatePackageInfo(packageName, func(pkgInfo *PackageInfo) {
+        pkgInfo.Commit = report.Commit
+        pkgInfo.VCS = report.VCS
+    })
+    if err != nil {
+        logger.Errorf("error recording built commit: %v", err)
+        return report, fmt.Errorf("error recording built commit: %v", err)
+    }
+    report.addStep(AURStepInstall, false, "installed %s %s", packageName, report.Version)
+    return report, nil
+}
+
+// reports whether the name is one of the steps of an AUR build
+func isAURBuildStep(step string) bool {
+    for _, known := range AURBuildSteps {
+        if step == known {
+            return true
+        }
+    }
+    return false
+}
+
+// builds the package in a clone and moves the archives into the package cache, returning their new paths
+func buildAURArchives(packageName, cloneDir, homeDir, commit string) ([]string, error) {
+    // makepkg can only verify signed sources with the keys from validpgpkeys in the keyring
+    if err := ensurePGPKeys(packageName, cloneDir); err != nil {
+        return nil, err
+    }
+
+    // The build environment goes to makepkg directly, so the clone stays exactly as it was fetched
+    config, err := ReadConfig()
+    if err != nil {
+        logger.Warnf("unable to read the config, building %s with the default environment: %v", packageName, err)
+    }
+    buildConfig := config.buildConfigFor(packageName)
+    if err := writeMakepkgConf(homeDir, packageName, buildConfig); err != nil {
+        return nil, err
+    }
+
+    // Build the package as the non-root user, following its build mode
+    if err := runAURBuildCommand(packageName, cloneDir, homeDir, config, buildConfig); err != nil {
+        return nil, err
+    }
+
+    archives, err := makepkgPackageList(cloneDir, makepkgBuildEnv(homeDir, buildConfig), makepkgConfArgs(homeDir, packageName, buildConfig))
+    if err != nil {
+        return nil, err
+    }
+
+    // Keep the built archives, so this version can be reinstalled later without building it again
+    cached, err := cacheBuiltPackages(cloneDir, archives, commit)
+    if err != nil {
+        return nil, err
+    }
+    if len(cached) == 0 {
+        logger.Errorf("makepkg did not produce any archives for %s", packageName)
+        return nil, fmt.Errorf("makepkg did not produce any archives for %s", packageName)
+    }
+    return cached, nil
+}
+
+// returns the cached archives of the packages a clone builds that were built from the given commit
+func cachedArchivesOfCommit(packageName, cloneDir, commit string) ([]string, error) {
+    packageNames := []string{packageName}
+    if srcinfo, err := readSrcinfo(cloneDir); err == nil && len(srcinfo["pkgname"]) > 0 {
+        packageNames = srcinfo["pkgname"]
+    }
+
+    var archives []string
+    for _, name := range packageNames {
+        cached, err := ListCachedAURPackages(name)
+        if err != nil {
+            return nil, err
+        }
+        for _, pkg := range cached {
+            if pkg.Commit == commit {
+                archives = append(archives, pkg.Path)
+                break
+            }
+        }
+    }
+
+    if len(archives) == 0 {
+        logger.Errorf("there is no cached build of %s from commit %s, build it first", packageName, shortCommit(commit))
+        return nil, fmt.Errorf("there is no cached build of %s from commit %s, build it first", packageName, shortCommit(commit))
+    }
+    return archives, nil
+}
+
+// returns the version of the package's own archive among the given ones
+func archiveVersion(packageName string, archives []string) string {
+    for _, archive := range archives {
+        if name, version, ok := parseArchiveName(filepath.Base(archive)); ok && name == packageName {
+            return version
+        }
+    }
+    return ""
+}
@@ -0,0 +1,289 @@
+package packagemanager
+
+// This file is responsible for reporting and cleaning up the disk space AUR packages take in AllPac's caches:
+// the clones in ~/.allpac/cache/ with the src/ and pkg/ trees makepkg leaves in them, and the built
+// archives in ~/.allpac/packages/
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the directories makepkg builds in, which are recreated by every build
+var makepkgBuildTrees = []string{"src", "pkg"}
+
+// AURCacheUsage represents the disk space a package takes in AllPac's caches
+type AURCacheUsage struct {
+    // the package base for clones, or the package name for cached archives
+    Name string
+    // whether any package built from it is in the package list
+    Managed bool
+    // the clone, without the build trees
+    CloneSize int64
+    // the src/ and pkg/ trees makepkg left in the clone
+    BuildTreeSize int64
+    // the cached archives
+    PackagesSize int64
+    // the number of cached archives
+    Builds int
+}
+
+// returns the total disk space of the package
+func (u AURCacheUsage) Total() int64 {
+    return u.CloneSize + u.BuildTreeSize + u.PackagesSize
+}
+
+// CacheRemoval represents a directory removed from AllPac's caches
+type CacheRemoval struct {
+    Name string
+    Path string
+    Size int64
+}
+
+// reports the disk space every package takes in the clone and package caches, largest first
+func AURCacheReport() ([]AURCacheUsage, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    usage := make(map[string]*AURCacheUsage)
+    entryFor := func(name string) *AURCacheUsage {
+        if usage[name] == nil {
+            usage[name] = &AURCacheUsage{Name: name}
+        }
+        return usage[name]
+    }
+
+    clones, err := listAURClones()
+    if err != nil {
+        return nil, err
+    }
+    for _, cloneDir := range clones {
+        entry := entryFor(filepath.Base(cloneDir))
+        entry.Managed = entry.Managed || !isOrphanedClone(cloneDir, pkgList)
+        var buildTreeSize int64
+        for _, tree := range makepkgBuildTrees {
+            buildTreeSize += dirSize(filepath.Join(cloneDir, tree))
+        }
+        entry.BuildTreeSize += buildTreeSize
+        entry.CloneSize += dirSize(cloneDir) - buildTreeSize
+    }
+
+    packageCacheDir, err := getPackageCacheDir()
+    if err != nil {
+        return nil, err
+    }
+    entries, err := os.ReadDir(packageCacheDir)
+    if err != nil && !os.IsNotExist(err) {
+        logger.Errorf("error reading package cache: %v", err)
+        return nil, fmt.Errorf("error reading package cache: %v", err)
+    }
+    for _, dirEntry := range entries {
+        if !dirEntry.IsDir() {
+            continue
+        }
+        entry := entryFor(dirEntry.Name())
+        if pkgInfo, exists := pkgList[dirEntry.Name()]; exists && pkgInfo.Source == "aur" {
+            entry.Managed = true
+        }
+        entry.PackagesSize += dirSize(filepath.Join(packageCacheDir, dirEntry.Name()))
+        cached, err := ListCachedAURPackages(dirEntry.Name())
+        if err != nil {
+            return nil, err
+        }
+        entry.Builds += len(cached)
+    }
+
+    report := make([]AURCacheUsage, 0, len(usage))
+    for _, entry := range usage {
+        report = append(report, *entry)
+    }
+    sort.Slice(report, func(i, j int) bool {
+        if report[i].Total() != report[j].Total() {
+            return report[i].Total() > report[j].Total()
+        }
+        return report[i].Name < report[j].Name
+    })
+    return report, nil
+}
+
+// removes the clones that no package in the package list is built from anymore, along with the
+// date-stamped clones older versions of AllPac made for every build
+func RemoveOrphanedAURClones() ([]CacheRemoval, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    clones, err := listAURClones()
+    if err != nil {
+        return nil, err
+    }
+
+    var removed []CacheRemoval
+    for _, cloneDir := range clones {
+        if !isOrphanedClone(cloneDir, pkgList) {
+            continue
+        }
+        size := dirSize(cloneDir)
+        if err := os.RemoveAll(cloneDir); err != nil {
+            logger.Errorf("error removing %s: %v", cloneDir, err)
+            return removed, fmt.Errorf("error removing %s: %v", cloneDir, err)
+        }
+        logger.Infof("Removed orphaned clone %s", cloneDir)
+        removed = append(removed, CacheRemoval{Name: filepath.Base(cloneDir), Path: cloneDir, Size: size})
+    }
+    return removed, nil
+}
+
+// removes the src/ and pkg/ trees makepkg leaves in the clones, keeping the clones themselves
+func RemoveAURBuildTrees() ([]CacheRemoval, error) {
+    clones, err := listAURClones()
+    if err != nil {
+        return nil, err
+    }
+
+    var removed []CacheRemoval
+    for _, cloneDir := range clones {
+        for _, tree := range makepkgBuildTrees {
+            treeDir := filepath.Join(cloneDir, tree)
+            if _, err := os.Stat(treeDir); err != nil {
+                continue
+            }
+            size := dirSize(treeDir)
+            if err := os.RemoveAll(treeDir); err != nil {
+                logger.Errorf("error removing %s: %v", treeDir, err)
+                return removed, fmt.Errorf("error removing %s: %v", treeDir, err)
+            }
+            logger.Infof("Removed build tree %s", treeDir)
+            removed = append(removed, CacheRemoval{Name: filepath.Base(cloneDir), Path: treeDir, Size: size})
+        }
+    }
+    return removed, nil
+}
+
+// returns the directories in the clone cache
+func listAURClones() ([]string, error) {
+    cacheDir, err := getCacheDir()
+    if err != nil {
+        return nil, err
+    }
+
+    entries, err := os.ReadDir(cacheDir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        logger.Errorf("error reading AUR cache: %v", err)
+        return nil, fmt.Errorf("error reading AUR cache: %v", err)
+    }
+
+    var clones []string
+    for _, entry := range entries {
+        if entry.IsDir() {
+            clones = append(clones, filepath.Join(cacheDir, entry.Name()))
+        }
+    }
+    return clones, nil
+}
+
+// reports whether a clone is no longer needed: it isn't named after its package base, like the clones of
+// older versions of AllPac, or none of the packages it builds are AUR packages in the package list
+func isOrphanedClone(cloneDir string, pkgList PackageList) bool {
+    pkgBase := filepath.Base(cloneDir)
+    pkgNames := []string{pkgBase}
+
+    if srcinfo, err := readSrcinfo(cloneDir); err == nil {
+        if bases := srcinfo["pkgbase"]; len(bases) > 0 && bases[0] != pkgBase {
+            return true
+        }
+        pkgNames = append(pkgNames, srcinfo["pkgname"]...)
+    }
+
+    for _, name := range pkgNames {
+        if pkgInfo, exists := pkgList[name]; exists && pkgInfo.Source == "aur" {
+            return false
+        }
+    }
+    return true
+}
+
+// reads the .SRCINFO of a clone into the values of every key, across the pkgbase and all pkgname sections
+func readSrcinfo(cloneDir string) (map[string][]string, error) {
+    file, err := os.Open(filepath.Join(cloneDir, ".SRCINFO"))
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return parseSrcinfo(file)
+}
+
+// reads the .SRCINFO of a clone or, if the clone has none, asks makepkg --printsrcinfo for it
+func cloneSrcinfo(cloneDir string) (map[string][]string, error) {
+    srcinfo, err := readSrcinfo(cloneDir)
+    if os.IsNotExist(err) {
+        cmd, cmdErr := newBuildCommand(makepkgPrintSrcinfoCommand())
+        if cmdErr != nil {
+            return nil, cmdErr
+        }
+        cmd.Dir = cloneDir
+        output, cmdErr := cmd.Output()
+        if cmdErr != nil {
+            logger.Errorf("error printing the .SRCINFO of %s: %v", cloneDir, cmdErr)
+            return nil, fmt.Errorf("error printing the .SRCINFO of %s: %v", cloneDir, cmdErr)
+        }
+        srcinfo, err = parseSrcinfo(bytes.NewReader(output))
+    }
+    if err != nil {
+        logger.Errorf("error reading the .SRCINFO of %s: %v", cloneDir, err)
+        return nil, fmt.Errorf("error reading the .SRCINFO of %s: %v", cloneDir, err)
+    }
+    return srcinfo, nil
+}
+
+// parses .SRCINFO content, as written to the AUR or printed by makepkg --printsrcinfo
+func parseSrcinfo(reader io.Reader) (map[string][]string, error) {
+    values := make(map[string][]string)
+    scanner := bufio.NewScanner(reader)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        key, value, found := strings.Cut(line, "=")
+        if !found {
+            continue
+        }
+        key = strings.TrimSpace(key)
+        values[key] = append(values[key], strings.TrimSpace(value))
+    }
+    return values, scanner.Err()
+}
+
+// returns the disk space of the files below a directory, or 0 if it doesn't exist
+func dirSize(dir string) int64 {
+    var size int64
+    filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+        if err != nil {
+            // Unreadable parts, e.g. files makepkg created as another user, are left out
+            return nil
+        }
+        if entry.Type().IsRegular() {
+            if info, err := entry.Info(); err == nil {
+                size += info.Size()
+            }
+        }
+        return nil
+    })
+    return size
+}
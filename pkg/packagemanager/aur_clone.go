@@ -0,0 +1,88 @@
+package packagemanager
+
+// This file is responsible for the clones of AUR repositories. Every package base has a single clone in
+// ~/.allpac/cache/<pkgbase> that is kept between builds: updates fetch it and reset it to the new commit,
+// so rebuilds and reviews can compare against the commit that was built last
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the ref a clone is reset to when no specific commit is wanted
+const aurCloneDefaultRef = "origin/HEAD"
+
+// returns the directory the AUR repository of a package base is cloned into
+func aurCloneDir(homeDir, pkgBase string) string {
+    return filepath.Join(homeDir, ".allpac", "cache", pkgBase)
+}
+
+// returns the package base of an AUR package, which split packages share. Falls back to the
+// package name if the AUR can't be asked
+func aurPackageBase(packageName string) string {
+    aurInfo, err := fetchAURPackageInfo(packageName)
+    if err != nil || aurInfo.PackageBase == "" {
+        return packageName
+    }
+    return aurInfo.PackageBase
+}
+
+// reports whether the directory holds a git repository
+func isGitRepository(dir string) bool {
+    info, err := os.Stat(filepath.Join(dir, ".git"))
+    return err == nil && info.IsDir()
+}
+
+// brings the clone of an AUR repository up to date, cloning it if it doesn't exist yet, and resets it
+// to the given commit, or the latest one when commit is empty. Files that makepkg leaves behind are kept
+func syncAURClone(repoURL, cloneDir, commit string) error {
+    if isGitRepository(cloneDir) {
+        cmdFetch, err := newBuildCommand(gitFetchCommand())
+        if err != nil {
+            return err
+        }
+        cmdFetch.Dir = cloneDir
+        if output, err := cmdFetch.CombinedOutput(); err != nil {
+            logger.Errorf("error fetching AUR repo: %s, %v", output, err)
+            return fmt.Errorf("error fetching AUR repo: %s, %v", output, err)
+        }
+    } else {
+        // Anything else in the way, like a broken earlier clone, is replaced
+        if err := os.RemoveAll(cloneDir); err != nil {
+            logger.Errorf("error removing old clone directory: %v", err)
+            return fmt.Errorf("error removing old clone directory: %v", err)
+        }
+        if err := mkdirAllForBuildUser(filepath.Dir(cloneDir)); err != nil {
+            logger.Errorf("error creating base directory: %v", err)
+            return fmt.Errorf("error creating base directory: %v", err)
+        }
+
+        cmdGitClone, err := newBuildCommand(gitCloneCommand(repoURL, cloneDir))
+        if err != nil {
+            return err
+        }
+        if output, err := cmdGitClone.CombinedOutput(); err != nil {
+            logger.Errorf("error cloning AUR repo: %s, %v", output, err)
+            return fmt.Errorf("error cloning AUR repo: %s, %v", output, err)
+        }
+    }
+
+    ref := commit
+    if ref == "" {
+        ref = aurCloneDefaultRef
+    }
+
+    // A hard reset also undoes any changes to tracked files, so every build starts from a pristine PKGBUILD
+    cmdReset, err := newBuildCommand(gitResetCommand(ref))
+    if err != nil {
+        return err
+    }
+    cmdReset.Dir = cloneDir
+    if output, err := cmdReset.CombinedOutput(); err != nil {
+        logger.Errorf("error checking out %s: %s, %v", ref, output, err)
+        return fmt.Errorf("error checking out %s: %s, %v", ref, output, err)
+    }
+    return nil
+}
@@ -0,0 +1,338 @@
+package packagemanager
+
+// This file is responsible for the cache of built AUR packages. After a build, the archives makepkg
+// produced are moved into ~/.allpac/packages/<name>/, so any version that was built once can be
+// reinstalled or downgraded to without building it again
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the suffix of the file next to a cached archive that records the commit it was built from
+const commitFileSuffix = ".commit"
+
+// CachedAURPackage represents a built AUR package archive in the package cache
+type CachedAURPackage struct {
+    Name    string
+    Version string
+    Path    string
+    Size    int64
+    Time    time.Time
+    // the commit of the AUR repository the archive was built from, if it is known
+    Commit string
+}
+
+// returns the path to the ~/.allpac/packages/ directory
+func getPackageCacheDir() (string, error) {
+    home, err := currentHomeDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(home, ".allpac", "packages"), nil
+}
+
+// lists the archives makepkg produces for the package in the given clone, including split packages
+func makepkgPackageList(cloneDir string, env []string, extraArgs []string) ([]string, error) {
+    cmd, err := newBuildCommand(makepkgPackageListCommand(extraArgs...))
+    if err != nil {
+        return nil, err
+    }
+    cmd.Env = append(cmd.Env, env...)
+    cmd.Dir = cloneDir
+    output, err := cmd.Output()
+    if err != nil {
+        logger.Errorf("error listing the packages built from %s: %v", cloneDir, err)
+        return nil, fmt.Errorf("error listing the packages built from %s: %v", cloneDir, err)
+    }
+
+    var archives []string
+    for _, line := range strings.Split(string(output), "\n") {
+        if line = strings.TrimSpace(line); line != "" {
+            archives = append(archives, line)
+        }
+    }
+    return archives, nil
+}
+
+// moves freshly built archives into the package cache, recording the commit they were built from, and
+// returns their paths in the cache. Archives outside the clone, e.g. in a PKGDEST set by the user, are copied instead
+func cacheBuiltPackages(cloneDir string, archives []string, commit string) ([]string, error) {
+    cacheDir, err := getPackageCacheDir()
+    if err != nil {
+        return nil, err
+    }
+
+    var cached []string
+    for _, archive := range archives {
+        if _, err := os.Stat(archive); err != nil {
+            // Packages that are disabled for this architecture are listed, but never built
+            continue
+        }
+
+        name, _, ok := parseArchiveName(filepath.Base(archive))
+        if !ok {
+            logger.Warnf("unable to tell which package %s belongs to, leaving it out of the package cache", archive)
+            continue
+        }
+
+        targetDir := filepath.Join(cacheDir, name)
+        if err := os.MkdirAll(targetDir, 0755); err != nil {
+            logger.Errorf("error creating package cache directory: %v", err)
+            return cached, fmt.Errorf("error creating package cache directory: %v", err)
+        }
+
+        target := filepath.Join(targetDir, filepath.Base(archive))
+        if err := moveOrCopyFile(archive, target, isWithinDir(cloneDir, archive)); err != nil {
+            logger.Errorf("error caching %s: %v", archive, err)
+            return cached, fmt.Errorf("error caching %s: %v", archive, err)
+        }
+        if _, err := os.Stat(archive + ".sig"); err == nil {
+            if err := moveOrCopyFile(archive+".sig", target+".sig", isWithinDir(cloneDir, archive)); err != nil {
+                logger.Warnf("error caching the signature of %s: %v", archive, err)
+            }
+        }
+        if commit != "" {
+            if err := os.WriteFile(target+commitFileSuffix, []byte(commit+"\n"), 0644); err != nil {
+                logger.Warnf("error recording the commit of %s: %v", target, err)
+            }
+        }
+        logger.Infof("Cached built package %s", target)
+        cached = append(cached, target)
+    }
+    return cached, nil
+}
+
+// splits an archive name like "foo-1:2.0-1-x86_64.pkg.tar.zst" into the package name and version
+func parseArchiveName(fileName string) (string, string, bool) {
+    index := strings.Index(fileName, ".pkg.tar")
+    if index < 0 {
+        return "", "", false
+    }
+    stem := fileName[:index]
+
+    // The last three fields are the version, the release and the architecture
+    parts := strings.Split(stem, "-")
+    if len(parts) < 4 {
+        return "", "", false
+    }
+    name := strings.Join(parts[:len(parts)-3], "-")
+    version := parts[len(parts)-3] + "-" + parts[len(parts)-2]
+    return name, version, true
+}
+
+// lists the cached archives of a package, newest first
+func ListCachedAURPackages(packageName string) ([]CachedAURPackage, error) {
+    cacheDir, err := getPackageCacheDir()
+    if err != nil {
+        return nil, err
+    }
+
+    entries, err := os.ReadDir(filepath.Join(cacheDir, packageName))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        logger.Errorf("error reading package cache: %v", err)
+        return nil, fmt.Errorf("error reading package cache: %v", err)
+    }
+
+    var packages []CachedAURPackage
+    for _, entry := range entries {
+        name, version, ok := parseArchiveName(entry.Name())
+        if !ok || name != packageName || entry.IsDir() || strings.HasSuffix(entry.Name(), ".sig") || strings.HasSuffix(entry.Name(), commitFileSuffix) {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+
+        path := filepath.Join(cacheDir, packageName, entry.Name())
+        cached := CachedAURPackage{Name: name, Version: version, Path: path, Size: info.Size(), Time: info.ModTime()}
+        if commit, err := os.ReadFile(path + commitFileSuffix); err == nil {
+            cached.Commit = strings.TrimSpace(string(commit))
+        }
+        packages = append(packages, cached)
+    }
+
+    sort.Slice(packages, func(i, j int) bool { return packages[i].Time.After(packages[j].Time) })
+    return packages, nil
+}
+
+// finds the cached archive of a specific version of a package
+func findCachedAURPackage(packageName, version string) (CachedAURPackage, error) {
+    packages, err := ListCachedAURPackages(packageName)
+    if err != nil {
+        return CachedAURPackage{}, err
+    }
+    for _, cached := range packages {
+        if cached.Version == version {
+            return cached, nil
+        }
+    }
+
+    logger.Errorf("version %s of %s is not in the AllPac package cache", version, packageName)
+    return CachedAURPackage{}, fmt.Errorf("version %s of %s is not in the AllPac package cache", version, packageName)
+}
+
+// installs a cached version of an AUR package without building it, and records it in the package list
+func InstallCachedAURPackage(packageName, version string) error {
+    cached, err := findCachedAURPackage(packageName, version)
+    if err != nil {
+        return err
+    }
+
+    cmd := newCommand(pacmanInstallArchiveCommand(cached.Path))
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error installing %s: %s, %v", cached.Path, output, err)
+        return fmt.Errorf("error installing %s: %s, %v", cached.Path, output, err)
+    }
+
+    if err := LogInstallation(packageName, "aur", version); err != nil {
+        logger.Errorf("error logging installation: %v", err)
+        return fmt.Errorf("error logging installation: %v", err)
+    }
+    return updatePackageInfo(packageName, func(pkgInfo *PackageInfo) { pkgInfo.Commit = cached.Commit })
+}
+
+// reinstalls the recorded version of an AUR package from the package cache
+func ReinstallCachedAURPackage(packageName string) error {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pkgInfo, found := pkgList[packageName]
+    if !found || pkgInfo.Source != "aur" {
+        logger.Errorf("package %s is not found or not an AUR package", packageName)
+        return fmt.Errorf("package %s is not found or not an AUR package", packageName)
+    }
+    return InstallCachedAURPackage(packageName, pkgInfo.Version)
+}
+
+// downgrades (or upgrades) a package to the given version: AUR packages come from AllPac's package cache,
+// pacman packages from the pacman cache
+func DowngradePackage(packageName, version string) error {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pkgInfo, found := pkgList[packageName]
+    if !found {
+        logger.Errorf("package %s not found in package list", packageName)
+        return fmt.Errorf("package %s not found in package list", packageName)
+    }
+
+    switch pkgInfo.Source {
+    case "aur":
+        return InstallCachedAURPackage(packageName, version)
+    case "pacman":
+        return InstallPacmanPackageFromCache(packageName, version)
+    default:
+        logger.Errorf("downgrading %s packages is not supported", pkgInfo.Source)
+        return fmt.Errorf("downgrading %s packages is not supported", pkgInfo.Source)
+    }
+}
+
+// removes cached archives beyond the newest keep ones of every package, and archives older than maxAge.
+// A zero keep or maxAge disables that limit. The archive of the recorded version is always kept
+func PruneAURPackageCache(keep int, maxAge time.Duration) ([]CachedAURPackage, error) {
+    cacheDir, err := getPackageCacheDir()
+    if err != nil {
+        return nil, err
+    }
+
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    entries, err := os.ReadDir(cacheDir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        logger.Errorf("error reading package cache: %v", err)
+        return nil, fmt.Errorf("error reading package cache: %v", err)
+    }
+
+    var removed []CachedAURPackage
+    for _, entry := range entries {
+        if !entry.IsDir() {
+            continue
+        }
+
+        packages, err := ListCachedAURPackages(entry.Name())
+        if err != nil {
+            return removed, err
+        }
+
+        for i, cached := range packages {
+            if pkgInfo, exists := pkgList[cached.Name]; exists && pkgInfo.Version == cached.Version {
+                continue
+            }
+            tooMany := keep > 0 && i >= keep
+            tooOld := maxAge > 0 && time.Since(cached.Time) > maxAge
+            if !tooMany && !tooOld {
+                continue
+            }
+
+            for _, path := range []string{cached.Path, cached.Path + ".sig", cached.Path + commitFileSuffix} {
+                if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+                    logger.Errorf("error removing %s: %v", path, err)
+                    return removed, fmt.Errorf("error removing %s: %v", path, err)
+                }
+            }
+            logger.Infof("Removed cached package %s", cached.Path)
+            removed = append(removed, cached)
+        }
+    }
+    return removed, nil
+}
+
+// reports whether the path is inside the given directory
+func isWithinDir(dir, path string) bool {
+    rel, err := filepath.Rel(dir, path)
+    return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moves a file, or copies it when move is false or the file can't be renamed across filesystems
+func moveOrCopyFile(source, target string, move bool) error {
+    if move {
+        if err := os.Rename(source, target); err == nil {
+            return nil
+        }
+    }
+
+    in, err := os.Open(source)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+
+    out, err := os.Create(target)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, in); err != nil {
+        out.Close()
+        return err
+    }
+    if err := out.Close(); err != nil {
+        return err
+    }
+
+    if move {
+        return os.Remove(source)
+    }
+    return nil
+}
@@ -0,0 +1,32 @@
+package packagemanager
+
+import (
+    "testing"
+)
+
+func TestParseArchiveName(t *testing.T) {
+    tests := []struct {
+        fileName    string
+        wantName    string
+        wantVersion string
+        wantOK      bool
+    }{
+        {"yay-12.3.5-1-x86_64.pkg.tar.zst", "yay", "12.3.5-1", true},
+        {"python-requests-2.31.0-1-any.pkg.tar.xz", "python-requests", "2.31.0-1", true},
+        {"foo-1:2.0-1-x86_64.pkg.tar.zst", "foo", "1:2.0-1", true},
+        {"neovim-git-0.10.0.r1234.gabcdef-1-x86_64.pkg.tar", "neovim-git", "0.10.0.r1234.gabcdef-1", true},
+        // Signatures parse like their archives, callers skip them by their suffix
+        {"yay-12.3.5-1-x86_64.pkg.tar.zst.sig", "yay", "12.3.5-1", true},
+        {"yay-12.3.5-x86_64.pkg.tar.zst", "", "", false},
+        {"PKGBUILD", "", "", false},
+        {"yay-12.3.5-1-x86_64.tar.gz", "", "", false},
+    }
+
+    for _, test := range tests {
+        name, version, ok := parseArchiveName(test.fileName)
+        if name != test.wantName || version != test.wantVersion || ok != test.wantOK {
+            t.Errorf("parseArchiveName(%q) = %q, %q, %v, want %q, %q, %v",
+                test.fileName, name, version, ok, test.wantName, test.wantVersion, test.wantOK)
+        }
+    }
+}
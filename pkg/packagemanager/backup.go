@@ -0,0 +1,228 @@
+package packagemanager
+
+// This file is responsible for keeping rotating backups of the package list, and restoring them
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+const (
+    backupDirname    = "backups"
+    backupTimeFormat = "20060102-150405"
+)
+
+// tracks whether the package list was already backed up during this run, so every
+// command that changes the package list leaves exactly one backup behind
+var (
+    backupMutex     sync.Mutex
+    backedUpThisRun bool
+)
+
+// PackageListBackup represents a backup copy of the package list
+type PackageListBackup struct {
+    ID   string
+    Path string
+    Time time.Time
+    Size int64
+}
+
+// returns the directory holding the package list backups, creating it if needed
+func getBackupDir() (string, error) {
+    pkgListPath, err := GetPkgListPath()
+    if err != nil {
+        return "", err
+    }
+
+    backupDir := filepath.Join(filepath.Dir(pkgListPath), backupDirname)
+    if err := os.MkdirAll(backupDir, 0755); err != nil {
+        logger.Errorf("error creating backup directory: %v", err)
+        return "", fmt.Errorf("error creating backup directory: %v", err)
+    }
+    return backupDir, nil
+}
+
+// copies the current package list file into the backup directory, even if it can't be parsed,
+// and returns the path of the backup
+func BackupPackageList() (string, error) {
+    pkgListPath, err := GetPkgListPath()
+    if err != nil {
+        return "", err
+    }
+
+    data, err := os.ReadFile(pkgListPath)
+    if err != nil {
+        logger.Errorf("error reading package list file: %v", err)
+        return "", fmt.Errorf("error reading package list file: %v", err)
+    }
+
+    backupDir, err := getBackupDir()
+    if err != nil {
+        return "", err
+    }
+
+    // Name the backup after the current time, adding a counter if we already made one this second
+    id := time.Now().Format(backupTimeFormat)
+    backupPath := filepath.Join(backupDir, pkgListFilename+"."+id)
+    for i := 1; fileExists(backupPath); i++ {
+        backupPath = filepath.Join(backupDir, fmt.Sprintf("%s.%s-%d", pkgListFilename, id, i))
+    }
+
+    if err := os.WriteFile(backupPath, data, 0600); err != nil {
+        logger.Errorf("error writing package list backup: %v", err)
+        return "", fmt.Errorf("error writing package list backup: %v", err)
+    }
+
+    backedUpThisRun = true
+    logger.Infof("Package list backed up to %s", backupPath)
+    return backupPath, nil
+}
+
+// backs up the package list before the first write of this run and rotates old backups.
+// failures are only logged, a missing backup should never block the change itself
+func backupPackageListAutomatically() {
+    backupMutex.Lock()
+    defer backupMutex.Unlock()
+
+    if backedUpThisRun {
+        return
+    }
+
+    config, err := ReadConfig()
+    if err != nil {
+        logger.Warnf("using default backup retention: %v", err)
+    }
+    if config.BackupRetention <= 0 {
+        return
+    }
+
+    if _, err := BackupPackageList(); err != nil {
+        logger.Warnf("unable to back up package list: %v", err)
+        return
+    }
+    if err := pruneBackups(config.BackupRetention); err != nil {
+        logger.Warnf("unable to remove old package list backups: %v", err)
+    }
+}
+
+// removes all but the newest keep backups
+func pruneBackups(keep int) error {
+    backups, err := ListBackups()
+    if err != nil {
+        return err
+    }
+
+    for i := keep; i < len(backups); i++ {
+        if err := os.Remove(backups[i].Path); err != nil {
+            logger.Errorf("error removing backup %s: %v", backups[i].ID, err)
+            return fmt.Errorf("error removing backup %s: %v", backups[i].ID, err)
+        }
+        logger.Infof("Removed old package list backup %s", backups[i].ID)
+    }
+    return nil
+}
+
+// lists the package list backups, newest first
+func ListBackups() ([]PackageListBackup, error) {
+    backupDir, err := getBackupDir()
+    if err != nil {
+        return nil, err
+    }
+
+    entries, err := os.ReadDir(backupDir)
+    if err != nil {
+        logger.Errorf("error reading backup directory: %v", err)
+        return nil, fmt.Errorf("error reading backup directory: %v", err)
+    }
+
+    var backups []PackageListBackup
+    for _, entry := range entries {
+        if entry.IsDir() || !strings.HasPrefix(entry.Name(), pkgListFilename+".") {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+
+        id := strings.TrimPrefix(entry.Name(), pkgListFilename+".")
+        backupTime := info.ModTime()
+        if len(id) >= len(backupTimeFormat) {
+            if parsed, err := time.ParseInLocation(backupTimeFormat, id[:len(backupTimeFormat)], time.Local); err == nil {
+                backupTime = parsed
+            }
+        }
+
+        backups = append(backups, PackageListBackup{
+            ID:   id,
+            Path: filepath.Join(backupDir, entry.Name()),
+            Time: backupTime,
+            Size: info.Size(),
+        })
+    }
+
+    sort.Slice(backups, func(i, j int) bool {
+        if !backups[i].Time.Equal(backups[j].Time) {
+            return backups[i].Time.After(backups[j].Time)
+        }
+        return backups[i].ID > backups[j].ID
+    })
+    return backups, nil
+}
+
+// restores the package list from the backup with the given ID, after checking that it parses.
+// the current package list is backed up first, so a restore can itself be undone
+func RestoreBackup(id string) error {
+    backups, err := ListBackups()
+    if err != nil {
+        return err
+    }
+
+    var backup *PackageListBackup
+    for i := range backups {
+        if backups[i].ID == id {
+            backup = &backups[i]
+            break
+        }
+    }
+    if backup == nil {
+        logger.Errorf("backup %s not found", id)
+        return fmt.Errorf("backup %s not found", id)
+    }
+
+    data, err := os.ReadFile(backup.Path)
+    if err != nil {
+        logger.Errorf("error reading backup %s: %v", id, err)
+        return fmt.Errorf("error reading backup %s: %v", id, err)
+    }
+
+    var pkgList PackageList
+    if err := json.Unmarshal(data, &pkgList); err != nil || pkgList == nil {
+        logger.Errorf("backup %s is not a valid package list: %v", id, err)
+        return fmt.Errorf("backup %s is not a valid package list: %v", id, err)
+    }
+
+    if _, err := BackupPackageList(); err != nil {
+        return err
+    }
+
+    if err := writePackageList(pkgList); err != nil {
+        return err
+    }
+
+    logger.Infof("Package list restored from backup %s", id)
+    return nil
+}
+
+// checks if a file exists at the given path
+func fileExists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
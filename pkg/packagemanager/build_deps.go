@@ -0,0 +1,102 @@
+package packagemanager
+
+// This file is responsible for the dependencies of AUR packages built on the host while AllPac runs as root.
+// makepkg -s would install them with sudo as the build user, so instead they are installed as root before
+// makepkg runs, and in the remove-makedepends mode removed again afterwards, like makepkg --rmdeps does
+
+import (
+    "errors"
+    "fmt"
+    "os/exec"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the .SRCINFO keys makepkg -s installs the dependencies of
+var buildDependencyKeys = []string{"depends", "makedepends", "checkdepends"}
+
+// returns the machine architecture, the one the arch-specific .SRCINFO keys are named after
+func hostArchitecture() string {
+    if output, err := exec.Command("uname", "-m").Output(); err == nil {
+        return strings.TrimSpace(string(output))
+    }
+    return "x86_64"
+}
+
+// returns the dependencies a build needs, including the ones only the given architecture needs,
+// across the pkgbase and all pkgname sections
+func buildDependencies(srcinfo map[string][]string, arch string) []string {
+    var dependencies []string
+    seen := make(map[string]bool)
+    for _, key := range buildDependencyKeys {
+        for _, dependency := range append(srcinfo[key], srcinfo[key+"_"+arch]...) {
+            if dependency != "" && !seen[dependency] {
+                seen[dependency] = true
+                dependencies = append(dependencies, dependency)
+            }
+        }
+    }
+    return dependencies
+}
+
+// returns the dependencies no installed package satisfies
+func missingDependencies(dependencies []string) ([]string, error) {
+    output, err := newCommand(pacmanDeptestCommand(dependencies...)).Output()
+    // pacman -T exits with 127 when it prints missing dependencies
+    var exitErr *exec.ExitError
+    if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 127) {
+        logger.Errorf("error checking for missing dependencies: %v", err)
+        return nil, fmt.Errorf("error checking for missing dependencies: %v", err)
+    }
+    return strings.Fields(string(output)), nil
+}
+
+// installs the missing build dependencies of the package in a clone as root, returning the names of
+// the packages that were installed for them
+func installBuildDependencies(packageName, cloneDir string) ([]string, error) {
+    srcinfo, err := cloneSrcinfo(cloneDir)
+    if err != nil {
+        return nil, err
+    }
+    missing, err := missingDependencies(buildDependencies(srcinfo, hostArchitecture()))
+    if err != nil || len(missing) == 0 {
+        return nil, err
+    }
+
+    before, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("Installing the build dependencies of %s: %s", packageName, strings.Join(missing, ", "))
+    if output, err := newCommand(pacmanInstallDependenciesCommand(missing...)).CombinedOutput(); err != nil {
+        logger.Errorf("error installing the build dependencies of %s: %s, %v", packageName, output, err)
+        return nil, fmt.Errorf("error installing the build dependencies of %s: %s, %v", packageName, output, err)
+    }
+    after, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+
+    var installed []string
+    for name := range after {
+        if _, ok := before[name]; !ok {
+            installed = append(installed, name)
+        }
+    }
+    sort.Strings(installed)
+    return installed, nil
+}
+
+// removes the packages installBuildDependencies installed. A failure only leaves them installed,
+// so it is logged instead of failing the build
+func removeBuildDependencies(packageName string, installed []string) {
+    if len(installed) == 0 {
+        return
+    }
+    if output, err := newCommand(pacmanRemoveDependenciesCommand(installed...)).CombinedOutput(); err != nil {
+        logger.Warnf("unable to remove the build dependencies of %s: %s, %v", packageName, output, err)
+        return
+    }
+    logger.Infof("Removed the build dependencies of %s: %s", packageName, strings.Join(installed, ", "))
+}
@@ -0,0 +1,31 @@
+package packagemanager
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestBuildDependencies(t *testing.T) {
+    srcinfo, err := parseSrcinfo(strings.NewReader("pkgbase = foo\n" +
+        "\tmakedepends = git\n" +
+        "\tmakedepends = cmake>=3.20\n" +
+        "\tmakedepends_x86_64 = nasm\n" +
+        "\tmakedepends_aarch64 = gcc-aarch64\n" +
+        "\tcheckdepends = python-pytest\n" +
+        "\tdepends = glibc\n" +
+        "\toptdepends = foo-extras: extra things\n" +
+        "\npkgname = foo\n" +
+        "\npkgname = foo-libs\n" +
+        "\tdepends = glibc\n" +
+        "\tdepends_x86_64 = lib32-glibc\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got := buildDependencies(srcinfo, "x86_64")
+    want := []string{"glibc", "lib32-glibc", "git", "cmake>=3.20", "nasm", "python-pytest"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("buildDependencies() = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,141 @@
+package packagemanager
+
+// This file is responsible for the environment AUR packages are built in. The clone of an AUR repository
+// is never modified: extra environment variables are passed to makepkg directly, and makepkg.conf
+// overrides go into a generated config that layers them over the system's /etc/makepkg.conf
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the system makepkg configuration every generated config starts from
+const systemMakepkgConf = "/etc/makepkg.conf"
+
+// the drop-in directory makepkg reads next to its config, e.g. rust.conf from the rust package. makepkg only
+// looks next to the file --config names, so the generated configs source the system one themselves
+const systemMakepkgConfDir = systemMakepkgConf + ".d"
+
+// AURBuildConfig represents the build settings of AUR packages, either for every package or a single one
+type AURBuildConfig struct {
+    // extra environment variables for makepkg, values may refer to other variables like $HOME
+    Env map[string]string `json:"env,omitempty"`
+    // the absolute path to a makepkg.conf fragment applied on top of /etc/makepkg.conf and /etc/makepkg.conf.d,
+    // e.g. to change MAKEFLAGS
+    MakepkgConf string `json:"makepkg_conf,omitempty"`
+    // how the package is built: BuildModeHost, BuildModeRemoveMakedepends or BuildModeChroot
+    Mode string `json:"mode,omitempty"`
+}
+
+// returns the build environment AllPac always sets, which the configuration can override
+func defaultBuildEnv() map[string]string {
+    return map[string]string{
+        // Go packages otherwise try to write their build cache next to the sources
+        "GOCACHE": "$HOME/.cache/go-build",
+    }
+}
+
+// returns the build settings of a package, with its own settings layered over the global ones
+func (c Config) buildConfigFor(packageName string) AURBuildConfig {
+    merged := AURBuildConfig{Env: defaultBuildEnv(), MakepkgConf: c.AURBuild.MakepkgConf, Mode: c.AURBuild.Mode}
+    for key, value := range c.AURBuild.Env {
+        merged.Env[key] = value
+    }
+
+    if pkgConfig, ok := c.AURPackages[packageName]; ok {
+        for key, value := range pkgConfig.Env {
+            merged.Env[key] = value
+        }
+        if pkgConfig.MakepkgConf != "" {
+            merged.MakepkgConf = pkgConfig.MakepkgConf
+        }
+        if pkgConfig.Mode != "" {
+            merged.Mode = pkgConfig.Mode
+        }
+    }
+    return merged
+}
+
+// returns the environment variables makepkg gets on top of AllPac's own environment, sorted by name
+func makepkgBuildEnv(homeDir string, buildConfig AURBuildConfig) []string {
+    lookup := func(name string) string {
+        if name == "HOME" {
+            return homeDir
+        }
+        return os.Getenv(name)
+    }
+
+    env := []string{"HOME=" + homeDir}
+    var keys []string
+    for key := range buildConfig.Env {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        env = append(env, key+"="+os.Expand(buildConfig.Env[key], lookup))
+    }
+    return env
+}
+
+// returns the path of the generated makepkg config of a package, outside of its clone
+func makepkgConfPath(homeDir, packageName string) string {
+    return filepath.Join(homeDir, ".allpac", "makepkg", packageName+".conf")
+}
+
+// returns the makepkg arguments that apply the package's makepkg.conf fragment, if it has one
+func makepkgConfArgs(homeDir, packageName string, buildConfig AURBuildConfig) []string {
+    if buildConfig.MakepkgConf == "" {
+        return nil
+    }
+    return []string{"--config", makepkgConfPath(homeDir, packageName)}
+}
+
+// writes the makepkg config of a package, which sources the system config with its drop-ins and then
+// the configured fragment
+func writeMakepkgConf(homeDir, packageName string, buildConfig AURBuildConfig) error {
+    if buildConfig.MakepkgConf == "" {
+        return nil
+    }
+
+    // makepkg sources the fragment from the clone, so a relative path would point somewhere else than it seems
+    if !filepath.IsAbs(buildConfig.MakepkgConf) {
+        logger.Errorf("the makepkg.conf fragment %s for %s must be an absolute path", buildConfig.MakepkgConf, packageName)
+        return fmt.Errorf("the makepkg.conf fragment %s for %s must be an absolute path", buildConfig.MakepkgConf, packageName)
+    }
+    if _, err := os.Stat(buildConfig.MakepkgConf); err != nil {
+        logger.Errorf("error reading makepkg.conf fragment for %s: %v", packageName, err)
+        return fmt.Errorf("error reading makepkg.conf fragment for %s: %v", packageName, err)
+    }
+
+    confPath := makepkgConfPath(homeDir, packageName)
+    if err := mkdirAllForBuildUser(filepath.Dir(confPath)); err != nil {
+        logger.Errorf("error creating makepkg config directory: %v", err)
+        return fmt.Errorf("error creating makepkg config directory: %v", err)
+    }
+
+    content := fmt.Sprintf("# Generated by AllPac for %s, changes are overwritten on the next build\n"+
+        "source %s\n"+
+        "for allpac_conf in %s/*.conf; do\n    if [[ -f $allpac_conf ]]; then source \"$allpac_conf\"; fi\ndone\nunset allpac_conf\n"+
+        "source %s\n",
+        packageName, ShellQuote(systemMakepkgConf), ShellQuote(systemMakepkgConfDir), ShellQuote(buildConfig.MakepkgConf))
+    if err := os.WriteFile(confPath, []byte(content), 0644); err != nil {
+        logger.Errorf("error writing makepkg config for %s: %v", packageName, err)
+        return fmt.Errorf("error writing makepkg config for %s: %v", packageName, err)
+    }
+    return nil
+}
+
+// quotes a value for a shell, like the makepkg.conf AllPac generates or a printed command.
+// Values that are safe as they are, like most paths, stay unquoted
+func ShellQuote(value string) string {
+    if value != "" && strings.IndexFunc(value, func(r rune) bool {
+        return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+    }) == -1 {
+        return value
+    }
+    return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
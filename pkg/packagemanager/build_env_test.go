@@ -0,0 +1,49 @@
+package packagemanager
+
+import (
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestWriteMakepkgConfRejectsRelativePaths(t *testing.T) {
+    err := writeMakepkgConf(t.TempDir(), "foo", AURBuildConfig{MakepkgConf: "no-lto.conf"})
+    if err == nil || !strings.Contains(err.Error(), "absolute path") {
+        t.Errorf("writeMakepkgConf() with a relative fragment error = %v, want an absolute path error", err)
+    }
+}
+
+func TestWriteMakepkgConf(t *testing.T) {
+    if runningAsRoot() {
+        t.Skip("the config is written for the build user when the tests run as root")
+    }
+
+    homeDir := t.TempDir()
+    fragment := filepath.Join(homeDir, "no lto.conf")
+    if err := os.WriteFile(fragment, []byte("OPTIONS+=(!lto)\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := writeMakepkgConf(homeDir, "foo", AURBuildConfig{MakepkgConf: fragment}); err != nil {
+        t.Fatalf("writeMakepkgConf() error = %v", err)
+    }
+
+    content, err := os.ReadFile(makepkgConfPath(homeDir, "foo"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The drop-ins come after the system config and before the fragment
+    system := strings.Index(string(content), "source /etc/makepkg.conf\n")
+    dropIns := strings.Index(string(content), "/etc/makepkg.conf.d/*.conf")
+    user := strings.Index(string(content), "source '"+fragment+"'")
+    if system < 0 || dropIns < system || user < dropIns {
+        t.Errorf("writeMakepkgConf() wrote:\n%s", content)
+    }
+
+    if _, err := exec.LookPath("bash"); err == nil {
+        if output, err := exec.Command("bash", "-n", makepkgConfPath(homeDir, "foo")).CombinedOutput(); err != nil {
+            t.Errorf("the generated config is not valid bash: %s, %v", output, err)
+        }
+    }
+}
@@ -0,0 +1,156 @@
+package packagemanager
+
+// This file is responsible for the ways AUR packages can be built. By default makepkg builds on the host and
+// leaves the makedepends installed. It can remove them again after the build instead, or the package is built
+// in a clean chroot with devtools, so nothing but the built package ever gets installed on the host
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the modes AUR packages can be built in
+const (
+    // build on the host with makepkg, keeping the makedepends
+    BuildModeHost = "host"
+    // build on the host with makepkg, removing the makedepends it installed afterwards
+    BuildModeRemoveMakedepends = "remove-makedepends"
+    // build in a clean chroot with makechrootpkg
+    BuildModeChroot = "chroot"
+)
+
+// returns the directory the build chroot lives in, the configured one or ~/.allpac/chroot
+func buildChrootDir(homeDir string, config Config) string {
+    if config.ChrootDir != "" {
+        return config.ChrootDir
+    }
+    return filepath.Join(homeDir, ".allpac", "chroot")
+}
+
+// builds the package in a clone following its build mode, without installing it. The archives are
+// installed by installBuiltArchives afterwards, so pacman is the only thing that runs as root
+func runAURBuildCommand(packageName, cloneDir, homeDir string, config Config, buildConfig AURBuildConfig) error {
+    var command []string
+    var env []string
+    switch buildConfig.Mode {
+    case BuildModeChroot:
+        chrootDir := buildChrootDir(homeDir, config)
+        if err := ensureBuildChroot(chrootDir); err != nil {
+            return err
+        }
+        if len(config.AURBuild.Env) > 0 || len(config.AURPackages[packageName].Env) > 0 || buildConfig.MakepkgConf != "" {
+            logger.Warnf("the build environment and makepkg.conf settings of %s don't apply to chroot builds", packageName)
+        }
+        command = makechrootpkgCommand(chrootDir)
+    case BuildModeHost, BuildModeRemoveMakedepends, "":
+        // makepkg installs the dependencies with sudo, which the build user may not be allowed to use,
+        // so as root they're installed before makepkg runs and removed again by us instead of --rmdeps
+        if runningAsRoot() {
+            installed, err := installBuildDependencies(packageName, cloneDir)
+            if err != nil {
+                return err
+            }
+            if buildConfig.Mode == BuildModeRemoveMakedepends {
+                defer removeBuildDependencies(packageName, installed)
+            }
+        }
+        command = makepkgBuildCommand(makepkgBuildArgs(homeDir, packageName, buildConfig)...)
+        env = makepkgBuildEnv(homeDir, buildConfig)
+    default:
+        logger.Errorf("unknown build mode %q for %s", buildConfig.Mode, packageName)
+        return fmt.Errorf("unknown build mode %q for %s", buildConfig.Mode, packageName)
+    }
+
+    cmd, err := newBuildCommand(command)
+    if err != nil {
+        return err
+    }
+    cmd.Env = append(cmd.Env, env...)
+    cmd.Dir = cloneDir
+    if output, err := cmd.CombinedOutput(); err != nil {
+        if pgpErr := makepkgPGPError(packageName, output); pgpErr != nil {
+            logger.Errorf("error verifying the sources of %s: %v", packageName, pgpErr)
+            return pgpErr
+        }
+        logger.Errorf("error building package with %s: %s, %v", cmd.Args[0], output, err)
+        return fmt.Errorf("error building package with %s: %s, %v", cmd.Args[0], output, err)
+    }
+    return nil
+}
+
+// returns the extra makepkg arguments of the host build modes. makepkg only installs and removes the
+// dependencies itself when AllPac doesn't run as root
+func makepkgBuildArgs(homeDir, packageName string, buildConfig AURBuildConfig) []string {
+    args := makepkgConfArgs(homeDir, packageName, buildConfig)
+    if runningAsRoot() {
+        return args
+    }
+    if buildConfig.Mode == BuildModeRemoveMakedepends {
+        args = append([]string{"--rmdeps"}, args...)
+    }
+    return append([]string{"--syncdeps"}, args...)
+}
+
+// returns the command that prepares the build chroot, creating it if it doesn't exist yet
+func buildChrootCommand(chrootDir string) []string {
+    rootDir := filepath.Join(chrootDir, "root")
+    if _, err := os.Stat(rootDir); os.IsNotExist(err) {
+        return mkarchrootCommand(rootDir)
+    }
+    return archNspawnUpgradeCommand(rootDir)
+}
+
+// creates the build chroot with base-devel if it doesn't exist yet, otherwise brings it up to date,
+// installing devtools first if it's missing
+func ensureBuildChroot(chrootDir string) error {
+    if _, err := exec.LookPath("makechrootpkg"); err != nil {
+        if !ConfirmAction("Building in a chroot needs devtools, which isn't installed. Do you want to install it?") {
+            logger.Warnf("user declined installing devtools")
+            return fmt.Errorf("building in a chroot needs devtools, which isn't installed")
+        }
+        if err := InstallDevtools(); err != nil {
+            return err
+        }
+    }
+
+    if err := mkdirAllForBuildUser(chrootDir); err != nil {
+        logger.Errorf("error creating chroot directory: %v", err)
+        return fmt.Errorf("error creating chroot directory: %v", err)
+    }
+
+    if output, err := newCommand(buildChrootCommand(chrootDir)).CombinedOutput(); err != nil {
+        logger.Errorf("error preparing the build chroot %s: %s, %v", chrootDir, output, err)
+        return fmt.Errorf("error preparing the build chroot %s: %s, %v", chrootDir, output, err)
+    }
+    return nil
+}
+
+// installs the archives a build produced with pacman, leaving out the ones that weren't built
+// and debug packages, like makepkg -i does
+func installBuiltArchives(packageName string, archives []string) error {
+    var toInstall []string
+    for _, archive := range archives {
+        name, _, ok := parseArchiveName(filepath.Base(archive))
+        if !ok || strings.HasSuffix(name, "-debug") {
+            continue
+        }
+        if _, err := os.Stat(archive); err == nil {
+            toInstall = append(toInstall, archive)
+        }
+    }
+    if len(toInstall) == 0 {
+        logger.Errorf("no built archives of %s were found", packageName)
+        return fmt.Errorf("no built archives of %s were found", packageName)
+    }
+
+    cmd := newCommand(pacmanInstallArchiveCommand(toInstall...))
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error installing %s: %s, %v", packageName, output, err)
+        return fmt.Errorf("error installing %s: %s, %v", packageName, output, err)
+    }
+    return nil
+}
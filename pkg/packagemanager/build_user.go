@@ -0,0 +1,193 @@
+package packagemanager
+
+// This file is responsible for who AUR packages are built as. makepkg refuses to run as root, so when AllPac
+// itself runs as root (e.g. through sudo), git, gpg and makepkg run as an unprivileged build user instead:
+// the configured build_user, or the user who ran sudo. Their clones and keyring live in that user's home,
+// and only the steps that change the system, like pacman -U, keep root's privileges
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "os/user"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+var (
+    buildUserOnce sync.Once
+    buildUser     *user.User
+    buildUserErr  error
+)
+
+// reports whether AllPac runs as root
+func runningAsRoot() bool {
+    return os.Geteuid() == 0
+}
+
+// returns the user AUR packages are built as. That's the current user, unless AllPac runs as root
+func getBuildUser() (*user.User, error) {
+    buildUserOnce.Do(func() {
+        buildUser, buildUserErr = lookupBuildUser()
+    })
+    return buildUser, buildUserErr
+}
+
+func lookupBuildUser() (*user.User, error) {
+    if !runningAsRoot() {
+        usr, err := user.Current()
+        if err != nil {
+            logger.Errorf("error getting current user: %v", err)
+            return nil, fmt.Errorf("error getting current user: %v", err)
+        }
+        // Builds live next to the rest of ~/.allpac
+        if usr.HomeDir, err = currentHomeDir(); err != nil {
+            return nil, err
+        }
+        return usr, nil
+    }
+
+    config, err := ReadConfig()
+    if err != nil {
+        logger.Warnf("unable to read the config, looking for the build user in $SUDO_USER: %v", err)
+    }
+    username := config.BuildUser
+    if username == "" {
+        username = os.Getenv("SUDO_USER")
+    }
+    if username == "" || username == "root" {
+        logger.Errorf("makepkg can't run as root, run AllPac with sudo or set build_user in the config")
+        return nil, fmt.Errorf("makepkg can't run as root, run AllPac with sudo or set build_user in the config")
+    }
+
+    usr, err := user.Lookup(username)
+    if err != nil {
+        logger.Errorf("error looking up build user %s: %v", username, err)
+        return nil, fmt.Errorf("error looking up build user %s: %v", username, err)
+    }
+    if usr.Uid == "0" {
+        logger.Errorf("the build user %s is root, which makepkg doesn't allow", username)
+        return nil, fmt.Errorf("the build user %s is root, which makepkg doesn't allow", username)
+    }
+    return usr, nil
+}
+
+// the PATH of the build user, as root's PATH may point into directories only root should run things from
+const buildUserPath = "/usr/local/sbin:/usr/local/bin:/usr/bin"
+
+// the variables of AllPac's environment the build user keeps when AllPac runs as root, besides the LC_*
+// locale variables. Everything else, like SUDO_* or root's GNUPGHOME, stays with root
+var buildUserEnvAllowList = []string{
+    "LANG", "LANGUAGE", "TERM", "TZ",
+    "http_proxy", "https_proxy", "ftp_proxy", "no_proxy", "all_proxy",
+    "HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "NO_PROXY", "ALL_PROXY",
+}
+
+// returns the minimal environment commands run with as the build user, like a login of that user would get
+func buildUserEnv(usr *user.User) []string {
+    env := []string{"PATH=" + buildUserPath, "HOME=" + usr.HomeDir, "USER=" + usr.Username, "LOGNAME=" + usr.Username}
+    allowed := make(map[string]bool)
+    for _, name := range buildUserEnvAllowList {
+        allowed[name] = true
+    }
+    for _, variable := range os.Environ() {
+        name, _, _ := strings.Cut(variable, "=")
+        if allowed[name] || strings.HasPrefix(name, "LC_") {
+            env = append(env, variable)
+        }
+    }
+    return env
+}
+
+// creates an exec.Cmd that runs as the build user. When AllPac runs as root, the process gets the build
+// user's credentials and a minimal environment of its own, otherwise it's a plain command with AllPac's environment
+func newBuildCommand(command []string) (*exec.Cmd, error) {
+    cmd := newCommand(command)
+    if !runningAsRoot() {
+        cmd.Env = os.Environ()
+        return cmd, nil
+    }
+
+    usr, err := getBuildUser()
+    if err != nil {
+        return nil, err
+    }
+    credential, err := userCredential(usr)
+    if err != nil {
+        return nil, err
+    }
+
+    cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+    cmd.Env = buildUserEnv(usr)
+    // git refuses to start in a working directory the build user can't read, like root's home
+    if _, err := os.Stat(usr.HomeDir); err == nil {
+        cmd.Dir = usr.HomeDir
+    } else {
+        cmd.Dir = os.TempDir()
+    }
+    return cmd, nil
+}
+
+// returns the credentials of a user, including their supplementary groups
+func userCredential(usr *user.User) (*syscall.Credential, error) {
+    uid, err := strconv.ParseUint(usr.Uid, 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid uid %s of user %s: %v", usr.Uid, usr.Username, err)
+    }
+    gid, err := strconv.ParseUint(usr.Gid, 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid gid %s of user %s: %v", usr.Gid, usr.Username, err)
+    }
+
+    credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+    groupIDs, err := usr.GroupIds()
+    if err != nil {
+        logger.Warnf("unable to read the groups of %s, building without them: %v", usr.Username, err)
+    }
+    for _, groupID := range groupIDs {
+        if id, err := strconv.ParseUint(groupID, 10, 32); err == nil {
+            credential.Groups = append(credential.Groups, uint32(id))
+        }
+    }
+    return credential, nil
+}
+
+// creates a directory in the build user's home, handing every directory it creates over to the build user
+// when AllPac runs as root
+func mkdirAllForBuildUser(dir string) error {
+    if !runningAsRoot() {
+        return os.MkdirAll(dir, 0755)
+    }
+
+    usr, err := getBuildUser()
+    if err != nil {
+        return err
+    }
+    credential, err := userCredential(usr)
+    if err != nil {
+        return err
+    }
+
+    // Remember which directories are missing, so existing ones keep their owner
+    var missing []string
+    for current := filepath.Clean(dir); ; current = filepath.Dir(current) {
+        if _, err := os.Stat(current); err == nil || current == filepath.Dir(current) {
+            break
+        }
+        missing = append(missing, current)
+    }
+
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    for _, created := range missing {
+        if err := os.Chown(created, int(credential.Uid), int(credential.Gid)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
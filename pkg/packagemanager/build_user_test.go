@@ -0,0 +1,42 @@
+package packagemanager
+
+import (
+    "os/user"
+    "strings"
+    "testing"
+)
+
+func TestBuildUserEnv(t *testing.T) {
+    t.Setenv("PATH", "/root/bin:/usr/bin")
+    t.Setenv("LANG", "de_DE.UTF-8")
+    t.Setenv("LC_TIME", "en_GB.UTF-8")
+    t.Setenv("https_proxy", "http://proxy:3128")
+    t.Setenv("SUDO_USER", "builder")
+    t.Setenv("GNUPGHOME", "/root/.gnupg")
+    t.Setenv("XDG_RUNTIME_DIR", "/run/user/0")
+
+    env := make(map[string]string)
+    for _, variable := range buildUserEnv(&user.User{Username: "builder", HomeDir: "/home/builder"}) {
+        name, value, _ := strings.Cut(variable, "=")
+        env[name] = value
+    }
+
+    for name, want := range map[string]string{
+        "PATH":        buildUserPath,
+        "HOME":        "/home/builder",
+        "USER":        "builder",
+        "LOGNAME":     "builder",
+        "LANG":        "de_DE.UTF-8",
+        "LC_TIME":     "en_GB.UTF-8",
+        "https_proxy": "http://proxy:3128",
+    } {
+        if env[name] != want {
+            t.Errorf("buildUserEnv() %s = %q, want %q", name, env[name], want)
+        }
+    }
+    for _, name := range []string{"SUDO_USER", "GNUPGHOME", "XDG_RUNTIME_DIR"} {
+        if value, ok := env[name]; ok {
+            t.Errorf("buildUserEnv() kept %s=%q", name, value)
+        }
+    }
+}
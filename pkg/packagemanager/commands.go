@@ -0,0 +1,160 @@
+package packagemanager
+
+// This file holds the external commands AllPac runs to change the system. The real operations and the
+// dry runs both build their commands here, so a dry run always shows exactly what would be executed
+
+import (
+    "os/exec"
+)
+
+// creates an exec.Cmd from a command built by one of the functions below
+func newCommand(command []string) *exec.Cmd {
+    return exec.Command(command[0], command[1:]...)
+}
+
+// syncs the databases and upgrades the whole system, skipping held packages
+func pacmanUpgradeCommand() []string {
+    return append([]string{"sudo", "pacman", "-Syu", "--noconfirm"}, pacmanIgnoreArgs()...)
+}
+
+// installs the given packages on top of an upgraded system, leaving installed ones alone
+func pacmanInstallCommand(packageNames ...string) []string {
+    return append([]string{"sudo", "pacman", "-S", "--needed", "--noconfirm"}, packageNames...)
+}
+
+// installs the given package archives
+func pacmanInstallArchiveCommand(archives ...string) []string {
+    return append([]string{"sudo", "pacman", "-U", "--noconfirm"}, archives...)
+}
+
+// prints the dependencies no installed package satisfies
+func pacmanDeptestCommand(dependencies ...string) []string {
+    return append([]string{"pacman", "-T"}, dependencies...)
+}
+
+// installs the missing build dependencies of an AUR package, marked as dependencies like makepkg -s does
+func pacmanInstallDependenciesCommand(dependencies ...string) []string {
+    return append([]string{"sudo", "pacman", "-S", "--needed", "--asdeps", "--noconfirm"}, dependencies...)
+}
+
+// removes the build dependencies that were installed for an AUR package, like makepkg --rmdeps does
+func pacmanRemoveDependenciesCommand(packageNames ...string) []string {
+    return append([]string{"sudo", "pacman", "-Rn", "--noconfirm"}, packageNames...)
+}
+
+// removes a pacman or AUR package along with its unneeded dependencies
+func pacmanRemoveCommand(packageName string) []string {
+    return []string{"sudo", "pacman", "-Rns", "--noconfirm", packageName}
+}
+
+// installs a Snap, optionally from a channel and in classic confinement
+func snapInstallCommand(packageName, channel string, classic bool) []string {
+    command := []string{"sudo", "snap", "install", packageName}
+    if channel != "" {
+        command = append(command, "--channel="+channel)
+    }
+    if classic {
+        command = append(command, "--classic")
+    }
+    return command
+}
+
+// refreshes the given Snaps, or every Snap if none are given
+func snapRefreshCommand(packageNames ...string) []string {
+    return append([]string{"sudo", "snap", "refresh"}, packageNames...)
+}
+
+// removes a Snap
+func snapRemoveCommand(packageName string) []string {
+    return []string{"sudo", "snap", "remove", packageName}
+}
+
+// installs a Flatpak, optionally from a specific remote
+func flatpakInstallCommand(remote, packageName string) []string {
+    command := []string{"flatpak", "install", "-y"}
+    if remote != "" {
+        command = append(command, remote)
+    }
+    return append(command, packageName)
+}
+
+// updates the given Flatpaks
+func flatpakUpdateCommand(packageNames ...string) []string {
+    return append([]string{"flatpak", "update", "-y"}, packageNames...)
+}
+
+// removes a Flatpak
+func flatpakUninstallCommand(packageName string) []string {
+    return []string{"flatpak", "uninstall", "-y", packageName}
+}
+
+// clones an AUR repository into the given directory
+func gitCloneCommand(repoURL, cloneDir string) []string {
+    return []string{"git", "clone", repoURL, cloneDir}
+}
+
+// fetches the latest commits of the repository in the working directory
+func gitFetchCommand() []string {
+    return []string{"git", "fetch", "--quiet", "--prune", "origin"}
+}
+
+// resets the repository in the working directory to the given commit, discarding changes to tracked files
+func gitResetCommand(ref string) []string {
+    return []string{"git", "reset", "--quiet", "--hard", ref}
+}
+
+// lists the commit a ref points to in a remote repository, without cloning it
+func gitLsRemoteCommand(repoURL, ref string) []string {
+    return []string{"git", "ls-remote", "--quiet", repoURL, ref}
+}
+
+// lists the files tracked in the repository in the working directory, separated by null bytes
+func gitLsFilesCommand() []string {
+    return []string{"git", "ls-files", "-z"}
+}
+
+// builds the package in the working directory in a clean copy of the given chroot, without installing it
+func makechrootpkgCommand(chrootDir string) []string {
+    return []string{"makechrootpkg", "-c", "-r", chrootDir}
+}
+
+// creates a chroot with base-devel to build packages in
+func mkarchrootCommand(rootDir string) []string {
+    return []string{"sudo", "mkarchroot", rootDir, "base-devel"}
+}
+
+// upgrades the packages in a build chroot
+func archNspawnUpgradeCommand(rootDir string) []string {
+    return []string{"sudo", "arch-nspawn", rootDir, "pacman", "-Syu", "--noconfirm"}
+}
+
+// builds the package in the working directory without installing it, with extra arguments like --syncdeps
+// or --config. Clones are reused, so an archive from an earlier build is overwritten instead of being kept
+func makepkgBuildCommand(extraArgs ...string) []string {
+    return append([]string{"makepkg", "--force", "--noconfirm"}, extraArgs...)
+}
+
+// lists the archives the package in the working directory builds, with extra arguments like --config
+func makepkgPackageListCommand(extraArgs ...string) []string {
+    return append([]string{"makepkg", "--packagelist"}, extraArgs...)
+}
+
+// prints the .SRCINFO of the package in the working directory
+func makepkgPrintSrcinfoCommand() []string {
+    return []string{"makepkg", "--printsrcinfo"}
+}
+
+// checks whether a key is in the user's GnuPG keyring
+func gpgListKeysCommand(key string) []string {
+    return []string{"gpg", "--batch", "--list-keys", "--with-colons", key}
+}
+
+// imports keys from a keyserver into the user's GnuPG keyring
+func gpgReceiveKeysCommand(keyserver string, keys ...string) []string {
+    return append([]string{"gpg", "--batch", "--keyserver", keyserver, "--recv-keys"}, keys...)
+}
+
+// imports key files into the user's GnuPG keyring
+func gpgImportCommand(keyFiles ...string) []string {
+    return append([]string{"gpg", "--batch", "--import"}, keyFiles...)
+}
@@ -0,0 +1,81 @@
+package packagemanager
+
+// This file is responsible for reading the AllPac configuration file
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "pixelridgesoftworks.com/AllPac/pkg/alpmdb"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+const configFilename = "config.json"
+
+// Config represents the user configuration in ~/.allpac/config.json
+type Config struct {
+    // how many package list backups to keep, 0 disables automatic backups
+    BackupRetention int `json:"backup_retention"`
+    // overrides the directory pacman keeps its databases in, which is the DBPath of pacman.conf otherwise
+    PacmanDBPath string `json:"pacman_db_path"`
+    // the pacman configuration, which sets the DBPath and lists the repositories in the order pacman searches them
+    PacmanConfPath string `json:"pacman_conf_path"`
+    // when to review the build files of AUR packages: ReviewAlways, ReviewNewOnly or ReviewNever
+    AURReview string `json:"aur_review"`
+    // the build settings for every AUR package
+    AURBuild AURBuildConfig `json:"aur_build"`
+    // build settings for single AUR packages, layered over AURBuild
+    AURPackages map[string]AURBuildConfig `json:"aur_packages,omitempty"`
+    // the keyserver the PGP keys AUR packages verify their sources with are received from
+    PGPKeyserver string `json:"pgp_keyserver"`
+    // a directory of exported keys, named after their fingerprints, to import from instead of the keyserver
+    PGPKeyDir string `json:"pgp_key_dir,omitempty"`
+    // the directory of the chroot AUR packages are built in with BuildModeChroot, ~/.allpac/chroot by default
+    ChrootDir string `json:"chroot_dir,omitempty"`
+    // the user AUR packages are built as when AllPac runs as root, $SUDO_USER by default
+    BuildUser string `json:"build_user,omitempty"`
+}
+
+// returns the configuration used when no config file exists
+func defaultConfig() Config {
+    return Config{
+        BackupRetention: 10,
+        PacmanConfPath:  alpmdb.DefaultConfPath,
+        AURReview:       ReviewAlways,
+        PGPKeyserver:    defaultPGPKeyserver,
+    }
+}
+
+// returns the file path for the configuration file
+func GetConfigPath() (string, error) {
+    pkgListPath, err := GetPkgListPath()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(filepath.Dir(pkgListPath), configFilename), nil
+}
+
+// reads the configuration file, falling back to the defaults for anything that isn't set
+func ReadConfig() (Config, error) {
+    config := defaultConfig()
+
+    configPath, err := GetConfigPath()
+    if err != nil {
+        return config, err
+    }
+
+    data, err := os.ReadFile(configPath)
+    if os.IsNotExist(err) {
+        return config, nil
+    } else if err != nil {
+        logger.Errorf("error reading config file: %v", err)
+        return config, fmt.Errorf("error reading config file: %v", err)
+    }
+
+    if err := json.Unmarshal(data, &config); err != nil {
+        logger.Errorf("error decoding config file: %v", err)
+        return defaultConfig(), fmt.Errorf("error decoding config file: %v", err)
+    }
+    return config, nil
+}
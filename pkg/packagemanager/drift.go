@@ -0,0 +1,118 @@
+package packagemanager
+
+// This file is responsible for detecting drift between the package list and what is actually installed,
+// which happens when packages are removed or upgraded outside of AllPac
+
+import (
+    "fmt"
+    "sort"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// DriftKind describes how a package list entry differs from the system
+type DriftKind string
+
+const (
+    // the package is in the list but not installed anymore
+    DriftMissing DriftKind = "missing"
+    // the installed version differs from the recorded one
+    DriftVersion DriftKind = "version"
+    // the package is installed, but from a different source than the recorded one
+    DriftSource DriftKind = "source"
+)
+
+// DriftIssue represents a single package list entry that doesn't match the system
+type DriftIssue struct {
+    Name      string
+    Kind      DriftKind
+    Recorded  PackageInfo
+    Installed SystemPackage
+}
+
+// compares every package list entry against the live installed state of its source
+func CheckPackageDrift() ([]DriftIssue, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    installed, err := ListSystemPackages(false)
+    if err != nil {
+        return nil, err
+    }
+
+    // The same name may be installed from several sources, e.g. a pacman package and a snap
+    installedByName := make(map[string][]SystemPackage)
+    for _, pkg := range installed {
+        installedByName[pkg.Name] = append(installedByName[pkg.Name], pkg)
+    }
+
+    var issues []DriftIssue
+    for name, pkgInfo := range pkgList {
+        if issue, drifted := comparePackageWithSystem(name, pkgInfo, installedByName[name]); drifted {
+            issues = append(issues, issue)
+        }
+    }
+
+    sort.Slice(issues, func(i, j int) bool { return issues[i].Name < issues[j].Name })
+    return issues, nil
+}
+
+// compares a single package list entry with the matching installed packages
+func comparePackageWithSystem(name string, pkgInfo PackageInfo, candidates []SystemPackage) (DriftIssue, bool) {
+    if len(candidates) == 0 {
+        return DriftIssue{Name: name, Kind: DriftMissing, Recorded: pkgInfo}, true
+    }
+
+    for _, candidate := range candidates {
+        if candidate.Source == pkgInfo.Source {
+            if candidate.Version != pkgInfo.Version {
+                return DriftIssue{Name: name, Kind: DriftVersion, Recorded: pkgInfo, Installed: candidate}, true
+            }
+            return DriftIssue{}, false
+        }
+    }
+
+    return DriftIssue{Name: name, Kind: DriftSource, Recorded: pkgInfo, Installed: candidates[0]}, true
+}
+
+// reconciles the package list with the system for the given drift issues
+func FixPackageDrift(issues []DriftIssue) error {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+
+    for _, issue := range issues {
+        pkgInfo, exists := pkgList[issue.Name]
+        if !exists {
+            continue
+        }
+
+        switch issue.Kind {
+        case DriftMissing:
+            delete(pkgList, issue.Name)
+            logger.Infof("Removed missing package %s from the package list", issue.Name)
+        case DriftVersion, DriftSource:
+            pkgInfo.Source = issue.Installed.Source
+            pkgInfo.Version = issue.Installed.Version
+            pkgList[issue.Name] = pkgInfo
+            logger.Infof("Updated package %s in the package list to %s %s", issue.Name, pkgInfo.Source, pkgInfo.Version)
+        }
+    }
+
+    if err := writePackageList(pkgList); err != nil {
+        return err
+    }
+
+    for _, issue := range issues {
+        if issue.Kind == DriftMissing {
+            appendHistory("remove", issue.Name, issue.Recorded.Source, issue.Recorded.Version)
+        } else {
+            appendHistory("update", issue.Name, issue.Installed.Source, issue.Installed.Version)
+        }
+    }
+    return nil
+}
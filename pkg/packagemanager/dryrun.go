@@ -0,0 +1,491 @@
+package packagemanager
+
+// This file is responsible for dry runs. Each planner mirrors a real operation, working out which packages
+// it would touch and which commands it would run, but only reads from the system
+
+import (
+    "fmt"
+    "sort"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// PlannedCommand represents an external command a dry run would execute
+type PlannedCommand struct {
+    Args []string
+    // the working directory, empty when it doesn't matter
+    Dir string
+    // environment variables set on top of AllPac's own environment
+    Env []string
+}
+
+// DryRunPlan describes what a command would do, without doing it
+type DryRunPlan struct {
+    Commands []PlannedCommand
+    Changes  []PackageListChange
+    Notes    []string
+
+    // set once the plan contains the system upgrade, which runs at most once per run
+    systemUpgradePlanned bool
+}
+
+func (p *DryRunPlan) addCommand(args []string) {
+    p.Commands = append(p.Commands, PlannedCommand{Args: args})
+}
+
+func (p *DryRunPlan) addCommandInDir(dir string, args []string) {
+    p.Commands = append(p.Commands, PlannedCommand{Args: args, Dir: dir})
+}
+
+// plans the system upgrade ensureSystemUpgraded runs, unless it is already planned
+func (p *DryRunPlan) addSystemUpgrade() {
+    if p.systemUpgradePlanned {
+        return
+    }
+    p.systemUpgradePlanned = true
+    p.addCommand(pacmanUpgradeCommand())
+    p.addNote("The system upgrade also records the new versions of all managed Pacman packages")
+}
+
+func (p *DryRunPlan) addCommandWithEnv(dir string, env []string, args []string) {
+    p.Commands = append(p.Commands, PlannedCommand{Args: args, Dir: dir, Env: env})
+}
+
+func (p *DryRunPlan) addNote(format string, args ...interface{}) {
+    p.Notes = append(p.Notes, fmt.Sprintf(format, args...))
+}
+
+// records that a package list entry would change, copying the old entry so later changes don't affect it
+func (p *DryRunPlan) addChange(name string, oldInfo *PackageInfo, newInfo *PackageInfo) {
+    change := PackageListChange{Name: name}
+    if oldInfo != nil {
+        oldCopy := *oldInfo
+        change.Old = &oldCopy
+    }
+    if newInfo != nil {
+        newCopy := *newInfo
+        change.New = &newCopy
+    }
+    p.Changes = append(p.Changes, change)
+}
+
+// plans installing a package from the given source
+func PlanInstall(packageName, source string) (*DryRunPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    plan := &DryRunPlan{}
+    switch source {
+    case "pacman":
+        plan.addSystemUpgrade()
+        plan.addCommand(pacmanInstallCommand(packageName))
+    case "aur":
+        if err := planAURBuild(plan, packageName); err != nil {
+            return nil, err
+        }
+    case "snap":
+        plan.addCommand(snapInstallCommand(packageName, "", false))
+        plan.addNote("If %s needs classic confinement, you will be asked before it is installed with --classic", packageName)
+    case "flatpak":
+        plan.addCommand(flatpakInstallCommand("", packageName))
+    default:
+        return nil, fmt.Errorf("unknown source %s for package %s", source, packageName)
+    }
+
+    newInfo := pkgList[packageName]
+    newInfo.Source = source
+    newInfo.Version = availableVersion(packageName, source)
+    if oldInfo, exists := pkgList[packageName]; exists {
+        plan.addChange(packageName, &oldInfo, &newInfo)
+    } else {
+        plan.addChange(packageName, nil, &newInfo)
+    }
+    return plan, nil
+}
+
+// plans installing the members of a pacman group
+func PlanInstallGroup(candidate InstallCandidate) (*DryRunPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    plan := &DryRunPlan{}
+    plan.addSystemUpgrade()
+    plan.addCommand(pacmanInstallCommand(candidate.Members...))
+    for _, member := range candidate.Members {
+        newInfo := pkgList[member]
+        newInfo.Source = "pacman"
+        newInfo.Version = availableVersion(member, "pacman")
+        if oldInfo, exists := pkgList[member]; exists {
+            plan.addChange(member, &oldInfo, &newInfo)
+        } else {
+            plan.addChange(member, nil, &newInfo)
+        }
+    }
+    return plan, nil
+}
+
+// plans uninstalling the given packages
+func PlanUninstall(packageNames []string) (*DryRunPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    plan := &DryRunPlan{}
+    for _, packageName := range packageNames {
+        pkgInfo, exists := pkgList[packageName]
+        if !exists {
+            plan.addNote("Package %s not found in installed packages list, it would be skipped", packageName)
+            continue
+        }
+
+        switch pkgInfo.Source {
+        case "pacman", "aur":
+            plan.addCommand(pacmanRemoveCommand(packageName))
+        case "snap":
+            plan.addCommand(snapRemoveCommand(packageName))
+        case "flatpak":
+            plan.addCommand(flatpakUninstallCommand(packageName))
+        default:
+            plan.addNote("Unknown source for package %s, it would be skipped", packageName)
+            continue
+        }
+        plan.addChange(packageName, &pkgInfo, nil)
+    }
+    return plan, nil
+}
+
+// plans an update, taking the same options as the update command
+func PlanUpdate(option string, devel bool) (*DryRunPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    plan := &DryRunPlan{}
+    switch option {
+    case "everything":
+        pacmanPackages, aurPackages, snapPackages, flatpakPackages := separatePackagesBySource(pkgList)
+        planPacmanUpdate(plan, pkgList, checkPackagesForUpdate(pacmanPackages, "pacman"))
+        planSnapUpdate(plan, pkgList, checkPackagesForUpdate(snapPackages, "snap"))
+        planFlatpakUpdate(plan, pkgList, checkPackagesForUpdate(flatpakPackages, "flatpak"))
+        if err := planAURUpdate(plan, pkgList, aurPackages, devel); err != nil {
+            return nil, err
+        }
+    case "snaps":
+        planSnapUpdate(plan, pkgList, nil)
+    case "aur":
+        if err := planAURUpdate(plan, pkgList, nil, devel); err != nil {
+            return nil, err
+        }
+    case "arch":
+        planPacmanUpdate(plan, pkgList, nil)
+    case "flats":
+        planFlatpakUpdate(plan, pkgList, nil)
+    default:
+        pkgInfo, exists := pkgList[option]
+        if !exists {
+            return nil, fmt.Errorf("package %s not found in package list", option)
+        }
+        if err := checkPackageNotHeld(pkgList, option); err != nil {
+            return nil, err
+        }
+
+        switch pkgInfo.Source {
+        case "pacman":
+            planPacmanUpdate(plan, pkgList, []string{option})
+        case "aur":
+            if err := planAURUpdate(plan, pkgList, []string{option}, devel); err != nil {
+                return nil, err
+            }
+        case "snap":
+            planSnapUpdate(plan, pkgList, []string{option})
+        case "flatpak":
+            planFlatpakUpdate(plan, pkgList, []string{option})
+        default:
+            return nil, fmt.Errorf("unknown source for package %s", option)
+        }
+    }
+    return plan, nil
+}
+
+// plans rebuilding and reinstalling an AUR package
+func PlanRebuild(packageName string) (*DryRunPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pkgInfo, found := pkgList[packageName]
+    if !found || pkgInfo.Source != "aur" {
+        return nil, fmt.Errorf("package %s is not found or not an AUR package", packageName)
+    }
+
+    plan := &DryRunPlan{}
+    if err := planAURBuild(plan, packageName); err != nil {
+        return nil, err
+    }
+
+    newInfo := pkgInfo
+    newInfo.Version = availableVersion(packageName, "aur")
+    plan.addChange(packageName, &pkgInfo, &newInfo)
+    return plan, nil
+}
+
+// plans installing a specific version of a package from a package cache, which DowngradePackage and
+// ReinstallCachedAURPackage do. An empty version means the recorded one
+func PlanInstallCachedPackage(packageName, version string) (*DryRunPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pkgInfo, found := pkgList[packageName]
+    if !found {
+        return nil, fmt.Errorf("package %s not found in package list", packageName)
+    }
+    if version == "" {
+        version = pkgInfo.Version
+    }
+
+    newInfo := pkgInfo
+    newInfo.Version = version
+    var archive string
+    switch pkgInfo.Source {
+    case "aur":
+        cached, err := findCachedAURPackage(packageName, version)
+        if err != nil {
+            return nil, err
+        }
+        archive = cached.Path
+        newInfo.Commit = cached.Commit
+    case "pacman":
+        if archive, err = findPacmanCachedPackage(packageName, version); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, fmt.Errorf("installing cached %s packages is not supported", pkgInfo.Source)
+    }
+
+    plan := &DryRunPlan{}
+    plan.addCommand(pacmanInstallArchiveCommand(archive))
+    plan.addChange(packageName, &pkgInfo, &newInfo)
+    return plan, nil
+}
+
+// plans the commands CloneAndInstallFromAUR runs to build and install an AUR package
+func planAURBuild(plan *DryRunPlan, packageName string) error {
+    usr, err := getBuildUser()
+    if err != nil {
+        return err
+    }
+    if runningAsRoot() {
+        plan.addNote("git, gpg and makepkg would run as the build user %s", usr.Username)
+    }
+
+    pkgBase := aurPackageBase(packageName)
+    cloneDir := aurCloneDir(usr.HomeDir, pkgBase)
+    plan.addSystemUpgrade()
+    if isGitRepository(cloneDir) {
+        plan.addCommandInDir(cloneDir, gitFetchCommand())
+    } else {
+        plan.addCommand(gitCloneCommand(aurRepoURL(pkgBase), cloneDir))
+    }
+    plan.addCommandInDir(cloneDir, gitResetCommand(aurCloneDefaultRef))
+    plan.addNote("Unless %s is trusted, its build files are shown for review before it is built, following the aur_review policy", packageName)
+    plan.addNote("PGP keys from the validpgpkeys of %s that are missing from your keyring are imported after asking", packageName)
+    config, err := ReadConfig()
+    if err != nil {
+        plan.addNote("Unable to read the config, the default build environment is shown: %v", err)
+    }
+    buildConfig := config.buildConfigFor(packageName)
+    if buildConfig.Mode == BuildModeChroot {
+        chrootDir := buildChrootDir(usr.HomeDir, config)
+        plan.addCommand(buildChrootCommand(chrootDir))
+        plan.addCommandInDir(cloneDir, makechrootpkgCommand(chrootDir))
+    } else {
+        if runningAsRoot() {
+            plan.addCommand(pacmanInstallDependenciesCommand("<depends, makedepends and checkdepends from .SRCINFO that pacman -T reports missing>"))
+        }
+        plan.addCommandWithEnv(cloneDir, makepkgBuildEnv(usr.HomeDir, buildConfig), makepkgBuildCommand(makepkgBuildArgs(usr.HomeDir, packageName, buildConfig)...))
+        if buildConfig.MakepkgConf != "" {
+            plan.addNote("%s would be generated to apply %s on top of %s and %s", makepkgConfPath(usr.HomeDir, packageName), buildConfig.MakepkgConf, systemMakepkgConf, systemMakepkgConfDir)
+        }
+        if runningAsRoot() && buildConfig.Mode == BuildModeRemoveMakedepends {
+            plan.addCommand(pacmanRemoveDependenciesCommand("<packages installed for the build dependencies>"))
+        }
+    }
+    plan.addNote("The built archives would be moved into the AllPac package cache, and you are asked before they are installed")
+    plan.addCommand(pacmanInstallArchiveCommand("<archives listed by makepkg --packagelist>"))
+    return nil
+}
+
+// plans UpdatePacmanPackages, which upgrades the whole system unless none of the given packages are outdated
+func planPacmanUpdate(plan *DryRunPlan, pkgList PackageList, packageNames []string) {
+    if len(packageNames) == 0 {
+        plan.addSystemUpgrade()
+        return
+    }
+
+    var toUpdate []string
+    for _, packageName := range filterHeldPackages(pkgList, packageNames) {
+        if planVersionUpdate(plan, pkgList, packageName, "pacman") {
+            toUpdate = append(toUpdate, packageName)
+        }
+    }
+
+    if len(toUpdate) == 0 {
+        plan.addNote("No Pacman packages need updating")
+        return
+    }
+    plan.addSystemUpgrade()
+}
+
+// plans UpdateSnapPackages, which refreshes every managed Snap when no packages are given
+func planSnapUpdate(plan *DryRunPlan, pkgList PackageList, packageNames []string) {
+    if len(packageNames) == 0 {
+        for packageName, pkgInfo := range pkgList {
+            if pkgInfo.Source == "snap" {
+                packageNames = append(packageNames, packageName)
+            }
+        }
+        sort.Strings(packageNames)
+    }
+
+    if len(packageNames) > 0 {
+        packageNames = filterHeldPackages(pkgList, packageNames)
+        if len(packageNames) == 0 {
+            plan.addNote("No Snap packages need updating")
+            return
+        }
+    }
+
+    plan.addCommand(snapRefreshCommand(packageNames...))
+    plan.addNote("Snap only reports new versions once a refresh has run, the recorded versions are updated afterwards")
+}
+
+// plans UpdateFlatpakPackages, which only updates the given packages
+func planFlatpakUpdate(plan *DryRunPlan, pkgList PackageList, packageNames []string) {
+    var toUpdate []string
+    for _, packageName := range filterHeldPackages(pkgList, packageNames) {
+        if planVersionUpdate(plan, pkgList, packageName, "flatpak") {
+            toUpdate = append(toUpdate, packageName)
+        }
+    }
+
+    if len(toUpdate) == 0 {
+        plan.addNote("No Flatpak packages need updating")
+        return
+    }
+    plan.addCommand(flatpakUpdateCommand(toUpdate...))
+}
+
+// plans UpdateAURPackages, which rebuilds every outdated AUR package when no packages are given,
+// or UpdateAURDevelPackages with devel
+func planAURUpdate(plan *DryRunPlan, pkgList PackageList, packageNames []string, devel bool) error {
+    if len(packageNames) == 0 {
+        for packageName, pkgInfo := range pkgList {
+            if pkgInfo.Source == "aur" {
+                packageNames = append(packageNames, packageName)
+            }
+        }
+    }
+    sort.Strings(packageNames)
+
+    for _, packageName := range filterHeldPackages(pkgList, packageNames) {
+        pkgInfo, exists := pkgList[packageName]
+        var rebuild bool
+        if exists && isVCSPackage(packageName, pkgInfo) {
+            rebuild = planVCSVersionUpdate(plan, packageName, pkgInfo) || (devel && planVCSUpdate(plan, packageName, pkgInfo))
+        } else {
+            rebuild = planVersionUpdate(plan, pkgList, packageName, "aur")
+        }
+        if rebuild {
+            if err := planAURBuild(plan, packageName); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// checks if the AUR has a newer version of a VCS package than the one that was built, recording the
+// planned version change if it does, like aurVersionNeedsUpdate
+func planVCSVersionUpdate(plan *DryRunPlan, packageName string, pkgInfo PackageInfo) bool {
+    aurVersion, err := GetAURPackageVersion(packageName)
+    if err != nil {
+        plan.addNote("Unable to check %s for updates: %v", packageName, err)
+        return false
+    }
+    if !aurVersionNeedsUpdate(pkgInfo, aurVersion, true) {
+        return false
+    }
+
+    newInfo := pkgInfo
+    newInfo.Version = aurVersion
+    plan.addChange(packageName, &pkgInfo, &newInfo)
+    return true
+}
+
+// checks if upstream moved since a VCS package was built, noting why it would be rebuilt if it did
+func planVCSUpdate(plan *DryRunPlan, packageName string, pkgInfo PackageInfo) bool {
+    changed, reason, err := vcsUpstreamChanged(packageName, pkgInfo)
+    if err != nil {
+        plan.addNote("Unable to check the upstream of %s, it would be skipped: %v", packageName, err)
+        return false
+    }
+    if changed {
+        plan.addNote("%s would be rebuilt: %s", packageName, reason)
+    }
+    return changed
+}
+
+// checks if a package needs an update and records the planned version change if it does
+func planVersionUpdate(plan *DryRunPlan, pkgList PackageList, packageName, source string) bool {
+    pkgInfo, exists := pkgList[packageName]
+    if !exists {
+        plan.addNote("Package %s not managed by AllPac, it would be skipped", packageName)
+        return false
+    }
+
+    needsUpdate, err := checkIfPackageNeedsUpdate(packageName, source)
+    if err != nil {
+        plan.addNote("Unable to check %s for updates: %v", packageName, err)
+        return false
+    }
+    if !needsUpdate {
+        return false
+    }
+
+    newInfo := pkgInfo
+    newInfo.Version = availableVersion(packageName, source)
+    plan.addChange(packageName, &pkgInfo, &newInfo)
+    return true
+}
+
+// returns the version a package would be installed or updated to, or "latest" when the source
+// can't tell us before the package is installed
+func availableVersion(packageName, source string) string {
+    var version string
+    var err error
+    switch source {
+    case "pacman":
+        version, err = GetPacmanPackageVersion(packageName)
+    case "aur":
+        version, err = GetAURPackageVersion(packageName)
+    }
+
+    if err != nil || version == "" {
+        return "latest"
+    }
+    return version
+}
@@ -20,7 +20,7 @@ func UpdateFlatpakPackages(packageNames ...string) error {
 
     // Determine which packages need updating
     var packagesToUpdate []string
-    for _, packageName := range packageNames {
+    for _, packageName := range filterHeldPackages(pkgList, packageNames) {
         installedInfo, ok := pkgList[packageName]
         if !ok {
             logger.Infof("Package %s not managed by AllPac, skipping", packageName)
@@ -40,8 +40,7 @@ func UpdateFlatpakPackages(packageNames ...string) error {
 
     // Update the packages
     if len(packagesToUpdate) > 0 {
-        args := append([]string{"update", "-y"}, packagesToUpdate...)
-        cmd := exec.Command("flatpak", args...)
+        cmd := newCommand(flatpakUpdateCommand(packagesToUpdate...))
         if output, err := cmd.CombinedOutput(); err != nil {
             logger.Errorf("error updating Flatpak packages: %s, %v", output, err)
             return fmt.Errorf("error updating Flatpak packages: %s, %v", output, err)
@@ -82,7 +81,7 @@ func UninstallFlatpakPackage(packageName string) error {
     }
 
     // Uninstalling the Flatpak package
-    cmd := exec.Command("flatpak", "uninstall", "-y", packageName)
+    cmd := newCommand(flatpakUninstallCommand(packageName))
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error uninstalling Flatpak package: %s, %v", output, err)
         return fmt.Errorf("error uninstalling Flatpak package: %s, %v", output, err)
@@ -0,0 +1,96 @@
+package packagemanager
+
+// This file is responsible for the history log, an append-only record of every change AllPac makes
+// to the package list. It lets us rebuild the package list if it ever gets lost or corrupted
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+const historyFilename = "history.log"
+
+// HistoryEntry represents a single change to the package list
+type HistoryEntry struct {
+    Time    time.Time `json:"time"`
+    Action  string    `json:"action"`
+    Name    string    `json:"name"`
+    Source  string    `json:"source,omitempty"`
+    Version string    `json:"version,omitempty"`
+}
+
+// returns the file path for the history log
+func getHistoryPath() (string, error) {
+    pkgListPath, err := GetPkgListPath()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(filepath.Dir(pkgListPath), historyFilename), nil
+}
+
+// appends an entry to the history log. Failures are only logged, since the history
+// is a safety net and should never stop the actual package operation
+func appendHistory(action, packageName, source, version string) {
+    historyPath, err := getHistoryPath()
+    if err != nil {
+        logger.Warnf("unable to locate history log: %v", err)
+        return
+    }
+
+    file, err := os.OpenFile(historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+        logger.Warnf("unable to open history log: %v", err)
+        return
+    }
+    defer file.Close()
+
+    entry := HistoryEntry{
+        Time:    time.Now(),
+        Action:  action,
+        Name:    packageName,
+        Source:  source,
+        Version: version,
+    }
+    if err := json.NewEncoder(file).Encode(entry); err != nil {
+        logger.Warnf("unable to write history log: %v", err)
+    }
+}
+
+// reads every entry from the history log, skipping lines that can't be parsed
+func ReadHistory() ([]HistoryEntry, error) {
+    historyPath, err := getHistoryPath()
+    if err != nil {
+        return nil, err
+    }
+
+    file, err := os.Open(historyPath)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        logger.Errorf("error opening history log: %v", err)
+        return nil, fmt.Errorf("error opening history log: %v", err)
+    }
+    defer file.Close()
+
+    var entries []HistoryEntry
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        var entry HistoryEntry
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+            logger.Warnf("skipping unreadable history entry: %v", err)
+            continue
+        }
+        entries = append(entries, entry)
+    }
+
+    if err := scanner.Err(); err != nil {
+        logger.Errorf("error reading history log: %v", err)
+        return nil, fmt.Errorf("error reading history log: %v", err)
+    }
+    return entries, nil
+}
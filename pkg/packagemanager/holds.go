@@ -0,0 +1,73 @@
+package packagemanager
+
+// This file is responsible for package holds. A held package is left alone by every update path,
+// so a broken upstream release can't be pulled in by an update
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// holds a package at its current version
+func HoldPackage(packageName string) error {
+    if err := updatePackageInfo(packageName, func(pkgInfo *PackageInfo) { pkgInfo.Held = true }); err != nil {
+        return err
+    }
+    logger.Infof("Package %s is now held", packageName)
+    return nil
+}
+
+// releases the hold on a package, so it is updated again
+func UnholdPackage(packageName string) error {
+    if err := updatePackageInfo(packageName, func(pkgInfo *PackageInfo) { pkgInfo.Held = false }); err != nil {
+        return err
+    }
+    logger.Infof("Package %s is no longer held", packageName)
+    return nil
+}
+
+// removes the held packages from the given names, logging each one we skip
+func filterHeldPackages(pkgList PackageList, packageNames []string) []string {
+    var unheld []string
+    for _, packageName := range packageNames {
+        if pkgList[packageName].Held {
+            logger.Infof("Package %s is held, skipping update", packageName)
+            continue
+        }
+        unheld = append(unheld, packageName)
+    }
+    return unheld
+}
+
+// returns the pacman arguments that keep a system upgrade away from held pacman and AUR packages
+func pacmanIgnoreArgs() []string {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Warnf("unable to read held packages, upgrading without --ignore: %v", err)
+        return nil
+    }
+
+    var held []string
+    for packageName, pkgInfo := range pkgList {
+        if pkgInfo.Held && (pkgInfo.Source == "pacman" || pkgInfo.Source == "aur") {
+            held = append(held, packageName)
+        }
+    }
+    if len(held) == 0 {
+        return nil
+    }
+
+    sort.Strings(held)
+    return []string{"--ignore", strings.Join(held, ",")}
+}
+
+// returns an error if the package is held, for commands that update a single package
+func checkPackageNotHeld(pkgList PackageList, packageName string) error {
+    if pkgList[packageName].Held {
+        logger.Warnf("package %s is held", packageName)
+        return fmt.Errorf("package %s is held, run 'allpac unhold %s' to update it", packageName, packageName)
+    }
+    return nil
+}
@@ -0,0 +1,111 @@
+package packagemanager
+
+// This file is responsible for importing a package set exported from another machine. Unlike sync,
+// import only ever adds packages, and it checks that every package can be found before installing it
+
+import (
+    "fmt"
+    "os/exec"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// ImportReport describes what happened to each package of an import
+type ImportReport struct {
+    Installed []string
+    // packages already managed by AllPac
+    Skipped []string
+    // packages that were installed outside of AllPac, which are now recorded in the package list
+    Adopted []string
+    // packages that couldn't be found in their recorded source
+    Unresolved []string
+    // packages that were found but failed to install, mapped to the error
+    Failed map[string]error
+}
+
+// installs every package of the manifest that is missing on this machine, using each entry's source
+func ImportManifest(manifest *Manifest, skipConfirmation bool) (*ImportReport, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    installed, err := ListSystemPackages(false)
+    if err != nil {
+        return nil, err
+    }
+    installedBySource := make(map[string]SystemPackage)
+    for _, pkg := range installed {
+        installedBySource[pkg.Source+"/"+pkg.Name] = pkg
+    }
+
+    report := &ImportReport{Failed: make(map[string]error)}
+    var toAdopt []SystemPackage
+    for _, entry := range manifest.Entries() {
+        name := entry.Package.Name
+
+        if _, managed := pkgList[name]; managed {
+            report.Skipped = append(report.Skipped, name)
+            continue
+        }
+
+        if pkg, exists := installedBySource[entry.Source+"/"+name]; exists {
+            toAdopt = append(toAdopt, pkg)
+            report.Adopted = append(report.Adopted, name)
+            continue
+        }
+
+        if !packageExistsInSource(entry.Source, entry.Package) {
+            logger.Warnf("Package %s could not be found in %s", name, entry.Source)
+            report.Unresolved = append(report.Unresolved, name)
+            continue
+        }
+
+        fmt.Printf("Installing %s from %s...\n", name, entry.Source)
+        if err := installManifestPackage(entry.Source, entry.Package, skipConfirmation); err != nil {
+            logger.Errorf("error importing package %s: %v", name, err)
+            report.Failed[name] = err
+            continue
+        }
+        report.Installed = append(report.Installed, name)
+    }
+
+    if len(toAdopt) > 0 {
+        if err := AdoptPackages(toAdopt); err != nil {
+            return report, err
+        }
+    }
+    return report, nil
+}
+
+// checks if a package can be found in the given source
+func packageExistsInSource(source string, pkg ManifestPackage) bool {
+    switch source {
+    case "pacman":
+        _, err := GetPacmanLatestVersion(pkg.Name)
+        return err == nil
+    case "aur":
+        _, err := fetchAURPackageInfo(pkg.Name)
+        return err == nil
+    case "flatpak":
+        if pkg.Remote != "" {
+            return exec.Command("flatpak", "remote-info", pkg.Remote, pkg.Name).Run() == nil
+        }
+        results, err := SearchFlatpak(pkg.Name)
+        if err != nil {
+            return false
+        }
+        for _, result := range results {
+            for _, column := range strings.Split(result, "\t") {
+                if strings.EqualFold(strings.TrimSpace(column), pkg.Name) {
+                    return true
+                }
+            }
+        }
+        return false
+    case "snap":
+        return exec.Command("snap", "info", pkg.Name).Run() == nil
+    }
+    return false
+}
@@ -6,10 +6,6 @@ package packagemanager
 
 import (
     "fmt"
-    "time"
-    "os"
-    "os/exec"
-    "os/user"
     "strings"
     "path/filepath"
     "pixelridgesoftworks.com/AllPac/pkg/logger"
@@ -17,13 +13,17 @@ import (
 
 // installs a package using Pacman and logs the installation
 func InstallPackagePacman(packageName string) error {
-    cmd := exec.Command("sudo", "pacman", "-Syu", "--noconfirm", packageName)
+    if _, err := ensureSystemUpgraded(); err != nil {
+        return err
+    }
+
+    cmd := newCommand(pacmanInstallCommand(packageName))
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error installing package with Pacman: %s, %v", output, err)
         return fmt.Errorf("error installing package with Pacman: %s, %v", output, err)
     }
 
-    version, err := GetPacmanPackageVersion(packageName)
+    version, err := GetPacmanInstalledVersion(packageName)
     if err != nil {
         logger.Errorf("An error has occured:", err)
         return err
@@ -38,7 +38,13 @@ func InstallPackagePacman(packageName string) error {
 
 // installs a package using Snap and logs the installation
 func InstallPackageSnap(packageName string) error {
-    cmd := exec.Command("sudo", "snap", "install", packageName)
+    return InstallPackageSnapWithOptions(packageName, "", false)
+}
+
+// installs a package using Snap from the given channel, optionally in classic mode, and logs the installation
+func InstallPackageSnapWithOptions(packageName, channel string, classic bool) error {
+    command := snapInstallCommand(packageName, channel, classic)
+    cmd := newCommand(command)
     output, err := cmd.CombinedOutput()
 
     if err != nil {
@@ -46,13 +52,13 @@ func InstallPackageSnap(packageName string) error {
         logger.Errorf("error installing package with Snap: %s, %v", outputStr, err)
 
         // Check if the error is due to the need for classic confinement
-        if strings.Contains(outputStr, "using classic") {
+        if !classic && strings.Contains(outputStr, "using classic") {
             fmt.Println("This package requires installation in classic mode, which may perform arbitrary system changes outside of the security sandbox. Do you want to proceed? (yes/no)")
             var response string
             fmt.Scanln(&response)
             if strings.ToLower(response) == "yes" {
                 // Retry installation with --classic flag
-                classicCmd := exec.Command("sudo", "snap", "install", "--classic", packageName)
+                classicCmd := newCommand(append(command, "--classic"))
                 if classicOutput, classicErr := classicCmd.CombinedOutput(); classicErr != nil {
                     logger.Errorf("error installing package with Snap in classic mode: %s, %v", classicOutput, classicErr)
                     return fmt.Errorf("error installing package with Snap in classic mode: %s, %v", classicOutput, classicErr)
@@ -80,7 +86,12 @@ func InstallPackageSnap(packageName string) error {
 
 // installs a package using Flatpak and logs the installation
 func InstallPackageFlatpak(packageName string) error {
-    cmd := exec.Command("flatpak", "install", "-y", packageName)
+    return InstallPackageFlatpakFromRemote("", packageName)
+}
+
+// installs a package using Flatpak from the given remote and logs the installation
+func InstallPackageFlatpakFromRemote(remote, packageName string) error {
+    cmd := newCommand(flatpakInstallCommand(remote, packageName))
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error installing package with Flatpak: %s, %v", output, err)
         return fmt.Errorf("error installing package with Flatpak: %s, %v", output, err)
@@ -99,109 +110,96 @@ func InstallPackageFlatpak(packageName string) error {
     return nil
 }
 
-// clones the given AUR repository and installs it
-func CloneAndInstallFromAUR(repoURL string, skipConfirmation bool) (string, error) {
-    // System update
-    if !skipConfirmation && !confirmAction("Do you want to update the system before proceeding? (skipping this step may result in partial updates, and break your system)") {
-        logger.Warnf("user aborted the system update")
-        return "", fmt.Errorf("user aborted the system update")
-    }
-
-    cmdUpdate := exec.Command("sudo", "pacman", "-Syu", "--noconfirm")
-    if output, err := cmdUpdate.CombinedOutput(); err != nil {
-        logger.Errorf("error updating system: %s, %v", output, err)
-        return "", fmt.Errorf("error updating system: %s, %v", output, err)
-    }
-    // Confirm before proceeding with each step
-    if !skipConfirmation && !confirmAction("Do you want to download and build package from " + repoURL + "?") {
-        logger.Warnf("user aborted the action")
-        return "", fmt.Errorf("user aborted the action")
+// installs a package from the given source, for commands that already know where a package comes from
+func InstallPackage(packageName, source string) error {
+    switch source {
+    case "pacman":
+        return InstallPackagePacman(packageName)
+    case "aur":
+        _, err := CloneAndInstallFromAUR(aurRepoURL(packageName), false)
+        return err
+    case "snap":
+        return InstallPackageSnap(packageName)
+    case "flatpak":
+        return InstallPackageFlatpak(packageName)
+    default:
+        logger.Errorf("unknown source %s for package %s", source, packageName)
+        return fmt.Errorf("unknown source %s for package %s", source, packageName)
     }
+}
 
-    // Get the current user's home directory
-    usr, err := user.Current()
+// installs a specific version of a package from the pacman package cache and logs the installation
+func InstallPacmanPackageFromCache(packageName, version string) error {
+    archive, err := findPacmanCachedPackage(packageName, version)
     if err != nil {
-        logger.Errorf("error getting current user: %v", err)
-        return "", fmt.Errorf("error getting current user: %v", err)
+        return err
     }
 
-    // Determine the name of the package from the repo URL
-    repoName := filepath.Base(repoURL)
-
-    // Remove .git suffix
-    repoName = strings.TrimSuffix(repoName, ".git")
-
-    // Get the current date in YYYYMMDD format
-    currentDate := time.Now().Format("20060102")
-
-    // Define the directory for this specific package clone
-    cloneDir := filepath.Join(usr.HomeDir, ".allpac", "cache", repoName+"-"+currentDate)
-
-    // Ensure the clone directory exists
-    if err := os.MkdirAll(cloneDir, 0755); err != nil {
-        logger.Errorf("error creating clone directory: %v", err)
-        return "", fmt.Errorf("error creating clone directory: %v", err)
+    cmd := newCommand(pacmanInstallArchiveCommand(archive))
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error installing %s from the pacman cache: %s, %v", archive, output, err)
+        return fmt.Errorf("error installing %s from the pacman cache: %s, %v", archive, output, err)
     }
 
-    // Define the base directory for AllPac cache
-    baseDir := filepath.Join(usr.HomeDir, ".allpac", "cache")
-
-    // Ensure the base directory exists
-    if err := os.MkdirAll(baseDir, 0755); err != nil {
-        logger.Errorf("error creating base directory: %v", err)
-        return "", fmt.Errorf("error creating base directory: %v", err)
+    if err := LogInstallation(packageName, "pacman", version); err != nil {
+        logger.Errorf("error logging installation: %v", err)
+        return fmt.Errorf("error logging installation: %v", err)
     }
+    return nil
+}
 
-    // Clone the repository
-    cmdGitClone := exec.Command("git", "clone", repoURL, cloneDir)
-    if output, err := cmdGitClone.CombinedOutput(); err != nil {
-        logger.Errorf("error cloning AUR repo: %s, %v", output, err)
-        return "", fmt.Errorf("error cloning AUR repo: %s, %v", output, err)
+// finds the archive of a specific package version in the pacman package cache
+func findPacmanCachedPackage(packageName, version string) (string, error) {
+    matches, err := filepath.Glob(filepath.Join(pacmanCacheDir, packageName+"-"+version+"-*.pkg.tar*"))
+    if err != nil {
+        return "", err
     }
 
-    // Change directory to the cloned repository
-    if err := os.Chdir(cloneDir); err != nil {
-        logger.Errorf("error changing directory: %v", err)
-        return "", fmt.Errorf("error changing directory: %v", err)
+    for _, match := range matches {
+        // Skip signature files, and packages whose name only starts with the one we want
+        if strings.HasSuffix(match, ".sig") {
+            continue
+        }
+        arch := strings.TrimPrefix(filepath.Base(match), packageName+"-"+version+"-")
+        if !strings.Contains(arch, "-") {
+            return match, nil
+        }
     }
 
-    // Append environment variables to PKGBUILD
-    cmdAppendEnv := exec.Command("bash", "-c", "echo 'export HOME=$HOME' >> PKGBUILD && echo 'export GOCACHE=$HOME/.cache/go-build' >> PKGBUILD")
-    cmdAppendEnv.Dir = cloneDir  // Set the working directory to the cloned repository
-    if _, err := cmdAppendEnv.CombinedOutput(); err != nil {
-        logger.Errorf("error appending environment variables to PKGBUILD: %v", err)
-        return "", fmt.Errorf("error appending environment variables to PKGBUILD: %v", err)
-    }
+    logger.Errorf("version %s of %s is not available in the pacman cache", version, packageName)
+    return "", fmt.Errorf("version %s of %s is not available in the pacman cache", version, packageName)
+}
 
-    // Build the package using makepkg as the non-root user
-    env := append(os.Environ(), "HOME=" + usr.HomeDir)
-    cmdMakePkg := exec.Command("makepkg", "-si", "--noconfirm")
-    cmdMakePkg.Env = env
-    cmdMakePkg.Dir = cloneDir
-    if output, err := cmdMakePkg.CombinedOutput(); err != nil {
-        logger.Errorf("error building package with makepkg: %s, %v", output, err)
-        return "", fmt.Errorf("error building package with makepkg: %s, %v", output, err)
-    }
+// clones the given AUR repository and installs it
+func CloneAndInstallFromAUR(repoURL string, skipConfirmation bool) (string, error) {
+    return cloneAndInstallFromAURAtCommit(repoURL, "", skipConfirmation)
+}
+
+// clones the given AUR repository, checks out the given commit unless it is empty, and installs it
+func cloneAndInstallFromAURAtCommit(repoURL, commit string, skipConfirmation bool) (string, error) {
+    // Determine the name of the package from the repo URL, without the .git suffix
+    repoName := strings.TrimSuffix(filepath.Base(repoURL), ".git")
 
-    // Extract the version from PKGBUILD
-    version, err := ExtractVersionFromPKGBUILD(cloneDir)
+    report, err := RunAURBuild(repoName, AURBuildOptions{Commit: commit, SkipConfirmation: skipConfirmation})
     if err != nil {
-        logger.Errorf("error extracting version from PKGBUILD: %v", err)
-        return "", fmt.Errorf("error extracting version from PKGBUILD: %v", err)
+        return "", err
     }
+    return report.Version, nil
+}
 
-    // Confirm before installing
-    if !skipConfirmation && !confirmAction("Do you want to install the built package " + repoName + "?") {
-        logger.Warnf("user aborted the installation")
-        return "", fmt.Errorf("user aborted the installation")
+// returns the commit currently checked out in the given git repository
+func gitHeadCommit(repoDir string) (string, error) {
+    cmd, err := newBuildCommand([]string{"git", "rev-parse", "HEAD"})
+    if err != nil {
+        return "", err
     }
-
-    if err := LogInstallation(repoName, "aur", version); err != nil {
-        logger.Errorf("error logging installation")
-        return "", fmt.Errorf("error logging installation: %v", err)
+    cmd.Dir = repoDir
+    output, err := cmd.CombinedOutput()
+    if err != nil {
+        logger.Errorf("error reading git commit: %s, %v", output, err)
+        return "", fmt.Errorf("error reading git commit: %s, %v", output, err)
     }
-
-    return version, nil
+    return strings.TrimSpace(string(output)), nil
 }
 
 // installs Snap manually from the AUR
@@ -228,8 +226,33 @@ func InstallGit() error {
     return nil
 }
 
-// installs the base-devel group using Pacman
+// installs devtools using Pacman, which provides the tools to build in a clean chroot
+func InstallDevtools() error {
+    if err := InstallPackagePacman("devtools"); err != nil {
+        logger.Errorf("error installing devtools: %v", err)
+        return fmt.Errorf("error installing devtools: %v", err)
+    }
+    return nil
+}
+
+// installs base-devel using Pacman, which is a package on current systems and a group on older ones
 func InstallBaseDevel() error {
+    candidates, err := ResolvePacmanCandidates("base-devel")
+    if err != nil {
+        logger.Errorf("error resolving base-devel: %v", err)
+        return fmt.Errorf("error resolving base-devel: %v", err)
+    }
+
+    for _, candidate := range candidates {
+        if candidate.Match == MatchGroup {
+            if err := InstallPacmanGroup(candidate); err != nil {
+                logger.Errorf("error installing base-devel: %v", err)
+                return fmt.Errorf("error installing base-devel: %v", err)
+            }
+            return nil
+        }
+    }
+
     if err := InstallPackagePacman("base-devel"); err != nil {
         logger.Errorf("error installing base-devel: %v", err)
         return fmt.Errorf("error installing base-devel: %v", err)
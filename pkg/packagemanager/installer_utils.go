@@ -7,10 +7,6 @@ import (
     "strings"
     "fmt"
     "pixelridgesoftworks.com/AllPac/pkg/logger"
-    "os/exec"
-    "os/user"
-    "strconv"
-    "syscall"
 )
 
 // reads the PKGBUILD file and extracts the package version
@@ -40,11 +36,12 @@ func ExtractVersionFromPKGBUILD(repoDir string) (string, error) {
     return "", fmt.Errorf("pkgver not found in PKGBUILD")
 }
 
-// prompts the user with a yes/no question and returns true if the answer is yes
-func confirmAction(question string) bool {
+// ConfirmAction prompts the user with a yes/no question and returns true if the answer is yes.
+// There is no default answer, so it keeps asking until it gets one
+func ConfirmAction(question string) bool {
     reader := bufio.NewReader(os.Stdin)
     for {
-        fmt.Printf("%s [Y/n]: ", question)
+        fmt.Printf("%s [y/n]: ", question)
         response, err := reader.ReadString('\n')
         if err != nil {
             logger.Errorf("Error reading response: %v", err)
@@ -60,32 +57,3 @@ func confirmAction(question string) bool {
         }
     }
 }
-
-// this is unused, just incase I need to do it this way since makepkg is being a pain in the neck
-func RunMakepkgAsUser(username string) error {
-    // Lookup the non-root user
-    usr, err := user.Lookup(username)
-    if err != nil {
-        return err
-    }
-
-    // Convert UID and GID to integers
-    uid, _ := strconv.Atoi(usr.Uid)
-    gid, _ := strconv.Atoi(usr.Gid)
-
-    // Set UID and GID of the process
-    err = syscall.Setgid(gid)
-    if err != nil {
-        return err
-    }
-    err = syscall.Setuid(uid)
-    if err != nil {
-        return err
-    }
-
-    // Now run makepkg as the non-root user
-    cmd := exec.Command("makepkg", "-si", "--noconfirm")
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
-    return cmd.Run()
-}
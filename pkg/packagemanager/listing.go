@@ -0,0 +1,311 @@
+package packagemanager
+
+// This file is responsible for listing the packages AllPac manages and showing their details,
+// combining what we recorded in the package list with live details from each backend
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// where snapd keeps the installed snap images
+const snapImageDir = "/var/lib/snapd/snaps"
+
+// the format pacman prints dates in under the C locale
+const pacmanDateFormat = "Mon Jan _2 15:04:05 2006"
+
+// ManagedPackage represents a package from the package list along with live details from its backend
+type ManagedPackage struct {
+    Name string `json:"name"`
+    PackageInfo
+    // false for pacman packages that were only installed as a dependency
+    Explicit    bool      `json:"explicit"`
+    InstallDate time.Time `json:"install_date"`
+    // installed size in bytes, 0 when the backend doesn't tell us
+    Size        int64     `json:"size"`
+}
+
+// DetailField represents a single line of live package details
+type DetailField struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+}
+
+// PackageDetails represents everything we know about a single managed package
+type PackageDetails struct {
+    Name     string        `json:"name"`
+    Recorded PackageInfo   `json:"recorded"`
+    Live     []DetailField `json:"live"`
+    AUR      []DetailField `json:"aur,omitempty"`
+}
+
+// lists every managed package, sorted by name, date or size
+func ListManagedPackages(sortBy string) ([]ManagedPackage, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    packages := make([]ManagedPackage, 0, len(pkgList))
+    var pacmanNames []string
+    for name, pkgInfo := range pkgList {
+        managed := ManagedPackage{Name: name, PackageInfo: pkgInfo, Explicit: true}
+        if pkgInfo.InstalledAt != "" {
+            managed.InstallDate, _ = time.Parse(time.RFC3339, pkgInfo.InstalledAt)
+        }
+        packages = append(packages, managed)
+
+        if pkgInfo.Source == "pacman" || pkgInfo.Source == "aur" {
+            pacmanNames = append(pacmanNames, name)
+        }
+    }
+
+    pacmanDetails := make(map[string]PacmanInfoRecord)
+    if len(pacmanNames) > 0 {
+        records, err := queryPacmanInfo(append([]string{"-Qi"}, pacmanNames...)...)
+        if err != nil {
+            return nil, err
+        }
+        for _, record := range records {
+            pacmanDetails[record.Get("Name")] = record
+        }
+    }
+    flatpakSizes := listFlatpakSizes()
+
+    for i := range packages {
+        pkg := &packages[i]
+        switch pkg.Source {
+        case "pacman", "aur":
+            record, found := pacmanDetails[pkg.Name]
+            if !found {
+                continue
+            }
+            pkg.Explicit = record.Get("Install Reason") == "Explicitly installed"
+            pkg.Size = parsePacmanSize(record.Get("Installed Size"))
+            if pkg.InstallDate.IsZero() {
+                pkg.InstallDate = parsePacmanDate(record.Get("Install Date"))
+            }
+        case "flatpak":
+            pkg.Size = flatpakSizes[pkg.Name]
+        case "snap":
+            pkg.Size = snapImageSize(pkg.Name)
+        }
+    }
+
+    sortManagedPackages(packages, sortBy)
+    return packages, nil
+}
+
+// sorts packages by name, install date (newest first) or size (largest first)
+func sortManagedPackages(packages []ManagedPackage, sortBy string) {
+    sort.Slice(packages, func(i, j int) bool {
+        switch sortBy {
+        case "date":
+            if !packages[i].InstallDate.Equal(packages[j].InstallDate) {
+                return packages[i].InstallDate.After(packages[j].InstallDate)
+            }
+        case "size":
+            if packages[i].Size != packages[j].Size {
+                return packages[i].Size > packages[j].Size
+            }
+        }
+        return packages[i].Name < packages[j].Name
+    })
+}
+
+// returns the installed size of every Flatpak application in bytes
+func listFlatpakSizes() map[string]int64 {
+    sizes := make(map[string]int64)
+    if _, err := exec.LookPath("flatpak"); err != nil {
+        return sizes
+    }
+
+    output, err := exec.Command("flatpak", "list", "--app", "--columns=application,size").CombinedOutput()
+    if err != nil {
+        logger.Warnf("unable to list Flatpak sizes: %s, %v", output, err)
+        return sizes
+    }
+
+    for _, line := range strings.Split(string(output), "\n") {
+        columns := strings.Split(line, "\t")
+        if len(columns) >= 2 {
+            sizes[strings.TrimSpace(columns[0])] = parseHumanSize(columns[1])
+        }
+    }
+    return sizes
+}
+
+// parses a size like "123.4 MB" or "1.2 GB" as printed by Flatpak into bytes
+func parseHumanSize(size string) int64 {
+    // Flatpak separates the number and unit with a non-breaking space
+    size = strings.ReplaceAll(strings.TrimSpace(size), "\u00a0", " ")
+    var value float64
+    var unit string
+    if _, err := fmt.Sscanf(size, "%g %s", &value, &unit); err != nil {
+        return 0
+    }
+
+    multipliers := map[string]float64{"bytes": 1, "B": 1, "kB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12}
+    return int64(value * multipliers[unit])
+}
+
+// returns the size of the installed image of a Snap in bytes
+func snapImageSize(name string) int64 {
+    _, revision, _, err := getSnapRevision(name)
+    if err != nil {
+        return 0
+    }
+
+    info, err := os.Stat(filepath.Join(snapImageDir, name+"_"+revision+".snap"))
+    if err != nil {
+        return 0
+    }
+    return info.Size()
+}
+
+// returns the recorded metadata of a managed package along with live details from its backend
+func GetPackageDetails(packageName string) (*PackageDetails, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pkgInfo, exists := pkgList[packageName]
+    if !exists {
+        return nil, fmt.Errorf("package %s is not managed by AllPac", packageName)
+    }
+
+    details := &PackageDetails{Name: packageName, Recorded: pkgInfo}
+    switch pkgInfo.Source {
+    case "pacman", "aur":
+        records, err := queryPacmanInfo("-Qi", packageName)
+        if err != nil {
+            return nil, err
+        }
+        if len(records) > 0 {
+            for _, field := range records[0] {
+                details.Live = append(details.Live, DetailField{Key: field.Key, Value: field.Value})
+            }
+        }
+        if pkgInfo.Source == "aur" {
+            details.AUR, err = fetchAURPackageDetails(packageName)
+            if err != nil {
+                logger.Warnf("unable to fetch AUR details for %s: %v", packageName, err)
+            }
+        }
+    case "flatpak":
+        details.Live = runDetailCommand("flatpak", "info", packageName)
+    case "snap":
+        details.Live = runDetailCommand("snap", "info", packageName)
+    }
+    return details, nil
+}
+
+// runs a command printing "key: value" lines and collects the top level fields
+func runDetailCommand(name string, args ...string) []DetailField {
+    cmd := exec.Command(name, args...)
+    // Field names and dates are translated, so we always ask for the untranslated output
+    cmd.Env = append(os.Environ(), "LC_ALL=C")
+    output, err := cmd.CombinedOutput()
+    if err != nil {
+        logger.Warnf("unable to get details from %s: %s, %v", name, output, err)
+        return nil
+    }
+
+    var fields []DetailField
+    for _, line := range strings.Split(string(output), "\n") {
+        // Snap indents the entries of nested lists like channels, which we leave out
+        if strings.HasPrefix(line, "  ") && name == "snap" {
+            continue
+        }
+        key, value, found := strings.Cut(line, ":")
+        if !found || strings.TrimSpace(value) == "" {
+            continue
+        }
+        fields = append(fields, DetailField{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+    }
+    return fields
+}
+
+// fetches the full AUR RPC info of a package as detail fields
+func fetchAURPackageDetails(packageName string) ([]DetailField, error) {
+    requestURL := "https://aur.archlinux.org/rpc/?v=5&type=info&arg[]=" + url.QueryEscape(packageName)
+    resp, err := http.Get(requestURL)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    var result struct {
+        Results []map[string]interface{} `json:"results"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return nil, err
+    }
+    if len(result.Results) == 0 {
+        return nil, fmt.Errorf("package %s not found in AUR", packageName)
+    }
+
+    keys := make([]string, 0, len(result.Results[0]))
+    for key := range result.Results[0] {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    var fields []DetailField
+    for _, key := range keys {
+        value := result.Results[0][key]
+        switch v := value.(type) {
+        case nil:
+            continue
+        case []interface{}:
+            parts := make([]string, len(v))
+            for i, part := range v {
+                parts[i] = fmt.Sprint(part)
+            }
+            fields = append(fields, DetailField{Key: key, Value: strings.Join(parts, " ")})
+        case float64:
+            fields = append(fields, DetailField{Key: key, Value: fmt.Sprintf("%v", v)})
+        default:
+            fields = append(fields, DetailField{Key: key, Value: fmt.Sprint(v)})
+        }
+    }
+    return fields, nil
+}
+
+// parses a pacman size like "12.34 MiB" into bytes
+func parsePacmanSize(size string) int64 {
+    fields := strings.Fields(size)
+    if len(fields) != 2 {
+        return 0
+    }
+
+    value, err := strconv.ParseFloat(fields[0], 64)
+    if err != nil {
+        return 0
+    }
+
+    multipliers := map[string]float64{"B": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40}
+    return int64(value * multipliers[fields[1]])
+}
+
+// parses a pacman date, returning the zero time if it can't be parsed
+func parsePacmanDate(date string) time.Time {
+    parsed, err := time.ParseInLocation(pacmanDateFormat, date, time.Local)
+    if err != nil {
+        return time.Time{}
+    }
+    return parsed
+}
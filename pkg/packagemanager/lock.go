@@ -0,0 +1,417 @@
+package packagemanager
+
+// This file is responsible for lockfiles, which pin the exact version of every managed package
+// (pacman version, AUR commit, Flatpak commit, Snap revision) so a workstation can be reproduced exactly
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the Arch Linux Archive, which keeps every package version that was ever in the repositories
+const archLinuxArchiveURL = "https://archive.archlinux.org/packages"
+
+// LockedPackage represents the exact installed state of a single package
+type LockedPackage struct {
+    Name    string `json:"name"`
+    Source  string `json:"source"`
+    Version string `json:"version"`
+    // the AUR repository commit, or the Flatpak commit
+    Commit   string `json:"commit,omitempty"`
+    // the Flatpak remote the package was installed from
+    Remote   string `json:"remote,omitempty"`
+    // the Snap revision and the channel it tracks
+    Revision string `json:"revision,omitempty"`
+    Channel  string `json:"channel,omitempty"`
+}
+
+// Lockfile represents the exact state of every package AllPac manages
+type Lockfile struct {
+    Packages []LockedPackage `json:"packages"`
+}
+
+// LockedVersionUnavailableError is returned when the exact version pinned in a lockfile can't be installed
+type LockedVersionUnavailableError struct {
+    Package LockedPackage
+    Reason  string
+}
+
+func (e *LockedVersionUnavailableError) Error() string {
+    return fmt.Sprintf("locked version %s of %s package %s is unavailable: %s", e.Package.lockedVersion(), e.Package.Source, e.Package.Name, e.Reason)
+}
+
+// describes the exact version of the package the way its source identifies it
+func (p LockedPackage) lockedVersion() string {
+    switch {
+    case p.Source == "aur" && p.Commit != "":
+        return p.Version + " (commit " + p.Commit + ")"
+    case p.Source == "flatpak" && p.Commit != "":
+        return p.Version + " (commit " + p.Commit + ")"
+    case p.Source == "snap" && p.Revision != "":
+        return p.Version + " (revision " + p.Revision + ")"
+    }
+    return p.Version
+}
+
+// records the exact installed state of every package in the package list
+func GenerateLockfile() (*Lockfile, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pacmanInstalled, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+
+    lockfile := &Lockfile{}
+    var failures []string
+    for name, pkgInfo := range pkgList {
+        locked, err := lockPackage(name, pkgInfo, pacmanInstalled)
+        if err != nil {
+            failures = append(failures, err.Error())
+            continue
+        }
+        lockfile.Packages = append(lockfile.Packages, locked)
+    }
+
+    if len(failures) > 0 {
+        sort.Strings(failures)
+        logger.Errorf("unable to lock every package: %s", strings.Join(failures, "; "))
+        return nil, fmt.Errorf("unable to lock every package: %s", strings.Join(failures, "; "))
+    }
+
+    sort.Slice(lockfile.Packages, func(i, j int) bool { return lockfile.Packages[i].Name < lockfile.Packages[j].Name })
+    return lockfile, nil
+}
+
+// records the exact installed state of a single package
+func lockPackage(name string, pkgInfo PackageInfo, pacmanInstalled map[string]string) (LockedPackage, error) {
+    locked := LockedPackage{Name: name, Source: pkgInfo.Source}
+
+    switch pkgInfo.Source {
+    case "pacman", "aur":
+        version, installed := pacmanInstalled[name]
+        if !installed {
+            return locked, fmt.Errorf("%s is not installed", name)
+        }
+        locked.Version = version
+
+        if pkgInfo.Source == "aur" {
+            commit, err := findAURBuiltCommit(name, pkgInfo)
+            if err != nil {
+                return locked, err
+            }
+            locked.Commit = commit
+        }
+    case "flatpak":
+        version, err := GetVersionFromFlatpak(name)
+        if err != nil {
+            return locked, fmt.Errorf("%s: %v", name, err)
+        }
+        commit, err := flatpakInfoField(name, "--show-commit")
+        if err != nil {
+            return locked, fmt.Errorf("%s: %v", name, err)
+        }
+        remote, err := flatpakInfoField(name, "--show-origin")
+        if err != nil {
+            return locked, fmt.Errorf("%s: %v", name, err)
+        }
+        locked.Version, locked.Commit, locked.Remote = version, commit, remote
+    case "snap":
+        version, revision, channel, err := getSnapRevision(name)
+        if err != nil {
+            return locked, fmt.Errorf("%s: %v", name, err)
+        }
+        locked.Version, locked.Revision, locked.Channel = version, revision, channel
+    default:
+        return locked, fmt.Errorf("%s has unknown source %s", name, pkgInfo.Source)
+    }
+    return locked, nil
+}
+
+// returns the AUR commit a package was built from, falling back to the newest clone in the cache
+// for packages that were installed before AllPac recorded commits
+func findAURBuiltCommit(name string, pkgInfo PackageInfo) (string, error) {
+    if pkgInfo.Commit != "" {
+        return pkgInfo.Commit, nil
+    }
+
+    cacheDir, err := getCacheDir()
+    if err != nil {
+        return "", err
+    }
+
+    // Split packages are built from the clone of their package base
+    if cloneDir := filepath.Join(cacheDir, aurPackageBase(name)); isGitRepository(cloneDir) {
+        return gitHeadCommit(cloneDir)
+    }
+
+    cloneDir := newestDateStampedClone(cacheDir, name)
+    if cloneDir == "" {
+        return "", fmt.Errorf("no built commit is recorded for AUR package %s, rebuild it with 'allpac rebuild %s' first", name, name)
+    }
+    return gitHeadCommit(cloneDir)
+}
+
+// returns the newest of the clones older versions of AllPac made for every build, named after the package
+// and the date, e.g. foo-20240101. Only the date is matched, so the clones of foo-bar don't count for foo
+func newestDateStampedClone(cacheDir, name string) string {
+    clones, _ := filepath.Glob(filepath.Join(cacheDir, name+"-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]", ".git"))
+    if len(clones) == 0 {
+        return ""
+    }
+    sort.Strings(clones)
+    return filepath.Dir(clones[len(clones)-1])
+}
+
+// returns a single field of flatpak info, such as the commit or origin
+func flatpakInfoField(name, flag string) (string, error) {
+    output, err := exec.Command("flatpak", "info", flag, name).CombinedOutput()
+    if err != nil {
+        logger.Errorf("error getting flatpak package info: %s, %v", output, err)
+        return "", fmt.Errorf("error getting flatpak package info: %s, %v", output, err)
+    }
+    return strings.TrimSpace(string(output)), nil
+}
+
+// returns the installed version, revision and tracked channel of a Snap package
+func getSnapRevision(name string) (string, string, string, error) {
+    output, err := exec.Command("snap", "list", name).CombinedOutput()
+    if err != nil {
+        logger.Errorf("error getting snap package info: %s, %v", output, err)
+        return "", "", "", fmt.Errorf("error getting snap package info: %s, %v", output, err)
+    }
+
+    // Columns are: Name Version Rev Tracking Publisher Notes
+    for _, line := range strings.Split(string(output), "\n")[1:] {
+        fields := strings.Fields(line)
+        if len(fields) >= 4 && fields[0] == name {
+            return fields[1], fields[2], fields[3], nil
+        }
+    }
+    return "", "", "", fmt.Errorf("revision not found for snap package: %s", name)
+}
+
+// reads the lockfile at the given path
+func ReadLockfile(path string) (*Lockfile, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        logger.Errorf("error reading lockfile: %v", err)
+        return nil, fmt.Errorf("error reading lockfile: %v", err)
+    }
+
+    var lockfile Lockfile
+    if err := json.Unmarshal(data, &lockfile); err != nil {
+        logger.Errorf("error decoding lockfile: %v", err)
+        return nil, fmt.Errorf("error decoding lockfile: %v", err)
+    }
+
+    if err := lockfile.validate(); err != nil {
+        logger.Errorf("invalid lockfile: %v", err)
+        return nil, fmt.Errorf("invalid lockfile: %v", err)
+    }
+    return &lockfile, nil
+}
+
+// checks that every locked package has a name, a known source and a version, and is only locked once
+func (l *Lockfile) validate() error {
+    seen := make(map[string]bool)
+    for i, locked := range l.Packages {
+        if locked.Name == "" {
+            return fmt.Errorf("package %d is missing its name", i+1)
+        }
+        if strings.ContainsAny(locked.Name, "/ \t\n") {
+            return fmt.Errorf("package name %q is invalid", locked.Name)
+        }
+        if seen[locked.Name] {
+            return fmt.Errorf("package %s is locked more than once", locked.Name)
+        }
+        seen[locked.Name] = true
+
+        switch locked.Source {
+        case "pacman", "aur", "flatpak", "snap":
+        default:
+            return fmt.Errorf("package %s has unknown source %q", locked.Name, locked.Source)
+        }
+        if locked.Version == "" {
+            return fmt.Errorf("package %s is missing its version", locked.Name)
+        }
+    }
+    return nil
+}
+
+// writes the lockfile to the given path
+func WriteLockfile(path string, lockfile *Lockfile) error {
+    data, err := json.MarshalIndent(lockfile, "", "  ")
+    if err != nil {
+        logger.Errorf("error encoding lockfile: %v", err)
+        return fmt.Errorf("error encoding lockfile: %v", err)
+    }
+
+    if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+        logger.Errorf("error writing lockfile: %v", err)
+        return fmt.Errorf("error writing lockfile: %v", err)
+    }
+    return nil
+}
+
+// lists the locked packages whose installed state differs from the lockfile
+func PlanLockedSync(lockfile *Lockfile) ([]LockedPackage, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pacmanInstalled, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+
+    var pending []LockedPackage
+    for _, locked := range lockfile.Packages {
+        current, err := lockPackage(locked.Name, PackageInfo{Source: locked.Source, Commit: pkgList[locked.Name].Commit}, pacmanInstalled)
+        if err != nil || current.Version != locked.Version || current.Commit != locked.Commit || current.Revision != locked.Revision {
+            pending = append(pending, locked)
+        }
+    }
+    return pending, nil
+}
+
+// installs the exact locked version of every given package, carrying on past failures
+func ApplyLockedSync(packages []LockedPackage, skipConfirmation bool) error {
+    var failures []string
+    for _, locked := range packages {
+        fmt.Printf("Installing %s %s from %s...\n", locked.Name, locked.lockedVersion(), locked.Source)
+        if err := installLockedPackage(locked, skipConfirmation); err != nil {
+            logger.Errorf("error installing locked package %s: %v", locked.Name, err)
+            fmt.Printf("Error: %v\n", err)
+            failures = append(failures, locked.Name)
+        }
+    }
+
+    if len(failures) > 0 {
+        return fmt.Errorf("%d of %d locked packages could not be installed: %s", len(failures), len(packages), strings.Join(failures, ", "))
+    }
+    return nil
+}
+
+// installs the exact locked version of a single package
+func installLockedPackage(locked LockedPackage, skipConfirmation bool) error {
+    switch locked.Source {
+    case "pacman":
+        return installLockedPacmanPackage(locked)
+    case "aur":
+        if locked.Commit == "" {
+            return &LockedVersionUnavailableError{Package: locked, Reason: "the lockfile has no commit for it"}
+        }
+        if _, err := cloneAndInstallFromAURAtCommit(aurRepoURL(locked.Name), locked.Commit, skipConfirmation); err != nil {
+            return &LockedVersionUnavailableError{Package: locked, Reason: err.Error()}
+        }
+        return nil
+    case "flatpak":
+        return installLockedFlatpakPackage(locked)
+    case "snap":
+        return installLockedSnapPackage(locked)
+    default:
+        return fmt.Errorf("unknown source %s for package %s", locked.Source, locked.Name)
+    }
+}
+
+// installs a locked pacman version, from the pacman cache if possible and the Arch Linux Archive otherwise
+func installLockedPacmanPackage(locked LockedPackage) error {
+    if _, err := findPacmanCachedPackage(locked.Name, locked.Version); err == nil {
+        return InstallPacmanPackageFromCache(locked.Name, locked.Version)
+    }
+
+    archiveURL, err := findArchivedPacmanPackage(locked.Name, locked.Version)
+    if err != nil {
+        return &LockedVersionUnavailableError{Package: locked, Reason: "it is neither in the pacman cache nor in the Arch Linux Archive"}
+    }
+
+    cmd := exec.Command("sudo", "pacman", "-U", "--noconfirm", archiveURL)
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error installing %s: %s, %v", archiveURL, output, err)
+        return fmt.Errorf("error installing %s: %s, %v", archiveURL, output, err)
+    }
+    return LogInstallation(locked.Name, "pacman", locked.Version)
+}
+
+// finds the URL of a package version in the Arch Linux Archive
+func findArchivedPacmanPackage(name, version string) (string, error) {
+    if name == "" || version == "" {
+        return "", fmt.Errorf("a package name and version are needed to search the Arch Linux Archive")
+    }
+
+    for _, pkgArch := range []string{hostArchitecture(), "any"} {
+        for _, extension := range []string{".pkg.tar.zst", ".pkg.tar.xz"} {
+            url := fmt.Sprintf("%s/%c/%s/%s-%s-%s%s", archLinuxArchiveURL, name[0], name, name, version, pkgArch, extension)
+            resp, err := http.Head(url)
+            if err != nil {
+                continue
+            }
+            resp.Body.Close()
+            if resp.StatusCode == http.StatusOK {
+                return url, nil
+            }
+        }
+    }
+    return "", fmt.Errorf("%s %s not found in the Arch Linux Archive", name, version)
+}
+
+// installs a Flatpak and moves it to the locked commit
+func installLockedFlatpakPackage(locked LockedPackage) error {
+    if _, err := GetVersionFromFlatpak(locked.Name); err != nil {
+        if err := InstallPackageFlatpakFromRemote(locked.Remote, locked.Name); err != nil {
+            return err
+        }
+    }
+
+    if locked.Commit != "" {
+        cmd := exec.Command("flatpak", "update", "-y", "--commit="+locked.Commit, locked.Name)
+        if output, err := cmd.CombinedOutput(); err != nil {
+            logger.Errorf("error updating flatpak %s to commit %s: %s, %v", locked.Name, locked.Commit, output, err)
+            return &LockedVersionUnavailableError{Package: locked, Reason: strings.TrimSpace(string(output))}
+        }
+    }
+
+    version, err := GetVersionFromFlatpak(locked.Name)
+    if err != nil {
+        return err
+    }
+    return UpdatePackageInList(locked.Name, "flatpak", version)
+}
+
+// installs or refreshes a Snap to the locked revision
+func installLockedSnapPackage(locked LockedPackage) error {
+    if locked.Revision == "" {
+        return &LockedVersionUnavailableError{Package: locked, Reason: "the lockfile has no revision for it"}
+    }
+
+    action := "install"
+    if _, err := GetVersionFromSnap(locked.Name); err == nil {
+        action = "refresh"
+    }
+
+    cmd := exec.Command("sudo", "snap", action, locked.Name, "--revision="+locked.Revision)
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error installing snap %s revision %s: %s, %v", locked.Name, locked.Revision, output, err)
+        return &LockedVersionUnavailableError{Package: locked, Reason: strings.TrimSpace(string(output))}
+    }
+
+    version, err := GetVersionFromSnap(locked.Name)
+    if err != nil {
+        return err
+    }
+    return UpdatePackageInList(locked.Name, "snap", version)
+}
@@ -0,0 +1,30 @@
+package packagemanager
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestNewestDateStampedClone(t *testing.T) {
+    cacheDir := t.TempDir()
+    for _, clone := range []string{"foo-20240101", "foo-20231224", "foo-bar-20240105", "foo-latest", "foo"} {
+        if err := os.MkdirAll(filepath.Join(cacheDir, clone, ".git"), 0755); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name string
+        want string
+    }{
+        {"foo", filepath.Join(cacheDir, "foo-20240101")},
+        {"foo-bar", filepath.Join(cacheDir, "foo-bar-20240105")},
+        {"bar", ""},
+    }
+    for _, test := range tests {
+        if got := newestDateStampedClone(cacheDir, test.name); got != test.want {
+            t.Errorf("newestDateStampedClone(%q) = %q, want %q", test.name, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,186 @@
+package packagemanager
+
+// This file is responsible for reading and writing package manifests, files that declare which packages
+// a machine should have from each source, so a workstation can be described in git or moved to another machine
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// ManifestPackage represents a single package declared in a manifest
+type ManifestPackage struct {
+    Name string `json:"name"`
+    // pins the package to an exact version
+    Version string `json:"version,omitempty"`
+    // the Snap channel to install from
+    Channel string `json:"channel,omitempty"`
+    // installs a Snap in classic confinement
+    Classic bool `json:"classic,omitempty"`
+    // the Flatpak remote to install from
+    Remote string `json:"remote,omitempty"`
+}
+
+// allows packages without any options to be written as plain strings
+func (p *ManifestPackage) UnmarshalJSON(data []byte) error {
+    var name string
+    if err := json.Unmarshal(data, &name); err == nil {
+        *p = ManifestPackage{Name: name}
+        return nil
+    }
+
+    type manifestPackage ManifestPackage
+    var pkg manifestPackage
+    if err := json.Unmarshal(data, &pkg); err != nil {
+        return err
+    }
+    *p = ManifestPackage(pkg)
+    return nil
+}
+
+// Manifest represents the packages a machine should have, grouped by source
+type Manifest struct {
+    Pacman  []ManifestPackage `json:"pacman,omitempty"`
+    AUR     []ManifestPackage `json:"aur,omitempty"`
+    Flatpak []ManifestPackage `json:"flatpak,omitempty"`
+    Snap    []ManifestPackage `json:"snap,omitempty"`
+}
+
+// ManifestEntry represents a manifest package together with its source
+type ManifestEntry struct {
+    Source  string
+    Package ManifestPackage
+}
+
+// reads and validates the manifest at the given path, as TOML if the file ends in .toml and JSON otherwise
+func ReadManifest(path string) (*Manifest, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        logger.Errorf("error reading manifest: %v", err)
+        return nil, fmt.Errorf("error reading manifest: %v", err)
+    }
+
+    manifest := &Manifest{}
+    if ManifestFormatForPath(path) == "toml" {
+        manifest, err = decodeManifestTOML(data)
+    } else {
+        err = json.Unmarshal(data, manifest)
+    }
+    if err != nil {
+        logger.Errorf("error decoding manifest: %v", err)
+        return nil, fmt.Errorf("error decoding manifest: %v", err)
+    }
+
+    if err := manifest.validate(); err != nil {
+        logger.Errorf("invalid manifest: %v", err)
+        return nil, fmt.Errorf("invalid manifest: %v", err)
+    }
+    return manifest, nil
+}
+
+// encodes the manifest in the given format, either json or toml
+func EncodeManifest(manifest *Manifest, format string) ([]byte, error) {
+    switch format {
+    case "json":
+        data, err := json.MarshalIndent(manifest, "", "  ")
+        if err != nil {
+            logger.Errorf("error encoding manifest: %v", err)
+            return nil, fmt.Errorf("error encoding manifest: %v", err)
+        }
+        return append(data, '\n'), nil
+    case "toml":
+        return encodeManifestTOML(manifest), nil
+    default:
+        return nil, fmt.Errorf("unknown manifest format %s, expected json or toml", format)
+    }
+}
+
+// returns the manifest format matching the extension of the given path
+func ManifestFormatForPath(path string) string {
+    if strings.EqualFold(filepath.Ext(path), ".toml") {
+        return "toml"
+    }
+    return "json"
+}
+
+// builds a manifest of every package AllPac manages, optionally pinning their current versions
+func ExportManifest(includeVersions bool) (*Manifest, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    names := make([]string, 0, len(pkgList))
+    for name := range pkgList {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    manifest := &Manifest{}
+    for _, name := range names {
+        pkgInfo := pkgList[name]
+        packages := manifest.packagesForSource(pkgInfo.Source)
+        if packages == nil {
+            logger.Warnf("Package %s has unknown source %s, leaving it out of the export", name, pkgInfo.Source)
+            continue
+        }
+
+        pkg := ManifestPackage{Name: name}
+        if includeVersions && sourceSupportsVersionPins(pkgInfo.Source) {
+            pkg.Version = pkgInfo.Version
+        }
+        *packages = append(*packages, pkg)
+    }
+    return manifest, nil
+}
+
+// reports whether manifest packages from the given source can pin a version
+func sourceSupportsVersionPins(source string) bool {
+    return source == "pacman" || source == "aur"
+}
+
+// returns every package in the manifest along with its source
+func (m *Manifest) Entries() []ManifestEntry {
+    var entries []ManifestEntry
+    sources := []struct {
+        Source   string
+        Packages []ManifestPackage
+    }{
+        {"pacman", m.Pacman},
+        {"aur", m.AUR},
+        {"flatpak", m.Flatpak},
+        {"snap", m.Snap},
+    }
+    for _, source := range sources {
+        for _, pkg := range source.Packages {
+            entries = append(entries, ManifestEntry{Source: source.Source, Package: pkg})
+        }
+    }
+    return entries
+}
+
+// checks that every package has a name and is only declared once,
+// since the package list can only track a name from a single source
+func (m *Manifest) validate() error {
+    seen := make(map[string]string)
+    for _, entry := range m.Entries() {
+        if entry.Package.Name == "" {
+            return fmt.Errorf("a %s package is missing its name", entry.Source)
+        }
+        if source, exists := seen[entry.Package.Name]; exists {
+            return fmt.Errorf("package %s is declared for both %s and %s", entry.Package.Name, source, entry.Source)
+        }
+        // Snaps and Flatpaks can only be installed at a revision or commit, which is what lockfiles record
+        if entry.Package.Version != "" && !sourceSupportsVersionPins(entry.Source) {
+            return fmt.Errorf("%s package %s can't be pinned to a version, use a channel or remote, or a lockfile for exact versions", entry.Source, entry.Package.Name)
+        }
+        seen[entry.Package.Name] = entry.Source
+    }
+    return nil
+}
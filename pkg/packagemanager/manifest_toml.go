@@ -0,0 +1,244 @@
+package packagemanager
+
+// This file is responsible for reading and writing manifests as TOML. We only need a small part of TOML,
+// arrays of tables holding string and boolean keys, so we handle it ourselves instead of adding a dependency.
+// Anything outside that part is rejected with its line number instead of being misread
+
+import (
+    "bufio"
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// encodes the manifest as TOML, with one [[source]] table per package
+func encodeManifestTOML(manifest *Manifest) []byte {
+    var builder strings.Builder
+    for i, entry := range manifest.Entries() {
+        if i > 0 {
+            builder.WriteString("\n")
+        }
+        pkg := entry.Package
+        fmt.Fprintf(&builder, "[[%s]]\n", entry.Source)
+        fmt.Fprintf(&builder, "name = %s\n", encodeTOMLString(pkg.Name))
+        if pkg.Version != "" {
+            fmt.Fprintf(&builder, "version = %s\n", encodeTOMLString(pkg.Version))
+        }
+        if pkg.Channel != "" {
+            fmt.Fprintf(&builder, "channel = %s\n", encodeTOMLString(pkg.Channel))
+        }
+        if pkg.Classic {
+            builder.WriteString("classic = true\n")
+        }
+        if pkg.Remote != "" {
+            fmt.Fprintf(&builder, "remote = %s\n", encodeTOMLString(pkg.Remote))
+        }
+    }
+    return []byte(builder.String())
+}
+
+// quotes a string as a TOML basic string
+func encodeTOMLString(text string) string {
+    var builder strings.Builder
+    builder.WriteByte('"')
+    for _, r := range text {
+        switch r {
+        case '"':
+            builder.WriteString(`\"`)
+        case '\\':
+            builder.WriteString(`\\`)
+        case '\b':
+            builder.WriteString(`\b`)
+        case '\t':
+            builder.WriteString(`\t`)
+        case '\n':
+            builder.WriteString(`\n`)
+        case '\f':
+            builder.WriteString(`\f`)
+        case '\r':
+            builder.WriteString(`\r`)
+        default:
+            if r < 0x20 || r == 0x7f {
+                fmt.Fprintf(&builder, `\u%04X`, r)
+            } else {
+                builder.WriteRune(r)
+            }
+        }
+    }
+    builder.WriteByte('"')
+    return builder.String()
+}
+
+// decodes a TOML manifest made of [[source]] tables
+func decodeManifestTOML(data []byte) (*Manifest, error) {
+    manifest := &Manifest{}
+    var current *ManifestPackage
+
+    scanner := bufio.NewScanner(strings.NewReader(string(data)))
+    for lineNumber := 1; scanner.Scan(); lineNumber++ {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+
+        if strings.HasPrefix(line, "[") {
+            if !strings.HasPrefix(line, "[[") {
+                return nil, fmt.Errorf("line %d: only [[source]] tables are supported", lineNumber)
+            }
+            header, rest, found := strings.Cut(line[2:], "]]")
+            if !found || !isTOMLComment(rest) {
+                return nil, fmt.Errorf("line %d: expected [[source]]", lineNumber)
+            }
+            source := strings.TrimSpace(header)
+            packages := manifest.packagesForSource(source)
+            if packages == nil {
+                return nil, fmt.Errorf("line %d: unknown source %q", lineNumber, source)
+            }
+            *packages = append(*packages, ManifestPackage{})
+            current = &(*packages)[len(*packages)-1]
+            continue
+        }
+
+        if current == nil {
+            return nil, fmt.Errorf("line %d: expected a [[source]] table", lineNumber)
+        }
+
+        key, value, found := strings.Cut(line, "=")
+        if !found {
+            return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+        }
+        key = strings.TrimSpace(key)
+        if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') {
+            unquoted, rest, err := parseTOMLString(key)
+            if err != nil || rest != "" {
+                return nil, fmt.Errorf("line %d: invalid key %s", lineNumber, key)
+            }
+            key = unquoted
+        }
+        value = strings.TrimSpace(value)
+
+        if key == "classic" {
+            switch {
+            case strings.HasPrefix(value, "true") && isTOMLComment(value[len("true"):]):
+                current.Classic = true
+            case strings.HasPrefix(value, "false") && isTOMLComment(value[len("false"):]):
+                current.Classic = false
+            default:
+                return nil, fmt.Errorf("line %d: classic must be true or false", lineNumber)
+            }
+            continue
+        }
+
+        text, rest, err := parseTOMLString(value)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %s: %v", lineNumber, key, err)
+        }
+        if !isTOMLComment(rest) {
+            return nil, fmt.Errorf("line %d: unexpected %q after the value of %s", lineNumber, strings.TrimSpace(rest), key)
+        }
+        switch key {
+        case "name":
+            current.Name = text
+        case "version":
+            current.Version = text
+        case "channel":
+            current.Channel = text
+        case "remote":
+            current.Remote = text
+        default:
+            return nil, fmt.Errorf("line %d: unknown key %q", lineNumber, key)
+        }
+    }
+
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    return manifest, nil
+}
+
+// parses the basic ("...") or literal ('...') string at the start of a value, returning it and what follows it
+func parseTOMLString(value string) (string, string, error) {
+    if strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+        return "", "", fmt.Errorf("multi-line strings are not supported")
+    }
+    if strings.HasPrefix(value, "'") {
+        text, rest, found := strings.Cut(value[1:], "'")
+        if !found {
+            return "", "", fmt.Errorf("unterminated string")
+        }
+        return text, rest, nil
+    }
+    if !strings.HasPrefix(value, `"`) {
+        return "", "", fmt.Errorf("expected a quoted string")
+    }
+
+    var builder strings.Builder
+    for i := 1; i < len(value); i++ {
+        switch value[i] {
+        case '"':
+            return builder.String(), value[i+1:], nil
+        case '\\':
+            if i+1 >= len(value) {
+                return "", "", fmt.Errorf("unterminated string")
+            }
+            i++
+            switch value[i] {
+            case 'b':
+                builder.WriteByte('\b')
+            case 't':
+                builder.WriteByte('\t')
+            case 'n':
+                builder.WriteByte('\n')
+            case 'f':
+                builder.WriteByte('\f')
+            case 'r':
+                builder.WriteByte('\r')
+            case '"':
+                builder.WriteByte('"')
+            case '\\':
+                builder.WriteByte('\\')
+            case 'u', 'U':
+                digits := 4
+                if value[i] == 'U' {
+                    digits = 8
+                }
+                if i+digits >= len(value) {
+                    return "", "", fmt.Errorf("invalid unicode escape")
+                }
+                code, err := strconv.ParseUint(value[i+1:i+1+digits], 16, 32)
+                if err != nil || !utf8.ValidRune(rune(code)) {
+                    return "", "", fmt.Errorf("invalid unicode escape \\%s", value[i:i+1+digits])
+                }
+                builder.WriteRune(rune(code))
+                i += digits
+            default:
+                return "", "", fmt.Errorf("invalid escape \\%c", value[i])
+            }
+        default:
+            builder.WriteByte(value[i])
+        }
+    }
+    return "", "", fmt.Errorf("unterminated string")
+}
+
+// reports whether what follows a value is only whitespace and an optional comment
+func isTOMLComment(rest string) bool {
+    rest = strings.TrimSpace(rest)
+    return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+// returns the package slice of the manifest for the given source, or nil if the source is unknown
+func (m *Manifest) packagesForSource(source string) *[]ManifestPackage {
+    switch source {
+    case "pacman":
+        return &m.Pacman
+    case "aur":
+        return &m.AUR
+    case "flatpak":
+        return &m.Flatpak
+    case "snap":
+        return &m.Snap
+    }
+    return nil
+}
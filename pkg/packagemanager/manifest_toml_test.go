@@ -0,0 +1,159 @@
+package packagemanager
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestDecodeManifestTOML(t *testing.T) {
+    tests := []struct {
+        name    string
+        data    string
+        want    *Manifest
+        wantErr string
+    }{
+        {
+            name: "tables",
+            data: `
+[[pacman]]
+name = "git"
+
+[[snap]]
+name = "code"
+channel = "stable"
+classic = true
+
+[[flatpak]]
+name = "org.mozilla.firefox"
+remote = "flathub"
+`,
+            want: &Manifest{
+                Pacman:  []ManifestPackage{{Name: "git"}},
+                Snap:    []ManifestPackage{{Name: "code", Channel: "stable", Classic: true}},
+                Flatpak: []ManifestPackage{{Name: "org.mozilla.firefox", Remote: "flathub"}},
+            },
+        },
+        {
+            name: "comments",
+            data: `# workstation
+[[pacman]] # editors
+name = "neovim" # the one true editor
+version = "0.9.5-1"#pinned
+[[snap]]
+name = "code"
+classic = false # confined
+`,
+            want: &Manifest{
+                Pacman: []ManifestPackage{{Name: "neovim", Version: "0.9.5-1"}},
+                Snap:   []ManifestPackage{{Name: "code"}},
+            },
+        },
+        {
+            name: "literal strings",
+            data: "[[aur]]\nname = 'yay'\nversion = 'C:\\path#1'\n",
+            want: &Manifest{AUR: []ManifestPackage{{Name: "yay", Version: `C:\path#1`}}},
+        },
+        {
+            name: "escapes",
+            data: `[[pacman]]
+name = "tab\there \"quoted\" \\ \u00e9\U0001F600"
+`,
+            want: &Manifest{Pacman: []ManifestPackage{{Name: "tab\there \"quoted\" \\ é😀"}}},
+        },
+        {
+            name: "quoted keys",
+            data: "[[pacman]]\n\"name\" = \"git\"\n'version' = \"1\"\n",
+            want: &Manifest{Pacman: []ManifestPackage{{Name: "git", Version: "1"}}},
+        },
+        {
+            name:    "go escape",
+            data:    "[[pacman]]\nname = \"\\x41\"\n",
+            wantErr: `line 2: name: invalid escape \x`,
+        },
+        {
+            name:    "trailing garbage",
+            data:    "[[pacman]]\nname = \"git\" extra\n",
+            wantErr: `line 2: unexpected "extra" after the value of name`,
+        },
+        {
+            name:    "multi-line string",
+            data:    "[[pacman]]\nname = \"\"\"git\"\"\"\n",
+            wantErr: "line 2: name: multi-line strings are not supported",
+        },
+        {
+            name:    "unquoted string",
+            data:    "[[pacman]]\nname = git\n",
+            wantErr: "line 2: name: expected a quoted string",
+        },
+        {
+            name:    "unterminated literal string",
+            data:    "[[pacman]]\nname = 'git\n",
+            wantErr: "line 2: name: unterminated string",
+        },
+        {
+            name:    "plain table",
+            data:    "[pacman]\nname = \"git\"\n",
+            wantErr: "line 1: only [[source]] tables are supported",
+        },
+        {
+            name:    "unknown source",
+            data:    "[[brew]]\n",
+            wantErr: `line 1: unknown source "brew"`,
+        },
+        {
+            name:    "key outside a table",
+            data:    "name = \"git\"\n",
+            wantErr: "line 1: expected a [[source]] table",
+        },
+        {
+            name:    "invalid boolean",
+            data:    "[[snap]]\nname = \"code\"\nclassic = yes\n",
+            wantErr: "line 3: classic must be true or false",
+        },
+        {
+            name:    "unknown key",
+            data:    "[[pacman]]\nname = \"git\"\nbranch = \"main\"\n",
+            wantErr: `line 3: unknown key "branch"`,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            manifest, err := decodeManifestTOML([]byte(test.data))
+            if test.wantErr != "" {
+                if err == nil || err.Error() != test.wantErr {
+                    t.Fatalf("error = %v, want %q", err, test.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !reflect.DeepEqual(manifest, test.want) {
+                t.Errorf("decoded %+v, want %+v", manifest, test.want)
+            }
+        })
+    }
+}
+
+func TestManifestTOMLRoundTrip(t *testing.T) {
+    manifest := &Manifest{
+        Pacman:  []ManifestPackage{{Name: "go", Version: "2:1.21.5-1"}},
+        AUR:     []ManifestPackage{{Name: "odd \"name\"\\\t\x01"}},
+        Flatpak: []ManifestPackage{{Name: "org.mozilla.firefox", Remote: "flathub"}},
+        Snap:    []ManifestPackage{{Name: "code", Channel: "latest/stable", Classic: true}},
+    }
+
+    data := encodeManifestTOML(manifest)
+    if strings.Contains(string(data), `\x`) {
+        t.Errorf("encoded a Go escape that TOML doesn't have:\n%s", data)
+    }
+    decoded, err := decodeManifestTOML(data)
+    if err != nil {
+        t.Fatalf("decoding the encoded manifest: %v\n%s", err, data)
+    }
+    if !reflect.DeepEqual(decoded, manifest) {
+        t.Errorf("round trip gave %+v, want %+v", decoded, manifest)
+    }
+}
@@ -0,0 +1,270 @@
+package packagemanager
+
+// This file is responsible for finding managed packages that have updates available, without applying them.
+// Every source is queried in bulk, so this stays fast enough to run from status bars and cron jobs
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the AUR RPC limits how many packages a single info request may ask for
+const aurInfoBatchSize = 100
+
+// OutdatedPackage represents a managed package with a newer version available
+type OutdatedPackage struct {
+    Name             string
+    Source           string
+    InstalledVersion string
+    AvailableVersion string
+    Held             bool
+}
+
+// holds the installed and available versions of a package
+type versionPair struct {
+    Installed string
+    Available string
+}
+
+// lists every managed package that has an update available in its source
+func FindOutdatedPackages() ([]OutdatedPackage, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    pacmanPackages, aurPackages, snapPackages, flatpakPackages := separatePackagesBySource(pkgList)
+
+    var outdated []OutdatedPackage
+    checks := []struct {
+        Source   string
+        Packages []string
+        Check    func([]string) (map[string]versionPair, error)
+    }{
+        {"pacman", pacmanPackages, findOutdatedPacmanPackages},
+        {"aur", aurPackages, findOutdatedAURPackages},
+        {"flatpak", flatpakPackages, findOutdatedFlatpakPackages},
+        {"snap", snapPackages, findOutdatedSnapPackages},
+    }
+
+    for _, check := range checks {
+        if len(check.Packages) == 0 {
+            continue
+        }
+        versions, err := check.Check(check.Packages)
+        if err != nil {
+            return nil, err
+        }
+        for name, version := range versions {
+            outdated = append(outdated, OutdatedPackage{
+                Name:             name,
+                Source:           check.Source,
+                InstalledVersion: version.Installed,
+                AvailableVersion: version.Available,
+                Held:             pkgList[name].Held,
+            })
+        }
+    }
+
+    sort.Slice(outdated, func(i, j int) bool { return outdated[i].Name < outdated[j].Name })
+    return outdated, nil
+}
+
+// compares installed pacman packages with the sync databases, returning installed and available versions
+func findOutdatedPacmanPackages(packageNames []string) (map[string]versionPair, error) {
+    installed, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+
+    // pacman -Sl lists every package in the sync databases, in repository order, in a single call
+    output, err := exec.Command("pacman", "-Sl").CombinedOutput()
+    if err != nil {
+        logger.Errorf("error listing pacman sync databases: %s, %v", output, err)
+        return nil, fmt.Errorf("error listing pacman sync databases: %s, %v", output, err)
+    }
+
+    available := make(map[string]string)
+    for _, line := range strings.Split(string(output), "\n") {
+        // Lines look like: core pacman 6.0.2-9 [installed]
+        fields := strings.Fields(line)
+        if len(fields) < 3 {
+            continue
+        }
+        if _, seen := available[fields[1]]; !seen {
+            available[fields[1]] = fields[2]
+        }
+    }
+
+    return compareVersions(packageNames, installed, available), nil
+}
+
+// returns how long ago the pacman sync databases were last refreshed, since pacman -Sl only sees
+// the updates that were known at that point
+func PacmanSyncDatabaseAge() (time.Duration, error) {
+    databases, err := filepath.Glob(filepath.Join(getAlpmHandle().DBPath, "sync", "*.db"))
+    if err != nil || len(databases) == 0 {
+        logger.Errorf("no pacman sync databases found in %s", getAlpmHandle().DBPath)
+        return 0, fmt.Errorf("no pacman sync databases found in %s", getAlpmHandle().DBPath)
+    }
+
+    var newest time.Time
+    for _, database := range databases {
+        info, err := os.Stat(database)
+        if err != nil {
+            logger.Errorf("error reading %s: %v", database, err)
+            return 0, fmt.Errorf("error reading %s: %v", database, err)
+        }
+        if info.ModTime().After(newest) {
+            newest = info.ModTime()
+        }
+    }
+    return time.Since(newest), nil
+}
+
+// compares installed AUR packages with the AUR RPC, returning installed and available versions
+func findOutdatedAURPackages(packageNames []string) (map[string]versionPair, error) {
+    installed, err := queryPacmanPackages("-Qm")
+    if err != nil {
+        return nil, err
+    }
+
+    available, err := fetchAURPackageVersions(packageNames)
+    if err != nil {
+        return nil, err
+    }
+
+    return compareVersions(packageNames, installed, available), nil
+}
+
+// asks Flatpak which installed applications have updates, returning installed and available versions
+func findOutdatedFlatpakPackages(packageNames []string) (map[string]versionPair, error) {
+    installedPackages, err := listFlatpakSystemPackages()
+    if err != nil {
+        return nil, err
+    }
+    installed := make(map[string]string)
+    for _, pkg := range installedPackages {
+        installed[pkg.Name] = pkg.Version
+    }
+
+    output, err := exec.Command("flatpak", "remote-ls", "--updates", "--columns=application,version").CombinedOutput()
+    if err != nil {
+        logger.Errorf("error listing Flatpak updates: %s, %v", output, err)
+        return nil, fmt.Errorf("error listing Flatpak updates: %s, %v", output, err)
+    }
+
+    outdated := make(map[string]versionPair)
+    wanted := make(map[string]bool)
+    for _, name := range packageNames {
+        wanted[name] = true
+    }
+    for _, line := range strings.Split(string(output), "\n") {
+        columns := strings.Split(line, "\t")
+        name := strings.TrimSpace(columns[0])
+        if !wanted[name] {
+            continue
+        }
+        // Flatpak lists updates even when the version string didn't change, e.g. for runtime rebuilds
+        version := ""
+        if len(columns) >= 2 {
+            version = strings.TrimSpace(columns[1])
+        }
+        outdated[name] = versionPair{installed[name], version}
+    }
+    return outdated, nil
+}
+
+// asks snapd which installed Snaps have refreshes, returning installed and available versions
+func findOutdatedSnapPackages(packageNames []string) (map[string]versionPair, error) {
+    installedPackages, err := listSnapSystemPackages()
+    if err != nil {
+        return nil, err
+    }
+    installed := make(map[string]string)
+    for _, pkg := range installedPackages {
+        installed[pkg.Name] = pkg.Version
+    }
+
+    output, err := exec.Command("snap", "refresh", "--list").CombinedOutput()
+    if err != nil {
+        logger.Errorf("error listing Snap refreshes: %s, %v", output, err)
+        return nil, fmt.Errorf("error listing Snap refreshes: %s, %v", output, err)
+    }
+
+    wanted := make(map[string]bool)
+    for _, name := range packageNames {
+        wanted[name] = true
+    }
+
+    // Columns are: Name Version Rev Size Publisher Notes, or "All snaps up to date." when there is nothing
+    outdated := make(map[string]versionPair)
+    for _, line := range strings.Split(string(output), "\n")[1:] {
+        fields := strings.Fields(line)
+        if len(fields) >= 2 && wanted[fields[0]] {
+            outdated[fields[0]] = versionPair{installed[fields[0]], fields[1]}
+        }
+    }
+    return outdated, nil
+}
+
+// returns the packages whose available version is newer than the installed one, so packages
+// installed from a newer build or another repository aren't offered a downgrade
+func compareVersions(packageNames []string, installed, available map[string]string) map[string]versionPair {
+    outdated := make(map[string]versionPair)
+    for _, name := range packageNames {
+        installedVersion, isInstalled := installed[name]
+        availableVersion, isAvailable := available[name]
+        if isInstalled && isAvailable && vercmp(availableVersion, installedVersion) > 0 {
+            outdated[name] = versionPair{installedVersion, availableVersion}
+        }
+    }
+    return outdated
+}
+
+// fetches the current versions of many AUR packages, batching the RPC requests
+func fetchAURPackageVersions(packageNames []string) (map[string]string, error) {
+    versions := make(map[string]string)
+    for start := 0; start < len(packageNames); start += aurInfoBatchSize {
+        end := start + aurInfoBatchSize
+        if end > len(packageNames) {
+            end = len(packageNames)
+        }
+
+        query := url.Values{"v": {"5"}, "type": {"info"}}
+        for _, name := range packageNames[start:end] {
+            query.Add("arg[]", name)
+        }
+
+        resp, err := http.Get("https://aur.archlinux.org/rpc/?" + query.Encode())
+        if err != nil {
+            logger.Errorf("error making request to AUR: %v", err)
+            return nil, fmt.Errorf("error making request to AUR: %v", err)
+        }
+
+        var result struct {
+            Results []AURPackageInfo `json:"results"`
+        }
+        err = json.NewDecoder(resp.Body).Decode(&result)
+        resp.Body.Close()
+        if err != nil {
+            logger.Errorf("error decoding AUR response: %v", err)
+            return nil, fmt.Errorf("error decoding AUR response: %v", err)
+        }
+
+        for _, info := range result.Results {
+            versions[info.Name] = info.Version
+        }
+    }
+    return versions, nil
+}
@@ -7,26 +7,47 @@ import (
 	"fmt"
     "path/filepath"
 	"encoding/json"
+	"time"
 )
 
 type PackageInfo struct {
     Source  string `json:"source"`
     Version string `json:"version"`
+    // the commit of the AUR repository the package was last built from
+    Commit  string `json:"commit,omitempty"`
+    // held packages are skipped by every update
+    Held    bool   `json:"held,omitempty"`
+    // trusted AUR packages are built without reviewing their build files
+    Trusted bool   `json:"trusted,omitempty"`
+    // when AllPac installed the package, in RFC 3339 format
+    InstalledAt string `json:"installed_at,omitempty"`
+    // the upstream commits the sources of a VCS package pointed to when it was built, by source
+    VCS map[string]string `json:"vcs,omitempty"`
 }
 
 type PackageList map[string]PackageInfo
 
 const pkgListFilename = "pkg.list"
 
-// returns the file path for the package list
-func GetPkgListPath() (string, error) {
+// returns the home directory of the user running AllPac, which ~/.allpac lives in. It comes from the
+// user database rather than $HOME, and tests replace it to keep away from the real ~/.allpac
+var currentHomeDir = func() (string, error) {
     usr, err := user.Current()
     if err != nil {
         logger.Errorf("error getting current user: %v", err)
         return "", fmt.Errorf("error getting current user: %v", err)
     }
+    return usr.HomeDir, nil
+}
+
+// returns the file path for the package list
+func GetPkgListPath() (string, error) {
+    home, err := currentHomeDir()
+    if err != nil {
+        return "", err
+    }
 
-    pkgListDir := filepath.Join(usr.HomeDir, ".allpac")
+    pkgListDir := filepath.Join(home, ".allpac")
     pkgListPath := filepath.Join(pkgListDir, pkgListFilename)
 
     logger.Infof("Checking directory: %s", pkgListDir)
@@ -134,6 +155,8 @@ func writePackageList(pkgList PackageList) error {
         return err
     }
 
+    backupPackageListAutomatically()
+
     file, err := os.Create(pkgListPath)
     if err != nil {
         logger.Errorf("error creating package list file: %v", err)
@@ -158,12 +181,21 @@ func LogInstallation(packageName, source, version string) error {
         return err
     }
 
-    pkgList[packageName] = PackageInfo{
-        Source:  source,
-        Version: version,
+    // Keep anything else we know about the package, such as a hold, when it is reinstalled
+    pkgInfo := pkgList[packageName]
+    pkgInfo.Source = source
+    pkgInfo.Version = version
+    if pkgInfo.InstalledAt == "" {
+        pkgInfo.InstalledAt = time.Now().Format(time.RFC3339)
     }
+    pkgList[packageName] = pkgInfo
 
-    return writePackageList(pkgList)
+    if err := writePackageList(pkgList); err != nil {
+        return err
+    }
+
+    appendHistory("install", packageName, source, version)
+    return nil
 }
 
 // removes a package from the package list file
@@ -182,6 +214,7 @@ func RemovePackageFromList(packageName string) error {
     }
 
     // Remove the package from the list
+    pkgInfo := pkgList[packageName]
     delete(pkgList, packageName)
     logger.Infof("Package %s removed from the package list", packageName)
 
@@ -191,6 +224,7 @@ func RemovePackageFromList(packageName string) error {
         return err
     }
 
+    appendHistory("remove", packageName, pkgInfo.Source, pkgInfo.Version)
     return nil
 }
 
@@ -225,5 +259,30 @@ func UpdatePackageInList(packageName, source, newVersion string) error {
         return err
     }
 
+    appendHistory("update", packageName, source, newVersion)
+    return nil
+}
+
+// applies the given change to a package already in the package list file
+func updatePackageInfo(packageName string, update func(*PackageInfo)) error {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("An error has occurred while reading the package list: %v", err)
+        return err
+    }
+
+    pkgInfo, exists := pkgList[packageName]
+    if !exists {
+        logger.Errorf("package %s not found in package list", packageName)
+        return fmt.Errorf("package %s not found in package list", packageName)
+    }
+
+    update(&pkgInfo)
+    pkgList[packageName] = pkgInfo
+
+    if err := writePackageList(pkgList); err != nil {
+        logger.Errorf("An error has occurred while writing the updated package list: %v", err)
+        return err
+    }
     return nil
 }
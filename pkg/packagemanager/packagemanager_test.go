@@ -0,0 +1,37 @@
+package packagemanager
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// runs the tests with ~/.allpac, including the log, in a temporary home directory
+func TestMain(m *testing.M) {
+    home, err := os.MkdirTemp("", "allpac-test")
+    if err != nil {
+        panic(err)
+    }
+    currentHomeDir = func() (string, error) {
+        return home, nil
+    }
+    if err := logger.Init(filepath.Join(home, ".allpac", "logs", "allpac.log")); err != nil {
+        panic(err)
+    }
+
+    code := m.Run()
+    os.RemoveAll(home)
+    os.Exit(code)
+}
+
+func TestPkgListPathUsesTestHome(t *testing.T) {
+    home, _ := currentHomeDir()
+    pkgListPath, err := GetPkgListPath()
+    if err != nil {
+        t.Fatalf("GetPkgListPath() error = %v", err)
+    }
+    if want := filepath.Join(home, ".allpac", pkgListFilename); pkgListPath != want {
+        t.Errorf("GetPkgListPath() = %q, want %q", pkgListPath, want)
+    }
+}
@@ -4,76 +4,74 @@ package packagemanager
 
 import (
 	"fmt"
-	"os/exec"
     "strings"
 	"pixelridgesoftworks.com/AllPac/pkg/logger"
 )
 
-// updates specified Pacman packages or all if no specific package is provided
+// the directory where pacman keeps downloaded package archives
+const pacmanCacheDir = "/var/cache/pacman/pkg"
+
+// updates specified Pacman packages or all if no specific package is provided. Arch Linux doesn't support
+// partial upgrades, so updating any package upgrades the whole system, and the recorded versions of all
+// managed Pacman packages are updated afterwards
 func UpdatePacmanPackages(packageNames ...string) error {
-    // If no specific packages are provided, update all packages
     if len(packageNames) == 0 {
         logger.Info("No specific package names provided, updating all Pacman packages")
-        cmd := exec.Command("sudo", "pacman", "-Syu", "--noconfirm")
-        if output, err := cmd.CombinedOutput(); err != nil {
-            logger.Errorf("error updating all Pacman packages: %s, %v", string(output), err)
-            return fmt.Errorf("error updating all Pacman packages: %s, %v", string(output), err)
-        }
-        return nil
-    }
-    // Read the current package list
-    pkgList, err := ReadPackageList()
-    if err != nil {
-        logger.Errorf("error reading package list: %v", err)
-        return fmt.Errorf("error reading package list: %v", err)
-    }
-
-    var packagesToUpdate []string
-    for _, packageName := range packageNames {
-        installedInfo, ok := pkgList[packageName]
-        if !ok {
-            logger.Infof("Package %s not managed by AllPac, skipping", packageName)
-            continue
-        }
-
-        latestVersion, err := GetPacmanLatestVersion(packageName)
+    } else {
+        // Read the current package list
+        pkgList, err := ReadPackageList()
         if err != nil {
-            logger.Errorf("error getting latest version for Pacman package %s: %v", packageName, err)
-            continue
+            logger.Errorf("error reading package list: %v", err)
+            return fmt.Errorf("error reading package list: %v", err)
         }
 
-        if installedInfo.Version != latestVersion {
-            packagesToUpdate = append(packagesToUpdate, packageName)
-        }
-    }
-
-    if len(packagesToUpdate) > 0 {
-        args := append([]string{"sudo", "pacman", "-Syu", "--noconfirm"}, packagesToUpdate...)
-        cmd := exec.Command(args[0], args[1:]...)
-        if output, err := cmd.CombinedOutput(); err != nil {
-            logger.Errorf("error updating Pacman packages: %s, %v", string(output), err)
-            return fmt.Errorf("error updating Pacman packages: %s, %v", string(output), err)
-        }
+        var outdated []string
+        for _, packageName := range filterHeldPackages(pkgList, packageNames) {
+            installedInfo, ok := pkgList[packageName]
+            if !ok {
+                logger.Infof("Package %s not managed by AllPac, skipping", packageName)
+                continue
+            }
 
-        // Update the package list with the new versions
-        for _, packageName := range packagesToUpdate {
-            newVersion, err := GetPacmanLatestVersion(packageName)
+            latestVersion, err := GetPacmanLatestVersion(packageName)
             if err != nil {
-                logger.Errorf("error getting new version for Pacman package %s after update: %v", packageName, err)
+                logger.Errorf("error getting latest version for Pacman package %s: %v", packageName, err)
                 continue
             }
-            if err := UpdatePackageInList(packageName, "pacman", newVersion); err != nil {
-                logger.Errorf("error updating package list for %s: %v", packageName, err)
-                return fmt.Errorf("error updating package list for %s: %v", packageName, err)
+
+            if installedInfo.Version != latestVersion {
+                outdated = append(outdated, packageName)
             }
         }
-    } else {
-        logger.Info("No Pacman packages need updating")
+
+        if len(outdated) == 0 {
+            logger.Info("No Pacman packages need updating")
+            return nil
+        }
+        logger.Infof("Upgrading the system to update %s", strings.Join(outdated, ", "))
     }
 
+    upgrades, err := ensureSystemUpgraded()
+    if err != nil {
+        return err
+    }
+    reportPacmanUpgrades(upgrades)
     return nil
 }
 
+// prints the packages pacman upgraded
+func reportPacmanUpgrades(upgrades []PacmanUpgrade) {
+    if len(upgrades) == 0 {
+        fmt.Println("Pacman didn't upgrade any packages.")
+        return
+    }
+
+    fmt.Printf("Pacman upgraded %d packages:\n", len(upgrades))
+    for _, upgrade := range upgrades {
+        fmt.Printf("  %s %s -> %s\n", upgrade.Name, upgrade.OldVersion, upgrade.NewVersion)
+    }
+}
+
 // uninstalls a specified Pacman package
 func UninstallPacmanPackage(packageName string) error {
     // Read the current package list
@@ -90,7 +88,7 @@ func UninstallPacmanPackage(packageName string) error {
     }
 
     // Uninstalling the Pacman package
-    cmd := exec.Command("sudo", "pacman", "-Rns", "--noconfirm", packageName)
+    cmd := newCommand(pacmanRemoveCommand(packageName))
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error uninstalling Pacman package: %s, %v", output, err)
         return fmt.Errorf("error uninstalling Pacman package: %s, %v", output, err)
@@ -106,15 +104,55 @@ func UninstallPacmanPackage(packageName string) error {
     return nil
 }
 
-// retrieves the latest available version of a package from Pacman
+// retrieves the latest available version of a package from Pacman. When several repositories have the
+// package, the first one wins, just like it does for pacman itself
 func GetPacmanLatestVersion(packageName string) (string, error) {
-    cmd := exec.Command("pacman", "-Si", packageName)
-    output, err := cmd.CombinedOutput()
+    if pkg, found, err := lookupSyncPackage(packageName); err == nil {
+        if !found {
+            logger.Errorf("package %s not found in Pacman", packageName)
+            return "", fmt.Errorf("package %s not found in Pacman", packageName)
+        }
+        return pkg.Version, nil
+    }
+
+    records, err := queryPacmanInfo("-Si", packageName)
     if err != nil {
         return "", fmt.Errorf("error getting package info from Pacman: %v", err)
     }
-    // Parse the output to find the version
-    versionLine := strings.Split(string(output), "\n")[2]
-    version := strings.Fields(versionLine)[2]
-    return version, nil
+    return pacmanRecordVersion(records, packageName)
+}
+
+// retrieves the installed version of a package from Pacman
+func GetPacmanInstalledVersion(packageName string) (string, error) {
+    if pkg, found, err := lookupLocalPackage(packageName); err == nil {
+        if !found {
+            logger.Errorf("package %s is not installed", packageName)
+            return "", fmt.Errorf("package %s is not installed", packageName)
+        }
+        return pkg.Version, nil
+    }
+
+    records, err := queryPacmanInfo("-Qi", packageName)
+    if err != nil {
+        return "", fmt.Errorf("error getting installed package info from Pacman: %v", err)
+    }
+    return pacmanRecordVersion(records, packageName)
+}
+
+// returns the version of the first info record for exactly the given package, which may be
+// prefixed with its repository like "extra/git"
+func pacmanRecordVersion(records []PacmanInfoRecord, packageName string) (string, error) {
+    name := packageName
+    if index := strings.LastIndex(name, "/"); index >= 0 {
+        name = name[index+1:]
+    }
+
+    for _, record := range records {
+        if record.Get("Name") == name && record.Get("Version") != "" {
+            return record.Get("Version"), nil
+        }
+    }
+
+    logger.Errorf("package %s not found in Pacman", packageName)
+    return "", fmt.Errorf("package %s not found in Pacman", packageName)
 }
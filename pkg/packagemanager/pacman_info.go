@@ -0,0 +1,78 @@
+package packagemanager
+
+// This file is responsible for parsing the key-value blocks printed by pacman -Qi and -Si
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// PacmanInfoField represents a single "Key : Value" line of pacman's package info
+type PacmanInfoField struct {
+    Key   string
+    Value string
+}
+
+// PacmanInfoRecord represents the info block of a single package, keeping pacman's field order
+type PacmanInfoRecord []PacmanInfoField
+
+// returns the value of the given key, or an empty string if the record doesn't have it
+func (r PacmanInfoRecord) Get(key string) string {
+    for _, field := range r {
+        if field.Key == key {
+            return field.Value
+        }
+    }
+    return ""
+}
+
+// runs pacman with the given arguments in the C locale and parses the info blocks it prints
+func queryPacmanInfo(args ...string) ([]PacmanInfoRecord, error) {
+    cmd := exec.Command("pacman", args...)
+    // Field names and dates are translated, so we always ask for the untranslated output
+    cmd.Env = append(os.Environ(), "LC_ALL=C")
+    output, err := cmd.Output()
+    if err != nil && len(output) == 0 {
+        logger.Errorf("error querying pacman %v: %v", args, err)
+        return nil, fmt.Errorf("error querying pacman %v: %v", args, err)
+    }
+
+    return parsePacmanInfo(string(output)), nil
+}
+
+// parses pacman's info output into one record per package
+func parsePacmanInfo(output string) []PacmanInfoRecord {
+    var records []PacmanInfoRecord
+    var current PacmanInfoRecord
+
+    for _, line := range strings.Split(output, "\n") {
+        if strings.TrimSpace(line) == "" {
+            if len(current) > 0 {
+                records = append(records, current)
+                current = nil
+            }
+            continue
+        }
+
+        // Long values, like optional dependencies, continue on indented lines
+        if strings.HasPrefix(line, " ") && len(current) > 0 {
+            last := &current[len(current)-1]
+            last.Value += "\n" + strings.TrimSpace(line)
+            continue
+        }
+
+        key, value, found := strings.Cut(line, ":")
+        if !found {
+            continue
+        }
+        current = append(current, PacmanInfoField{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+    }
+
+    if len(current) > 0 {
+        records = append(records, current)
+    }
+    return records
+}
@@ -0,0 +1,73 @@
+package packagemanager
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParsePacmanInfo(t *testing.T) {
+    tests := []struct {
+        name   string
+        output string
+        want   []PacmanInfoRecord
+    }{
+        {
+            name:   "empty",
+            output: "",
+            want:   nil,
+        },
+        {
+            name: "single package",
+            output: "Name            : git\n" +
+                "Version         : 2.43.0-1\n" +
+                "Build Date      : Mon 20 Nov 2023 09:03:09 PM UTC\n",
+            want: []PacmanInfoRecord{{
+                {"Name", "git"},
+                {"Version", "2.43.0-1"},
+                {"Build Date", "Mon 20 Nov 2023 09:03:09 PM UTC"},
+            }},
+        },
+        {
+            name: "continued and empty values",
+            output: "Name            : git\n" +
+                "Optional Deps   : tk: gitk and git gui\n" +
+                "                  perl-libwww: git svn [installed]\n" +
+                "Required By     : None\n" +
+                "Groups          : \n",
+            want: []PacmanInfoRecord{{
+                {"Name", "git"},
+                {"Optional Deps", "tk: gitk and git gui\nperl-libwww: git svn [installed]"},
+                {"Required By", "None"},
+                {"Groups", ""},
+            }},
+        },
+        {
+            name: "several packages",
+            output: "Repository      : core\nName            : pacman\nVersion         : 6.0.2-9\n\n" +
+                "Repository      : extra\nName            : git\nVersion         : 2.43.0-1\n\n\n",
+            want: []PacmanInfoRecord{
+                {{"Repository", "core"}, {"Name", "pacman"}, {"Version", "6.0.2-9"}},
+                {{"Repository", "extra"}, {"Name", "git"}, {"Version", "2.43.0-1"}},
+            },
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got := parsePacmanInfo(test.output)
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("parsePacmanInfo() = %q, want %q", got, test.want)
+            }
+        })
+    }
+}
+
+func TestPacmanInfoRecordGet(t *testing.T) {
+    record := PacmanInfoRecord{{"Name", "git"}, {"Version", "2.43.0-1"}}
+    if got := record.Get("Version"); got != "2.43.0-1" {
+        t.Errorf("Get(Version) = %q, want 2.43.0-1", got)
+    }
+    if got := record.Get("Licenses"); got != "" {
+        t.Errorf("Get(Licenses) = %q, want an empty string", got)
+    }
+}
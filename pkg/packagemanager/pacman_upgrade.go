@@ -0,0 +1,170 @@
+package packagemanager
+
+// This file is responsible for AllPac's pacman upgrade policy. Arch Linux doesn't support partial upgrades,
+// so every AllPac run syncs and upgrades the whole system at most once, and every later pacman install
+// only adds packages on top of that with -S --needed
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "regexp"
+    "sort"
+    "sync"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the log pacman appends every transaction to
+const pacmanLogPath = "/var/log/pacman.log"
+
+// matches the lines pacman writes to its log for every upgraded package, e.g.
+// [2024-01-10T10:00:00+0100] [ALPM] upgraded linux (6.6.9.arch1-1 -> 6.6.10.arch1-1)
+var pacmanLogUpgradePattern = regexp.MustCompile(`\[ALPM\] upgraded (\S+) \((\S+) -> (\S+)\)`)
+
+var (
+    systemUpgradeMutex sync.Mutex
+    systemUpgraded     bool
+    systemUpgrades     []PacmanUpgrade
+)
+
+// PacmanUpgrade represents a package pacman upgraded during a system upgrade
+type PacmanUpgrade struct {
+    Name       string
+    OldVersion string
+    NewVersion string
+}
+
+// reports whether the system was already upgraded during this run
+func isSystemUpgraded() bool {
+    systemUpgradeMutex.Lock()
+    defer systemUpgradeMutex.Unlock()
+    return systemUpgraded
+}
+
+// syncs and upgrades the whole system, unless that already happened during this run, and returns the
+// packages pacman upgraded. The recorded versions of all managed pacman packages are updated afterwards
+func ensureSystemUpgraded() ([]PacmanUpgrade, error) {
+    systemUpgradeMutex.Lock()
+    defer systemUpgradeMutex.Unlock()
+
+    if systemUpgraded {
+        return systemUpgrades, nil
+    }
+
+    before, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+    logOffset := pacmanLogSize()
+
+    cmd := newCommand(pacmanUpgradeCommand())
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error upgrading the system: %s, %v", output, err)
+        return nil, fmt.Errorf("error upgrading the system: %s, %v", output, err)
+    }
+
+    after, err := queryPacmanPackages("-Q")
+    if err != nil {
+        return nil, err
+    }
+
+    // The log has the versions exactly as pacman saw them, comparing the installed packages is only our fallback
+    upgrades, err := readPacmanLogUpgrades(logOffset)
+    if err != nil {
+        logger.Warnf("unable to read the upgraded packages from %s, comparing installed versions instead: %v", pacmanLogPath, err)
+        upgrades = diffPacmanVersions(before, after)
+    }
+
+    for _, upgrade := range upgrades {
+        logger.Infof("Pacman upgraded %s from %s to %s", upgrade.Name, upgrade.OldVersion, upgrade.NewVersion)
+    }
+
+    if err := recordPacmanVersions(after); err != nil {
+        return nil, err
+    }
+
+    systemUpgraded = true
+    systemUpgrades = upgrades
+    return upgrades, nil
+}
+
+// returns the current size of the pacman log, or -1 if it can't be read
+func pacmanLogSize() int64 {
+    info, err := os.Stat(pacmanLogPath)
+    if err != nil {
+        return -1
+    }
+    return info.Size()
+}
+
+// reads the packages upgraded according to the lines appended to the pacman log after the given offset
+func readPacmanLogUpgrades(offset int64) ([]PacmanUpgrade, error) {
+    if offset < 0 {
+        return nil, fmt.Errorf("the pacman log was not readable before the upgrade")
+    }
+
+    file, err := os.Open(pacmanLogPath)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    if _, err := file.Seek(offset, io.SeekStart); err != nil {
+        return nil, err
+    }
+
+    var upgrades []PacmanUpgrade
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        if match := pacmanLogUpgradePattern.FindStringSubmatch(scanner.Text()); match != nil {
+            upgrades = append(upgrades, PacmanUpgrade{Name: match[1], OldVersion: match[2], NewVersion: match[3]})
+        }
+    }
+    return upgrades, scanner.Err()
+}
+
+// returns the packages whose installed version changed between two pacman queries
+func diffPacmanVersions(before, after map[string]string) []PacmanUpgrade {
+    var upgrades []PacmanUpgrade
+    for name, newVersion := range after {
+        if oldVersion, existed := before[name]; existed && oldVersion != newVersion {
+            upgrades = append(upgrades, PacmanUpgrade{Name: name, OldVersion: oldVersion, NewVersion: newVersion})
+        }
+    }
+    sort.Slice(upgrades, func(i, j int) bool { return upgrades[i].Name < upgrades[j].Name })
+    return upgrades
+}
+
+// records the installed version of every managed pacman package in the package list
+func recordPacmanVersions(installed map[string]string) error {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+
+    var changed []string
+    for name, pkgInfo := range pkgList {
+        version, isInstalled := installed[name]
+        if pkgInfo.Source != "pacman" || !isInstalled || version == pkgInfo.Version {
+            continue
+        }
+        pkgInfo.Version = version
+        pkgList[name] = pkgInfo
+        changed = append(changed, name)
+    }
+
+    if len(changed) == 0 {
+        return nil
+    }
+    if err := writePackageList(pkgList); err != nil {
+        return err
+    }
+
+    sort.Strings(changed)
+    for _, name := range changed {
+        appendHistory("update", name, "pacman", pkgList[name].Version)
+    }
+    return nil
+}
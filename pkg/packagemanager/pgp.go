@@ -0,0 +1,211 @@
+package packagemanager
+
+// This file is responsible for the PGP keys AUR packages verify their sources with. A PKGBUILD lists the
+// fingerprints it trusts in validpgpkeys, and makepkg refuses to build when one of them isn't in the
+// user's GnuPG keyring, so missing keys are shown and imported, after asking, before the build starts
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the keyserver missing keys are received from unless the config says otherwise
+const defaultPGPKeyserver = "hkps://keyserver.ubuntu.com"
+
+// the file extensions looked for when keys are imported from a key directory
+var pgpKeyFileExtensions = []string{".asc", ".gpg", ".pub", ".key"}
+
+// matches the key makepkg reports when it can't verify a source, e.g.
+// "FAILED (unknown public key 1234567890ABCDEF)"
+var makepkgUnknownKeyPattern = regexp.MustCompile(`unknown public key ([0-9A-Fa-f]+)`)
+
+// PGPVerificationError is returned when the sources of an AUR package can't be verified, either because
+// the keys in its validpgpkeys weren't imported or because makepkg rejected a signature
+type PGPVerificationError struct {
+    Package string
+    // the fingerprints or key IDs that are missing from the keyring
+    MissingKeys []string
+    // the makepkg output, if the error comes from a failed build
+    Output string
+}
+
+func (e *PGPVerificationError) Error() string {
+    if len(e.MissingKeys) > 0 {
+        return fmt.Sprintf("unable to verify the sources of %s, the PGP keys %s are not in your keyring", e.Package, strings.Join(e.MissingKeys, ", "))
+    }
+    return fmt.Sprintf("unable to verify the PGP signatures of the sources of %s: %s", e.Package, e.Output)
+}
+
+// returns the fingerprints in the validpgpkeys of the package in a clone
+func requiredPGPKeys(cloneDir string) ([]string, error) {
+    srcinfo, err := cloneSrcinfo(cloneDir)
+    if err != nil {
+        return nil, err
+    }
+
+    var keys []string
+    seen := make(map[string]bool)
+    for _, key := range srcinfo["validpgpkeys"] {
+        key = normalizePGPKey(key)
+        if key != "" && !seen[key] {
+            seen[key] = true
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    return keys, nil
+}
+
+// returns the keys that aren't in the build user's GnuPG keyring
+func missingPGPKeys(keys []string) ([]string, error) {
+    var missing []string
+    for _, key := range keys {
+        cmd, err := newBuildCommand(gpgListKeysCommand(key))
+        if err != nil {
+            return nil, err
+        }
+        if err := cmd.Run(); err != nil {
+            missing = append(missing, key)
+        }
+    }
+    return missing, nil
+}
+
+// makes sure the keys in the validpgpkeys of a package are in the user's keyring, asking before the missing
+// ones are imported from the configured keyserver or key directory
+func ensurePGPKeys(packageName, cloneDir string) error {
+    keys, err := requiredPGPKeys(cloneDir)
+    if err != nil {
+        return err
+    }
+    missing, err := missingPGPKeys(keys)
+    if err != nil {
+        return err
+    }
+    if len(missing) == 0 {
+        return nil
+    }
+
+    config, err := ReadConfig()
+    if err != nil {
+        logger.Warnf("unable to read the config, using the default keyserver: %v", err)
+    }
+    origin := config.PGPKeyserver
+    if config.PGPKeyDir != "" {
+        origin = config.PGPKeyDir
+    }
+
+    fmt.Printf("%s verifies its sources with PGP keys that are not in your keyring:\n", packageName)
+    for _, key := range missing {
+        fmt.Printf("    %s\n", formatPGPFingerprint(key))
+    }
+    if !ConfirmAction("Do you want to import these keys from " + origin + "?") {
+        logger.Warnf("user declined importing the PGP keys of %s", packageName)
+        return &PGPVerificationError{Package: packageName, MissingKeys: missing}
+    }
+
+    if err := importPGPKeys(config, missing); err != nil {
+        return err
+    }
+
+    // Keyservers can answer without returning a key, so check that everything arrived
+    stillMissing, err := missingPGPKeys(missing)
+    if err != nil {
+        return err
+    }
+    if len(stillMissing) > 0 {
+        logger.Errorf("the PGP keys %s of %s could not be imported", strings.Join(stillMissing, ", "), packageName)
+        return &PGPVerificationError{Package: packageName, MissingKeys: stillMissing}
+    }
+    logger.Infof("Imported the PGP keys %s for %s", strings.Join(missing, ", "), packageName)
+    return nil
+}
+
+// imports keys from the key directory if one is configured, otherwise from the keyserver
+func importPGPKeys(config Config, keys []string) error {
+    var command []string
+    if config.PGPKeyDir != "" {
+        var keyFiles []string
+        for _, key := range keys {
+            keyFile, err := findPGPKeyFile(config.PGPKeyDir, key)
+            if err != nil {
+                return err
+            }
+            keyFiles = append(keyFiles, keyFile)
+        }
+        command = gpgImportCommand(keyFiles...)
+    } else {
+        command = gpgReceiveKeysCommand(config.PGPKeyserver, keys...)
+    }
+
+    // makepkg verifies with the keyring of the build user, so that's where the keys go
+    cmd, err := newBuildCommand(command)
+    if err != nil {
+        return err
+    }
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error importing PGP keys: %s, %v", output, err)
+        return fmt.Errorf("error importing PGP keys: %s, %v", output, err)
+    }
+    return nil
+}
+
+// finds the file of a key in a key directory, named after its fingerprint or its long key ID
+func findPGPKeyFile(keyDir, key string) (string, error) {
+    names := []string{key}
+    if len(key) > 16 {
+        names = append(names, key[len(key)-16:])
+    }
+    for _, name := range names {
+        for _, extension := range pgpKeyFileExtensions {
+            keyFile := filepath.Join(keyDir, name+extension)
+            if _, err := os.Stat(keyFile); err == nil {
+                return keyFile, nil
+            }
+        }
+    }
+
+    logger.Errorf("the PGP key %s is not in %s", key, keyDir)
+    return "", fmt.Errorf("the PGP key %s is not in %s", key, keyDir)
+}
+
+// returns a PGPVerificationError if makepkg failed because it couldn't verify a signature, otherwise nil
+func makepkgPGPError(packageName string, output []byte) error {
+    text := string(output)
+    matches := makepkgUnknownKeyPattern.FindAllStringSubmatch(text, -1)
+    if len(matches) == 0 && !strings.Contains(text, "PGP signatures could not be verified") {
+        return nil
+    }
+
+    var missing []string
+    seen := make(map[string]bool)
+    for _, match := range matches {
+        if key := normalizePGPKey(match[1]); !seen[key] {
+            seen[key] = true
+            missing = append(missing, key)
+        }
+    }
+    return &PGPVerificationError{Package: packageName, MissingKeys: missing, Output: strings.TrimSpace(text)}
+}
+
+// uppercases a fingerprint and strips the spaces and 0x prefix it is sometimes written with
+func normalizePGPKey(key string) string {
+    key = strings.ReplaceAll(strings.TrimSpace(key), " ", "")
+    key = strings.TrimPrefix(strings.TrimPrefix(key, "0x"), "0X")
+    return strings.ToUpper(key)
+}
+
+// groups a fingerprint into blocks of four, the way gpg prints them
+func formatPGPFingerprint(key string) string {
+    var blocks []string
+    for len(key) > 4 {
+        blocks = append(blocks, key[:4])
+        key = key[4:]
+    }
+    return strings.Join(append(blocks, key), " ")
+}
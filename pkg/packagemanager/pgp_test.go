@@ -0,0 +1,178 @@
+package packagemanager
+
+import (
+    "errors"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestRequiredPGPKeys(t *testing.T) {
+    cloneDir := t.TempDir()
+    srcinfo := "pkgbase = foo\n" +
+        "\tpkgver = 1.0\n" +
+        "\tvalidpgpkeys = 0x9f2a 1b3c 4d5e 6f70 8192 a3b4 c5d6 e7f8 0912 3456\n" +
+        "\tvalidpgpkeys = 0123456789ABCDEF0123456789ABCDEF01234567\n" +
+        "\tvalidpgpkeys = 9F2A1B3C4D5E6F708192A3B4C5D6E7F809123456\n" +
+        "\npkgname = foo\n"
+    if err := os.WriteFile(filepath.Join(cloneDir, ".SRCINFO"), []byte(srcinfo), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    keys, err := requiredPGPKeys(cloneDir)
+    if err != nil {
+        t.Fatalf("requiredPGPKeys() error = %v", err)
+    }
+    want := []string{"0123456789ABCDEF0123456789ABCDEF01234567", "9F2A1B3C4D5E6F708192A3B4C5D6E7F809123456"}
+    if !reflect.DeepEqual(keys, want) {
+        t.Errorf("requiredPGPKeys() = %q, want %q", keys, want)
+    }
+}
+
+func TestRequiredPGPKeysWithoutKeys(t *testing.T) {
+    cloneDir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(cloneDir, ".SRCINFO"), []byte("pkgbase = foo\n\npkgname = foo\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    keys, err := requiredPGPKeys(cloneDir)
+    if err != nil {
+        t.Fatalf("requiredPGPKeys() error = %v", err)
+    }
+    if len(keys) != 0 {
+        t.Errorf("requiredPGPKeys() = %q, want no keys", keys)
+    }
+}
+
+func TestFindPGPKeyFile(t *testing.T) {
+    keyDir := t.TempDir()
+    fingerprint := "9F2A1B3C4D5E6F708192A3B4C5D6E7F809123456"
+    longKeyID := "0123456789ABCDEF0123456789ABCDEF01234567"
+    for _, name := range []string{fingerprint + ".asc", longKeyID[24:] + ".gpg", "unrelated.txt"} {
+        if err := os.WriteFile(filepath.Join(keyDir, name), []byte("key"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        key     string
+        want    string
+        wantErr bool
+    }{
+        {fingerprint, filepath.Join(keyDir, fingerprint+".asc"), false},
+        // Keys may be stored under their long key ID, the last 16 characters of the fingerprint
+        {longKeyID, filepath.Join(keyDir, "89ABCDEF01234567.gpg"), false},
+        {"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", "", true},
+    }
+
+    for _, test := range tests {
+        keyFile, err := findPGPKeyFile(keyDir, test.key)
+        if (err != nil) != test.wantErr {
+            t.Errorf("findPGPKeyFile(%q) error = %v, wantErr %v", test.key, err, test.wantErr)
+            continue
+        }
+        if keyFile != test.want {
+            t.Errorf("findPGPKeyFile(%q) = %q, want %q", test.key, keyFile, test.want)
+        }
+    }
+}
+
+func TestMakepkgPGPError(t *testing.T) {
+    tests := []struct {
+        name        string
+        output      string
+        wantErr     bool
+        wantMissing []string
+    }{
+        {
+            name: "unknown public keys",
+            output: "==> Verifying source file signatures with gpg...\n" +
+                "    foo-1.0.tar.gz ... FAILED (unknown public key 8192a3b4c5d6e7f8)\n" +
+                "    foo-1.0.patch ... FAILED (unknown public key 8192A3B4C5D6E7F8)\n" +
+                "    bar-1.0.tar.gz ... FAILED (unknown public key 0123456789ABCDEF)\n" +
+                "==> ERROR: One or more PGP signatures could not be verified!\n",
+            wantErr:     true,
+            wantMissing: []string{"8192A3B4C5D6E7F8", "0123456789ABCDEF"},
+        },
+        {
+            name: "bad signature",
+            output: "    foo-1.0.tar.gz ... FAILED (bad signature from public key 8192A3B4C5D6E7F8)\n" +
+                "==> ERROR: One or more PGP signatures could not be verified!\n",
+            wantErr: true,
+        },
+        {
+            name:   "unrelated failure",
+            output: "==> ERROR: A failure occurred in build().\n    Aborting...\n",
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            err := makepkgPGPError("foo", []byte(test.output))
+            if !test.wantErr {
+                if err != nil {
+                    t.Errorf("makepkgPGPError() = %v, want nil", err)
+                }
+                return
+            }
+
+            var pgpErr *PGPVerificationError
+            if !errors.As(err, &pgpErr) {
+                t.Fatalf("makepkgPGPError() = %v, want a PGPVerificationError", err)
+            }
+            if pgpErr.Package != "foo" || !reflect.DeepEqual(pgpErr.MissingKeys, test.wantMissing) {
+                t.Errorf("makepkgPGPError() = %+v, want missing keys %q", pgpErr, test.wantMissing)
+            }
+            if pgpErr.Output != strings.TrimSpace(test.output) {
+                t.Errorf("makepkgPGPError() output = %q", pgpErr.Output)
+            }
+        })
+    }
+}
+
+func TestMissingPGPKeys(t *testing.T) {
+    if runningAsRoot() {
+        t.Skip("gpg runs as the build user when the tests run as root")
+    }
+    if _, err := exec.LookPath("gpg"); err != nil {
+        t.Skip("gpg is not installed")
+    }
+
+    // A keyring of its own, so the user's keyring isn't touched
+    gnupgHome := t.TempDir()
+    t.Setenv("GNUPGHOME", gnupgHome)
+    t.Cleanup(func() {
+        exec.Command("gpgconf", "--kill", "all").Run()
+    })
+
+    generate := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "AllPac Test <test@allpac.invalid>", "default", "default", "never")
+    if output, err := generate.CombinedOutput(); err != nil {
+        t.Skipf("unable to generate a test key: %s, %v", output, err)
+    }
+    output, err := exec.Command("gpg", "--batch", "--list-keys", "--with-colons").Output()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var fingerprint string
+    for _, line := range strings.Split(string(output), "\n") {
+        if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+            fingerprint = fields[9]
+            break
+        }
+    }
+    if fingerprint == "" {
+        t.Fatalf("no fingerprint in the gpg output: %s", output)
+    }
+
+    absent := "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"
+    missing, err := missingPGPKeys([]string{fingerprint, absent})
+    if err != nil {
+        t.Fatalf("missingPGPKeys() error = %v", err)
+    }
+    if !reflect.DeepEqual(missing, []string{absent}) {
+        t.Errorf("missingPGPKeys() = %q, want %q", missing, []string{absent})
+    }
+}
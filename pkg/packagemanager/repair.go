@@ -0,0 +1,193 @@
+package packagemanager
+
+// This file is responsible for repairing the package list. Instead of starting over with an empty list,
+// we salvage what we can from the existing file and the history log, then check it against the system
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "reflect"
+    "regexp"
+    "sort"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// matches a single `"name": {...}` entry of the package list, used to salvage entries from a corrupt file
+var pkgListEntryPattern = regexp.MustCompile(`"((?:[^"\\]|\\.)+)"\s*:\s*(\{[^{}]*\})`)
+
+// RepairPlan describes how the package list will look after a repair
+type RepairPlan struct {
+    BackupPath string
+    Corrupt    bool
+    Current    PackageList
+    Repaired   PackageList
+    Changes    []PackageListChange
+}
+
+// PackageListChange represents a single entry that differs between two package lists.
+// Old is nil for added entries and New is nil for removed entries
+type PackageListChange struct {
+    Name string
+    Old  *PackageInfo
+    New  *PackageInfo
+}
+
+// backs up the package list and works out the repaired list, without writing it
+func PlanPackageListRepair() (*RepairPlan, error) {
+    pkgListPath, err := GetPkgListPath()
+    if err != nil {
+        return nil, err
+    }
+
+    backupPath, err := BackupPackageList()
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := os.ReadFile(pkgListPath)
+    if err != nil {
+        logger.Errorf("error reading package list file: %v", err)
+        return nil, fmt.Errorf("error reading package list file: %v", err)
+    }
+
+    current, corrupt := salvagePackageList(data)
+
+    history, err := ReadHistory()
+    if err != nil {
+        return nil, err
+    }
+
+    installed, err := ListSystemPackages(false)
+    if err != nil {
+        return nil, err
+    }
+
+    repaired := reconstructPackageList(current, history, installed)
+
+    return &RepairPlan{
+        BackupPath: backupPath,
+        Corrupt:    corrupt,
+        Current:    current,
+        Repaired:   repaired,
+        Changes:    DiffPackageLists(current, repaired),
+    }, nil
+}
+
+// writes the repaired package list from the given plan
+func ApplyPackageListRepair(plan *RepairPlan) error {
+    if err := writePackageList(plan.Repaired); err != nil {
+        return err
+    }
+
+    for _, change := range plan.Changes {
+        if change.New == nil {
+            appendHistory("remove", change.Name, change.Old.Source, change.Old.Version)
+        } else {
+            appendHistory("repair", change.Name, change.New.Source, change.New.Version)
+        }
+    }
+    return nil
+}
+
+// parses the package list, falling back to salvaging every readable entry when the JSON is corrupt.
+// the second return value reports whether the file was corrupt
+func salvagePackageList(data []byte) (PackageList, bool) {
+    var pkgList PackageList
+    if err := json.Unmarshal(data, &pkgList); err == nil {
+        if pkgList == nil {
+            pkgList = PackageList{}
+        }
+        return pkgList, false
+    }
+
+    logger.Warnf("package list is corrupt, salvaging readable entries")
+    pkgList = PackageList{}
+    for _, match := range pkgListEntryPattern.FindAllSubmatch(data, -1) {
+        var name string
+        if err := json.Unmarshal([]byte(`"`+string(match[1])+`"`), &name); err != nil {
+            continue
+        }
+
+        var pkgInfo PackageInfo
+        if err := json.Unmarshal(match[2], &pkgInfo); err != nil || pkgInfo.Source == "" {
+            continue
+        }
+        pkgList[name] = pkgInfo
+    }
+
+    logger.Infof("Salvaged %d entries from the corrupt package list", len(pkgList))
+    return pkgList, true
+}
+
+// rebuilds the package list from the salvaged entries and the history log, keeping only packages
+// that are still installed and taking their source and version from the system
+func reconstructPackageList(salvaged PackageList, history []HistoryEntry, installed []SystemPackage) PackageList {
+    known := make(PackageList)
+    for name, pkgInfo := range salvaged {
+        known[name] = pkgInfo
+    }
+
+    // Replay the history, so packages lost from the file are brought back and removed ones stay removed
+    for _, entry := range history {
+        if _, isSalvaged := salvaged[entry.Name]; isSalvaged {
+            continue
+        }
+        if entry.Action == "remove" {
+            delete(known, entry.Name)
+        } else {
+            known[entry.Name] = PackageInfo{Source: entry.Source, Version: entry.Version}
+        }
+    }
+
+    installedByName := make(map[string][]SystemPackage)
+    for _, pkg := range installed {
+        installedByName[pkg.Name] = append(installedByName[pkg.Name], pkg)
+    }
+
+    repaired := make(PackageList)
+    for name, pkgInfo := range known {
+        candidates := installedByName[name]
+        if len(candidates) == 0 {
+            logger.Infof("Package %s is no longer installed, dropping it from the package list", name)
+            continue
+        }
+
+        match := candidates[0]
+        for _, candidate := range candidates {
+            if candidate.Source == pkgInfo.Source {
+                match = candidate
+                break
+            }
+        }
+
+        pkgInfo.Source = match.Source
+        pkgInfo.Version = match.Version
+        repaired[name] = pkgInfo
+    }
+    return repaired
+}
+
+// lists the entries that differ between two package lists, sorted by name
+func DiffPackageLists(oldList, newList PackageList) []PackageListChange {
+    var changes []PackageListChange
+    for name, oldInfo := range oldList {
+        oldInfo := oldInfo
+        newInfo, exists := newList[name]
+        if !exists {
+            changes = append(changes, PackageListChange{Name: name, Old: &oldInfo})
+        } else if !reflect.DeepEqual(oldInfo, newInfo) {
+            newInfo := newInfo
+            changes = append(changes, PackageListChange{Name: name, Old: &oldInfo, New: &newInfo})
+        }
+    }
+    for name, newInfo := range newList {
+        newInfo := newInfo
+        if _, exists := oldList[name]; !exists {
+            changes = append(changes, PackageListChange{Name: name, New: &newInfo})
+        }
+    }
+
+    sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+    return changes
+}
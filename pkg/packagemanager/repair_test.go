@@ -0,0 +1,61 @@
+package packagemanager
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestSalvagePackageList(t *testing.T) {
+    tests := []struct {
+        name        string
+        data        string
+        wantList    PackageList
+        wantCorrupt bool
+    }{
+        {
+            name:     "valid",
+            data:     `{"git": {"source": "pacman", "version": "2.43.0-1"}}`,
+            wantList: PackageList{"git": {Source: "pacman", Version: "2.43.0-1"}},
+        },
+        {
+            name:     "empty object",
+            data:     `{}`,
+            wantList: PackageList{},
+        },
+        {
+            name:     "null",
+            data:     `null`,
+            wantList: PackageList{},
+        },
+        {
+            name:        "truncated",
+            data:        `{"git": {"source": "pacman", "version": "2.43.0-1"}, "yay": {"source": "aur", "vers`,
+            wantList:    PackageList{"git": {Source: "pacman", Version: "2.43.0-1"}},
+            wantCorrupt: true,
+        },
+        {
+            name:        "entry without a source",
+            data:        `{"git": {"version": "2.43.0-1"}, "neovim": {"source": "pacman", "version": "0.9.5-1"},,}`,
+            wantList:    PackageList{"neovim": {Source: "pacman", Version: "0.9.5-1"}},
+            wantCorrupt: true,
+        },
+        {
+            name:        "garbage",
+            data:        "\x00\x01not json",
+            wantList:    PackageList{},
+            wantCorrupt: true,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            pkgList, corrupt := salvagePackageList([]byte(test.data))
+            if corrupt != test.wantCorrupt {
+                t.Errorf("corrupt = %v, want %v", corrupt, test.wantCorrupt)
+            }
+            if !reflect.DeepEqual(pkgList, test.wantList) {
+                t.Errorf("salvaged %v, want %v", pkgList, test.wantList)
+            }
+        })
+    }
+}
@@ -0,0 +1,166 @@
+package packagemanager
+
+// This file is responsible for resolving the names users ask to install into concrete packages.
+// Like pacman itself, a name that isn't a package may still be satisfied by the packages that provide it
+// (e.g. java-runtime or sh), or stand for a pacman group whose members are installed instead
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "pixelridgesoftworks.com/AllPac/pkg/alpmdb"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// how an install candidate matched the requested name
+const (
+    MatchPackage  = "package"
+    MatchProvides = "provides"
+    MatchGroup    = "group"
+)
+
+// InstallCandidate represents a concrete package (or pacman group) that satisfies a requested name
+type InstallCandidate struct {
+    // the package to install, or the group name for group candidates
+    Name        string
+    Source      string
+    Version     string
+    Description string
+    // MatchPackage, MatchProvides or MatchGroup
+    Match string
+    // the packages a group candidate installs
+    Members []string
+}
+
+// resolves a name against the pacman sync databases. An exact package wins, otherwise every package
+// providing the name is a candidate, and a group of that name is one more candidate
+func ResolvePacmanCandidates(name string) ([]InstallCandidate, error) {
+    databases, err := getAlpmHandle().SyncDatabases()
+    if err != nil {
+        // Without the databases we can only tell if the name is a package
+        logger.Warnf("unable to read the sync databases, only looking for a package named %s: %v", name, err)
+        version, err := GetPacmanLatestVersion(name)
+        if err != nil {
+            return nil, nil
+        }
+        return []InstallCandidate{{Name: name, Source: "pacman", Version: version, Match: MatchPackage}}, nil
+    }
+
+    // The first repository that has the package wins, just like it does for pacman
+    for _, db := range databases {
+        if pkg := db.Package(name); pkg != nil {
+            return []InstallCandidate{pacmanCandidate(pkg, MatchPackage)}, nil
+        }
+    }
+
+    var candidates []InstallCandidate
+    seen := make(map[string]bool)
+    for _, db := range databases {
+        for _, pkg := range db.Providers(name) {
+            if !seen[pkg.Name] {
+                seen[pkg.Name] = true
+                candidates = append(candidates, pacmanCandidate(pkg, MatchProvides))
+            }
+        }
+    }
+
+    var members []string
+    seenMembers := make(map[string]bool)
+    for _, db := range databases {
+        for _, pkg := range db.Group(name) {
+            if !seenMembers[pkg.Name] {
+                seenMembers[pkg.Name] = true
+                members = append(members, pkg.Name)
+            }
+        }
+    }
+    if len(members) > 0 {
+        sort.Strings(members)
+        candidates = append(candidates, InstallCandidate{
+            Name:        name,
+            Source:      "pacman",
+            Description: fmt.Sprintf("group of %d packages", len(members)),
+            Match:       MatchGroup,
+            Members:     members,
+        })
+    }
+    return candidates, nil
+}
+
+func pacmanCandidate(pkg *alpmdb.Package, match string) InstallCandidate {
+    return InstallCandidate{
+        Name:        pkg.Name,
+        Source:      "pacman",
+        Version:     pkg.Version,
+        Description: pkg.Repository + ": " + pkg.Description,
+        Match:       match,
+    }
+}
+
+// resolves a name against the AUR. An exact package wins, otherwise every AUR package providing the name is a candidate
+func ResolveAURCandidates(name string) ([]InstallCandidate, error) {
+    // Searching by provides also returns the package with the exact name
+    requestURL := "https://aur.archlinux.org/rpc/?v=5&type=search&by=provides&arg=" + url.QueryEscape(name)
+    resp, err := http.Get(requestURL)
+    if err != nil {
+        logger.Errorf("error making request to AUR: %v", err)
+        return nil, fmt.Errorf("error making request to AUR: %v", err)
+    }
+    defer resp.Body.Close()
+
+    var aurResponse AURResponse
+    if err := json.NewDecoder(resp.Body).Decode(&aurResponse); err != nil {
+        logger.Errorf("error decoding AUR response: %v", err)
+        return nil, fmt.Errorf("error decoding AUR response: %v", err)
+    }
+
+    var candidates []InstallCandidate
+    for _, result := range aurResponse.Results {
+        candidate := InstallCandidate{
+            Name:        result.Name,
+            Source:      "aur",
+            Version:     result.Version,
+            Description: result.Description,
+            Match:       MatchProvides,
+        }
+        if result.Name == name {
+            candidate.Match = MatchPackage
+            return []InstallCandidate{candidate}, nil
+        }
+        candidates = append(candidates, candidate)
+    }
+
+    sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+    return candidates, nil
+}
+
+// installs the members of a pacman group and records each of them, since the group itself isn't a package
+func InstallPacmanGroup(candidate InstallCandidate) error {
+    if candidate.Match != MatchGroup || len(candidate.Members) == 0 {
+        return fmt.Errorf("%s is not a pacman group", candidate.Name)
+    }
+
+    if _, err := ensureSystemUpgraded(); err != nil {
+        return err
+    }
+
+    cmd := newCommand(pacmanInstallCommand(candidate.Members...))
+    if output, err := cmd.CombinedOutput(); err != nil {
+        logger.Errorf("error installing group %s with Pacman: %s, %v", candidate.Name, output, err)
+        return fmt.Errorf("error installing group %s with Pacman: %s, %v", candidate.Name, output, err)
+    }
+
+    for _, member := range candidate.Members {
+        version, err := GetPacmanInstalledVersion(member)
+        if err != nil {
+            return err
+        }
+        if err := LogInstallation(member, "pacman", version); err != nil {
+            logger.Errorf("error logging installation: %v", err)
+            return fmt.Errorf("error logging installation: %v", err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,225 @@
+package packagemanager
+
+// This file is responsible for reviewing the build files of AUR packages before they are built.
+// A PKGBUILD is a shell script that runs with the user's privileges, so new packages have their PKGBUILD
+// and every other file of their repository shown, and updates show what changed since the commit that was last built
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the review policies for AUR packages
+const (
+    // review first installs and updates
+    ReviewAlways = "always"
+    // only review packages that aren't installed yet
+    ReviewNewOnly = "new-only"
+    // never review, trusting everything in the AUR
+    ReviewNever = "never"
+)
+
+// the pager used when $PAGER isn't set
+const defaultPager = "less"
+
+// marks an AUR package as trusted, so its builds are never reviewed
+func TrustPackage(packageName string) error {
+    if err := setPackageTrust(packageName, true); err != nil {
+        return err
+    }
+    logger.Infof("Package %s is now trusted", packageName)
+    return nil
+}
+
+// removes the trust from an AUR package, so its builds are reviewed again
+func UntrustPackage(packageName string) error {
+    if err := setPackageTrust(packageName, false); err != nil {
+        return err
+    }
+    logger.Infof("Package %s is no longer trusted", packageName)
+    return nil
+}
+
+// sets whether an AUR package is trusted. Only AUR builds are reviewed, so other packages are rejected
+func setPackageTrust(packageName string, trusted bool) error {
+    pkgList, err := readPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+    if pkgInfo, found := pkgList[packageName]; !found || pkgInfo.Source != "aur" {
+        logger.Errorf("package %s is not found or not an AUR package", packageName)
+        return fmt.Errorf("package %s is not found or not an AUR package", packageName)
+    }
+    return updatePackageInfo(packageName, func(pkgInfo *PackageInfo) { pkgInfo.Trusted = trusted })
+}
+
+// shows the build files of an AUR package for review, following the configured policy, and asks whether
+// to build it. Returns an error if the user rejects the build
+func reviewAURBuildFiles(packageName, cloneDir string) error {
+    config, err := ReadConfig()
+    if err != nil {
+        logger.Warnf("unable to read the config, reviewing %s: %v", packageName, err)
+    }
+
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return fmt.Errorf("error reading package list: %v", err)
+    }
+    pkgInfo, installed := pkgList[packageName]
+    installed = installed && pkgInfo.Source == "aur"
+
+    if installed && pkgInfo.Trusted {
+        logger.Infof("Package %s is trusted, skipping the review", packageName)
+        return nil
+    }
+
+    switch config.AURReview {
+    case ReviewNever:
+        return nil
+    case ReviewNewOnly:
+        if installed {
+            return nil
+        }
+    case ReviewAlways:
+    default:
+        logger.Warnf("unknown AUR review policy %q, reviewing %s", config.AURReview, packageName)
+    }
+
+    head, err := gitHeadCommit(cloneDir)
+    if err != nil {
+        return err
+    }
+
+    // Updates only need a look at what changed since the commit we built last time
+    if installed && pkgInfo.Commit != "" {
+        if pkgInfo.Commit == head {
+            logger.Infof("The build files of %s didn't change since the last build, skipping the review", packageName)
+            return nil
+        }
+
+        diff, err := gitDiff(cloneDir, pkgInfo.Commit, head)
+        if err == nil {
+            fmt.Printf("Showing the changes to %s since the last build (%s..%s)\n", packageName, shortCommit(pkgInfo.Commit), shortCommit(head))
+            if err := runPager(bytes.NewReader(diff)); err != nil {
+                return err
+            }
+            return confirmReviewedBuild(packageName)
+        }
+        logger.Warnf("unable to diff %s against the last built commit, showing the full build files instead: %v", packageName, err)
+    }
+
+    files, err := aurBuildFiles(cloneDir)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Showing the build files of %s: %s\n", packageName, strings.Join(relativePaths(cloneDir, files), ", "))
+    if err := runPager(nil, files...); err != nil {
+        return err
+    }
+    return confirmReviewedBuild(packageName)
+}
+
+// asks whether to go on with a build after its review
+func confirmReviewedBuild(packageName string) error {
+    if !ConfirmAction("Do you want to build " + packageName + " after reviewing it?") {
+        logger.Warnf("user rejected the build of %s after reviewing it", packageName)
+        return fmt.Errorf("user rejected the build of %s after reviewing it", packageName)
+    }
+    return nil
+}
+
+// returns every file tracked in a cloned AUR repository, PKGBUILD first. Patches, install scripts and anything
+// else the PKGBUILD may use are included, only the generated .SRCINFO is left out
+func aurBuildFiles(cloneDir string) ([]string, error) {
+    pkgbuild := filepath.Join(cloneDir, "PKGBUILD")
+    if _, err := os.Stat(pkgbuild); err != nil {
+        logger.Errorf("error reading PKGBUILD: %v", err)
+        return nil, fmt.Errorf("error reading PKGBUILD: %v", err)
+    }
+
+    // git refuses to work in repositories owned by another user, so it runs as the owner of the clone
+    cmd, err := newBuildCommand(gitLsFilesCommand())
+    if err != nil {
+        return nil, err
+    }
+    cmd.Dir = cloneDir
+    output, err := cmd.Output()
+    if err != nil {
+        logger.Errorf("error listing the files of %s: %v", cloneDir, err)
+        return nil, fmt.Errorf("error listing the files of %s: %v", cloneDir, err)
+    }
+
+    files := []string{pkgbuild}
+    for _, name := range strings.Split(string(output), "\x00") {
+        if name == "" || name == "PKGBUILD" || name == ".SRCINFO" {
+            continue
+        }
+        files = append(files, filepath.Join(cloneDir, name))
+    }
+    return files, nil
+}
+
+// returns the diff between two commits of a git repository
+func gitDiff(repoDir, fromCommit, toCommit string) ([]byte, error) {
+    // git refuses to work in repositories owned by another user, so it runs as the owner of the clone
+    cmd, err := newBuildCommand([]string{"git", "diff", "--stat", "--patch", fromCommit, toCommit})
+    if err != nil {
+        return nil, err
+    }
+    cmd.Dir = repoDir
+    output, err := cmd.CombinedOutput()
+    if err != nil {
+        return nil, fmt.Errorf("%s, %v", strings.TrimSpace(string(output)), err)
+    }
+    return output, nil
+}
+
+// shows the given files, or the given content when there are no files, in the user's pager
+func runPager(content io.Reader, files ...string) error {
+    pager := strings.Fields(os.Getenv("PAGER"))
+    if len(pager) == 0 {
+        pager = []string{defaultPager}
+    }
+
+    cmd := exec.Command(pager[0], append(pager[1:], files...)...)
+    cmd.Stdin = os.Stdin
+    if content != nil {
+        cmd.Stdin = content
+    }
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    if err := cmd.Run(); err != nil {
+        logger.Errorf("error running pager %s: %v", pager[0], err)
+        return fmt.Errorf("error running pager %s: %v", pager[0], err)
+    }
+    return nil
+}
+
+// shortens a commit hash for display
+func shortCommit(commit string) string {
+    if len(commit) > 12 {
+        return commit[:12]
+    }
+    return commit
+}
+
+// returns the paths relative to the given directory, for display
+func relativePaths(dir string, paths []string) []string {
+    relative := make([]string, len(paths))
+    for i, path := range paths {
+        if rel, err := filepath.Rel(dir, path); err == nil {
+            relative[i] = rel
+        } else {
+            relative[i] = path
+        }
+    }
+    return relative
+}
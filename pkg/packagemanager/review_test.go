@@ -0,0 +1,68 @@
+package packagemanager
+
+import (
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestTrustPackage(t *testing.T) {
+    pkgList := PackageList{
+        "yay":     {Source: "aur", Version: "12.3.5-1"},
+        "firefox": {Source: "pacman", Version: "128.0-1"},
+    }
+    if err := writePackageList(pkgList); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { writePackageList(PackageList{}) })
+
+    if err := TrustPackage("yay"); err != nil {
+        t.Fatalf("TrustPackage(yay) error = %v", err)
+    }
+    for _, name := range []string{"firefox", "missing"} {
+        if err := TrustPackage(name); err == nil {
+            t.Errorf("TrustPackage(%s) succeeded, want an error", name)
+        }
+    }
+
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !pkgList["yay"].Trusted || pkgList["firefox"].Trusted {
+        t.Errorf("trusted yay = %v, firefox = %v, want true, false", pkgList["yay"].Trusted, pkgList["firefox"].Trusted)
+    }
+}
+
+func TestAURBuildFiles(t *testing.T) {
+    if runningAsRoot() {
+        t.Skip("git runs as the build user when the tests run as root")
+    }
+    if _, err := exec.LookPath("git"); err != nil {
+        t.Skip("git is not installed")
+    }
+
+    cloneDir := t.TempDir()
+    for _, name := range []string{"PKGBUILD", ".SRCINFO", "foo.install", "fix-build.patch", "foo.sysusers", "untracked.txt"} {
+        if err := os.WriteFile(filepath.Join(cloneDir, name), []byte(name+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    git := exec.Command("sh", "-c", "git init --quiet && git add PKGBUILD .SRCINFO foo.install fix-build.patch foo.sysusers")
+    git.Dir = cloneDir
+    if output, err := git.CombinedOutput(); err != nil {
+        t.Fatalf("git: %s, %v", output, err)
+    }
+
+    files, err := aurBuildFiles(cloneDir)
+    if err != nil {
+        t.Fatalf("aurBuildFiles() error = %v", err)
+    }
+    want := []string{"PKGBUILD", "fix-build.patch", "foo.install", "foo.sysusers"}
+    if got := relativePaths(cloneDir, files); !reflect.DeepEqual(got, want) {
+        t.Errorf("aurBuildFiles() = %s, want %s", strings.Join(got, ", "), strings.Join(want, ", "))
+    }
+}
@@ -6,7 +6,6 @@ import (
     "encoding/json"
     "io/ioutil"
     "fmt"
-    "os/user"
 	"os/exec"
     "path/filepath"
 	"strings"
@@ -30,22 +29,13 @@ func UninstallPackages(packageNames []string) error {
             continue
         }
 
-        switch pkgInfo.Source {
-        case "pacman":
-            err = UninstallPacmanPackage(packageName)
-        case "snap":
-            err = UninstallSnapPackage(packageName)
-        case "flatpak":
-            err = UninstallFlatpakPackage(packageName)
-        case "aur":
-            err = UninstallAURPackage(packageName)
-        default:
+        if !isKnownSource(pkgInfo.Source) {
             logger.Warnf("Unknown source for package %s\n", packageNames)
             fmt.Printf("Unknown source for package %s\n", packageName)
             continue
         }
 
-        if err != nil {
+        if err = uninstallPackageFromSource(packageName, pkgInfo.Source); err != nil {
             logger.Warnf("Error uninstalling package %s: %v\n", packageName, err)
             fmt.Printf("Error uninstalling package %s: %v\n", packageName, err)
         } else {
@@ -57,14 +47,39 @@ func UninstallPackages(packageNames []string) error {
     return nil
 }
 
+// uninstalls a package using the uninstaller for its source
+func uninstallPackageFromSource(packageName, source string) error {
+    switch source {
+    case "pacman":
+        return UninstallPacmanPackage(packageName)
+    case "snap":
+        return UninstallSnapPackage(packageName)
+    case "flatpak":
+        return UninstallFlatpakPackage(packageName)
+    case "aur":
+        return UninstallAURPackage(packageName)
+    default:
+        logger.Errorf("unknown source %s for package %s", source, packageName)
+        return fmt.Errorf("unknown source %s for package %s", source, packageName)
+    }
+}
+
+// checks if AllPac knows how to handle packages from the given source
+func isKnownSource(source string) bool {
+    switch source {
+    case "pacman", "aur", "snap", "flatpak":
+        return true
+    }
+    return false
+}
+
 // reads the package list from the pkg.list file
 func readPackageList() (PackageList, error) {
-    usr, err := user.Current()
+    home, err := currentHomeDir()
     if err != nil {
-        logger.Errorf("error getting current user: %v", err)
-        return nil, fmt.Errorf("error getting current user: %v", err)
+        return nil, err
     }
-    pkgListPath := filepath.Join(usr.HomeDir, ".allpac", "pkg.list")
+    pkgListPath := filepath.Join(home, ".allpac", "pkg.list")
 
     file, err := ioutil.ReadFile(pkgListPath)
     if err != nil {
@@ -184,30 +199,7 @@ func parsePacmanOutput(output string) []string {
 
 // returns the version of a package in the Pacman repositories
 func GetPacmanPackageVersion(packageName string) (string, error) {
-    searchResults, err := SearchPacman(packageName)
-    if err != nil {
-        logger.Errorf("An error has occured:", err)
-        return "", err
-    }
-
-    for _, result := range searchResults {
-        if strings.Contains(result, packageName) {
-            return extractVersionFromPacmanResult(result), nil
-        }
-    }
-
-    logger.Errorf("package %s not found in Pacman", packageName)
-    return "", fmt.Errorf("package %s not found in Pacman", packageName)
-}
-
-// extracts the version from a Pacman search result string
-func extractVersionFromPacmanResult(result string) string {
-    // Assuming the result is in the format "packageName version description"
-    parts := strings.Fields(result)
-    if len(parts) >= 2 {
-        return parts[1]
-    }
-    return ""
+    return GetPacmanLatestVersion(packageName)
 }
 
 // fetches package information from the AUR
@@ -27,14 +27,17 @@ func UpdateSnapPackages(packageNames ...string) error {
         }
     }
 
-    var cmd *exec.Cmd
-    if len(packageNames) == 0 {
-        cmd = exec.Command("sudo", "snap", "refresh")
-    } else {
-        args := append([]string{"snap", "refresh"}, packageNames...)
-        cmd = exec.Command("sudo", args...)
+    // Refreshing with no names would refresh everything, held snaps included, so stop here if all of them are held
+    if len(packageNames) > 0 {
+        packageNames = filterHeldPackages(pkgList, packageNames)
+        if len(packageNames) == 0 {
+            logger.Info("No Snap packages need updating")
+            return nil
+        }
     }
 
+    cmd := newCommand(snapRefreshCommand(packageNames...))
+
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error updating Snap packages: %s, %v", string(output), err)
         return fmt.Errorf("error updating Snap packages: %s, %v", string(output), err)
@@ -72,7 +75,7 @@ func UninstallSnapPackage(packageName string) error {
     }
 
     // Uninstalling the Snap package
-    cmd := exec.Command("sudo", "snap", "remove", packageName)
+    cmd := newCommand(snapRemoveCommand(packageName))
     if output, err := cmd.CombinedOutput(); err != nil {
         logger.Errorf("error uninstalling Snap package: %s, %v", string(output), err)
         return fmt.Errorf("error uninstalling Snap package: %s, %v", string(output), err)
@@ -0,0 +1,159 @@
+package packagemanager
+
+// This file is responsible for syncing the system with a manifest. We diff the manifest against the
+// package list, then install, replace and optionally remove packages until both agree
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// SyncAction describes what a sync step does to a package
+type SyncAction string
+
+const (
+    // the package is in the manifest but not managed by AllPac yet
+    SyncInstall SyncAction = "install"
+    // the package is managed by AllPac, but from a different source than the manifest says
+    SyncReplace SyncAction = "replace"
+    // the package is installed, but not at the version pinned in the manifest
+    SyncPin SyncAction = "pin"
+    // the package is managed by AllPac but not in the manifest, only planned when pruning
+    SyncRemove SyncAction = "remove"
+)
+
+// SyncStep represents a single change needed to bring the system in line with the manifest
+type SyncStep struct {
+    Action  SyncAction
+    Source  string
+    Package ManifestPackage
+    // the current package list entry, nil when the package isn't managed yet
+    Current *PackageInfo
+}
+
+// SyncPlan represents every change needed to bring the system in line with the manifest
+type SyncPlan struct {
+    Steps     []SyncStep
+    Unchanged []string
+}
+
+// works out the steps needed to converge the package list with the manifest.
+// packages that are managed by AllPac but not in the manifest are only removed when prune is set
+func PlanSync(manifest *Manifest, prune bool) (*SyncPlan, error) {
+    pkgList, err := ReadPackageList()
+    if err != nil {
+        logger.Errorf("error reading package list: %v", err)
+        return nil, fmt.Errorf("error reading package list: %v", err)
+    }
+
+    plan := &SyncPlan{}
+    declared := make(map[string]bool)
+    for _, entry := range manifest.Entries() {
+        name := entry.Package.Name
+        declared[name] = true
+
+        pkgInfo, exists := pkgList[name]
+        switch {
+        case !exists:
+            plan.Steps = append(plan.Steps, SyncStep{Action: SyncInstall, Source: entry.Source, Package: entry.Package})
+        case pkgInfo.Source != entry.Source:
+            plan.Steps = append(plan.Steps, SyncStep{Action: SyncReplace, Source: entry.Source, Package: entry.Package, Current: &pkgInfo})
+        case entry.Package.Version != "" && pkgInfo.Version != entry.Package.Version:
+            plan.Steps = append(plan.Steps, SyncStep{Action: SyncPin, Source: entry.Source, Package: entry.Package, Current: &pkgInfo})
+        default:
+            plan.Unchanged = append(plan.Unchanged, name)
+        }
+    }
+
+    if prune {
+        var undeclared []string
+        for name := range pkgList {
+            if !declared[name] {
+                undeclared = append(undeclared, name)
+            }
+        }
+        sort.Strings(undeclared)
+
+        for _, name := range undeclared {
+            pkgInfo := pkgList[name]
+            plan.Steps = append(plan.Steps, SyncStep{Action: SyncRemove, Source: pkgInfo.Source, Package: ManifestPackage{Name: name}, Current: &pkgInfo})
+        }
+    }
+
+    sort.Strings(plan.Unchanged)
+    return plan, nil
+}
+
+// applies every step of the plan, carrying on past failures so one broken package
+// doesn't stop the rest of the machine from converging
+func ApplySync(plan *SyncPlan, skipConfirmation bool) error {
+    var failures []string
+    for _, step := range plan.Steps {
+        if err := applySyncStep(step, skipConfirmation); err != nil {
+            logger.Errorf("error applying sync step %s %s: %v", step.Action, step.Package.Name, err)
+            fmt.Printf("Error during %s of %s: %v\n", step.Action, step.Package.Name, err)
+            failures = append(failures, fmt.Sprintf("%s (%s)", step.Package.Name, step.Action))
+            continue
+        }
+        fmt.Printf("Completed %s of %s from %s.\n", step.Action, step.Package.Name, step.Source)
+    }
+
+    if len(failures) > 0 {
+        return fmt.Errorf("%d of %d sync steps failed: %s", len(failures), len(plan.Steps), strings.Join(failures, ", "))
+    }
+    return nil
+}
+
+// applies a single sync step
+func applySyncStep(step SyncStep, skipConfirmation bool) error {
+    switch step.Action {
+    case SyncInstall, SyncPin:
+        return installManifestPackage(step.Source, step.Package, skipConfirmation)
+    case SyncReplace:
+        if err := uninstallPackageFromSource(step.Package.Name, step.Current.Source); err != nil {
+            return err
+        }
+        return installManifestPackage(step.Source, step.Package, skipConfirmation)
+    case SyncRemove:
+        return uninstallPackageFromSource(step.Package.Name, step.Source)
+    default:
+        return fmt.Errorf("unknown sync action %s", step.Action)
+    }
+}
+
+// installs a manifest package from the given source, honouring its options and version pin
+func installManifestPackage(source string, pkg ManifestPackage, skipConfirmation bool) error {
+    switch source {
+    case "pacman":
+        if pkg.Version != "" {
+            latestVersion, err := GetPacmanPackageVersion(pkg.Name)
+            if err != nil {
+                return err
+            }
+            if latestVersion != pkg.Version {
+                return fmt.Errorf("pinned version %s of %s is not available in the repositories, the current version is %s", pkg.Version, pkg.Name, latestVersion)
+            }
+        }
+        return InstallPackagePacman(pkg.Name)
+    case "aur":
+        if pkg.Version != "" {
+            aurVersion, err := GetAURPackageVersion(pkg.Name)
+            if err != nil {
+                return err
+            }
+            if aurVersion != pkg.Version {
+                return fmt.Errorf("pinned version %s of %s is not available in the AUR, the current version is %s", pkg.Version, pkg.Name, aurVersion)
+            }
+        }
+        _, err := CloneAndInstallFromAUR(aurRepoURL(pkg.Name), skipConfirmation)
+        return err
+    case "flatpak":
+        return InstallPackageFlatpakFromRemote(pkg.Remote, pkg.Name)
+    case "snap":
+        return InstallPackageSnapWithOptions(pkg.Name, pkg.Channel, pkg.Classic)
+    default:
+        return fmt.Errorf("unknown source %s for package %s", source, pkg.Name)
+    }
+}
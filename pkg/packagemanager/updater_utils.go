@@ -4,8 +4,9 @@ import (
 	"fmt"
 )
 
-// UpdatePackageByName updates a specific package by its name
-func UpdatePackageByName(packageName string) error {
+// UpdatePackageByName updates a specific package by its name. With devel, a VCS package is also
+// rebuilt when its upstream moved since it was built
+func UpdatePackageByName(packageName string, devel bool) error {
     pkgList, err := ReadPackageList()
     if err != nil {
         return fmt.Errorf("error reading package list: %v", err)
@@ -16,11 +17,15 @@ func UpdatePackageByName(packageName string) error {
         return fmt.Errorf("package %s not found in package list", packageName)
     }
 
+    if err := checkPackageNotHeld(pkgList, packageName); err != nil {
+        return err
+    }
+
     switch pkgInfo.Source {
     case "pacman":
         return UpdatePacmanPackages(packageName)
     case "aur":
-        return UpdateAURPackages(packageName)
+        return updateAURPackages(devel, []string{packageName})
     case "snap":
         return UpdateSnapPackages(packageName)
     case "flatpak":
@@ -0,0 +1,227 @@
+package packagemanager
+
+// This file is responsible for noticing updates of VCS packages (-git, -svn, -hg, ...). Their PKGBUILD builds
+// whatever upstream points to at build time, so the AUR version only changes when the maintainer bumps it.
+// When such a package is built, the upstream commit of each of its git sources is recorded, and
+// update --devel asks upstream with git ls-remote whether any of them moved since
+
+import (
+    "bytes"
+    "fmt"
+    "sort"
+    "strings"
+    "pixelridgesoftworks.com/AllPac/pkg/logger"
+)
+
+// the package name suffixes AUR packages that build from a VCS checkout use
+var vcsPackageSuffixes = []string{"-git", "-svn", "-hg", "-bzr", "-fossil", "-darcs", "-cvs"}
+
+// the protocols makepkg checks out sources with
+var vcsProtocols = []string{"git", "svn", "hg", "bzr", "fossil"}
+
+// vcsSource represents a source of a PKGBUILD that makepkg checks out from a VCS,
+// e.g. "name::git+https://example.com/repo.git#branch=main"
+type vcsSource struct {
+    // the source as written in the .SRCINFO, without the name:: prefix
+    Source string
+    // the VCS, e.g. git
+    Protocol string
+    // the URL of the repository, without the VCS prefix, fragment and query
+    URL string
+    // the fragment selecting what to check out, e.g. branch, tag, commit or revision
+    FragmentKey   string
+    FragmentValue string
+}
+
+// reports whether the source is pinned to a fixed commit or revision, so it never moves
+func (s vcsSource) pinned() bool {
+    return s.FragmentKey == "commit" || s.FragmentKey == "revision"
+}
+
+// returns the ref git ls-remote resolves for a git source
+func (s vcsSource) gitRef() string {
+    switch s.FragmentKey {
+    case "branch":
+        return "refs/heads/" + s.FragmentValue
+    case "tag":
+        return "refs/tags/" + s.FragmentValue
+    }
+    return "HEAD"
+}
+
+// parses a source of a .SRCINFO, reporting whether makepkg checks it out from a VCS
+func parseVCSSource(source string) (vcsSource, bool) {
+    if _, location, found := strings.Cut(source, "::"); found {
+        source = location
+    }
+
+    scheme, _, found := strings.Cut(source, "://")
+    if !found {
+        return vcsSource{}, false
+    }
+    protocol, _, _ := strings.Cut(scheme, "+")
+    if !isVCSProtocol(protocol) {
+        return vcsSource{}, false
+    }
+
+    parsed := vcsSource{Source: source, Protocol: protocol}
+    url := source
+    if strings.Contains(scheme, "+") {
+        url = strings.TrimPrefix(source, protocol+"+")
+    }
+    // makepkg allows the ?signed query before or after the fragment
+    url, fragment, _ := strings.Cut(url, "#")
+    url, _, _ = strings.Cut(url, "?")
+    fragment, _, _ = strings.Cut(fragment, "?")
+    parsed.URL = url
+    parsed.FragmentKey, parsed.FragmentValue, _ = strings.Cut(fragment, "=")
+    return parsed, true
+}
+
+func isVCSProtocol(protocol string) bool {
+    for _, known := range vcsProtocols {
+        if protocol == known {
+            return true
+        }
+    }
+    return false
+}
+
+// returns the VCS sources in a .SRCINFO, across all architectures
+func vcsSourcesOf(srcinfo map[string][]string) []vcsSource {
+    var keys []string
+    for key := range srcinfo {
+        if key == "source" || strings.HasPrefix(key, "source_") {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+
+    var sources []vcsSource
+    seen := make(map[string]bool)
+    for _, key := range keys {
+        for _, entry := range srcinfo[key] {
+            if source, ok := parseVCSSource(entry); ok && !seen[source.Source] {
+                seen[source.Source] = true
+                sources = append(sources, source)
+            }
+        }
+    }
+    return sources
+}
+
+// reports whether a package builds from a VCS checkout, by its name, the upstream commits recorded for it
+// or the sources in its clone
+func isVCSPackage(packageName string, pkgInfo PackageInfo) bool {
+    if len(pkgInfo.VCS) > 0 {
+        return true
+    }
+    for _, suffix := range vcsPackageSuffixes {
+        if strings.HasSuffix(packageName, suffix) {
+            return true
+        }
+    }
+
+    srcinfo, err := readAURCloneSrcinfo(packageName)
+    return err == nil && len(vcsSourcesOf(srcinfo)) > 0
+}
+
+// reads the .SRCINFO of the clone a package is built from
+func readAURCloneSrcinfo(packageName string) (map[string][]string, error) {
+    usr, err := getBuildUser()
+    if err != nil {
+        return nil, err
+    }
+    return readSrcinfo(aurCloneDir(usr.HomeDir, aurPackageBase(packageName)))
+}
+
+// returns the commit a git source points to upstream
+func gitUpstreamHead(source vcsSource) (string, error) {
+    cmd, err := newBuildCommand(gitLsRemoteCommand(source.URL, source.gitRef()))
+    if err != nil {
+        return "", err
+    }
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    output, err := cmd.Output()
+    if err != nil {
+        logger.Errorf("error asking %s for %s: %s, %v", source.URL, source.gitRef(), bytes.TrimSpace(stderr.Bytes()), err)
+        return "", fmt.Errorf("error asking %s for %s: %s, %v", source.URL, source.gitRef(), bytes.TrimSpace(stderr.Bytes()), err)
+    }
+
+    fields := strings.Fields(string(output))
+    if len(fields) == 0 {
+        logger.Errorf("%s has no %s", source.URL, source.gitRef())
+        return "", fmt.Errorf("%s has no %s", source.URL, source.gitRef())
+    }
+    return fields[0], nil
+}
+
+// returns the upstream commits of the git sources in a clone, by source. Pinned sources are left out,
+// and sources of other VCSs are only warned about, since they can't be checked without checking them out
+func vcsUpstreamHeads(packageName, cloneDir string) (map[string]string, error) {
+    srcinfo, err := readSrcinfo(cloneDir)
+    if err != nil {
+        logger.Errorf("error reading the .SRCINFO of %s: %v", cloneDir, err)
+        return nil, fmt.Errorf("error reading the .SRCINFO of %s: %v", cloneDir, err)
+    }
+
+    var heads map[string]string
+    for _, source := range vcsSourcesOf(srcinfo) {
+        if source.pinned() {
+            continue
+        }
+        if source.Protocol != "git" {
+            logger.Warnf("the %s source %s of %s can't be checked for upstream changes", source.Protocol, source.URL, packageName)
+            continue
+        }
+        head, err := gitUpstreamHead(source)
+        if err != nil {
+            return nil, err
+        }
+        if heads == nil {
+            heads = make(map[string]string)
+        }
+        heads[source.Source] = head
+    }
+    return heads, nil
+}
+
+// reports whether upstream moved since a VCS package was built, and why. Packages whose upstream commits
+// were never recorded count as changed, unless their clone shows they can't be checked at all
+func vcsUpstreamChanged(packageName string, pkgInfo PackageInfo) (bool, string, error) {
+    if len(pkgInfo.VCS) == 0 {
+        if srcinfo, err := readAURCloneSrcinfo(packageName); err == nil {
+            checkable := false
+            for _, source := range vcsSourcesOf(srcinfo) {
+                checkable = checkable || (source.Protocol == "git" && !source.pinned())
+            }
+            if !checkable {
+                logger.Warnf("%s has no git sources that follow upstream, so it can't be checked for changes", packageName)
+                return false, "", nil
+            }
+        }
+        return true, "no upstream commits were recorded when it was built", nil
+    }
+
+    var sources []string
+    for source := range pkgInfo.VCS {
+        sources = append(sources, source)
+    }
+    sort.Strings(sources)
+
+    for _, source := range sources {
+        parsed, ok := parseVCSSource(source)
+        if !ok {
+            continue
+        }
+        head, err := gitUpstreamHead(parsed)
+        if err != nil {
+            return false, "", err
+        }
+        if head != pkgInfo.VCS[source] {
+            return true, fmt.Sprintf("%s moved from %s to %s", parsed.URL, shortCommit(pkgInfo.VCS[source]), shortCommit(head)), nil
+        }
+    }
+    return false, "", nil
+}
@@ -0,0 +1,182 @@
+package packagemanager
+
+import (
+    "os/exec"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseVCSSource(t *testing.T) {
+    tests := []struct {
+        source string
+        want   vcsSource
+        wantOK bool
+    }{
+        {
+            source: "git+https://github.com/foo/bar.git",
+            want:   vcsSource{Source: "git+https://github.com/foo/bar.git", Protocol: "git", URL: "https://github.com/foo/bar.git"},
+            wantOK: true,
+        },
+        {
+            source: "bar::git+https://github.com/foo/bar.git#branch=main",
+            want: vcsSource{Source: "git+https://github.com/foo/bar.git#branch=main", Protocol: "git",
+                URL: "https://github.com/foo/bar.git", FragmentKey: "branch", FragmentValue: "main"},
+            wantOK: true,
+        },
+        // makepkg accepts ?signed before and after the fragment
+        {
+            source: "git+https://github.com/foo/bar.git?signed#tag=v1.0",
+            want: vcsSource{Source: "git+https://github.com/foo/bar.git?signed#tag=v1.0", Protocol: "git",
+                URL: "https://github.com/foo/bar.git", FragmentKey: "tag", FragmentValue: "v1.0"},
+            wantOK: true,
+        },
+        {
+            source: "bar::git+https://github.com/foo/bar.git#tag=v1.0?signed",
+            want: vcsSource{Source: "git+https://github.com/foo/bar.git#tag=v1.0?signed", Protocol: "git",
+                URL: "https://github.com/foo/bar.git", FragmentKey: "tag", FragmentValue: "v1.0"},
+            wantOK: true,
+        },
+        {
+            source: "git://git.example.com/bar.git#commit=0123abc",
+            want: vcsSource{Source: "git://git.example.com/bar.git#commit=0123abc", Protocol: "git",
+                URL: "git://git.example.com/bar.git", FragmentKey: "commit", FragmentValue: "0123abc"},
+            wantOK: true,
+        },
+        {
+            source: "svn+https://svn.example.com/bar/trunk#revision=1234",
+            want: vcsSource{Source: "svn+https://svn.example.com/bar/trunk#revision=1234", Protocol: "svn",
+                URL: "https://svn.example.com/bar/trunk", FragmentKey: "revision", FragmentValue: "1234"},
+            wantOK: true,
+        },
+        {source: "https://example.com/bar-1.0.tar.gz"},
+        {source: "bar-1.0.tar.gz::https://example.com/v1.0.tar.gz"},
+        {source: "bar.patch"},
+    }
+
+    for _, test := range tests {
+        got, ok := parseVCSSource(test.source)
+        if ok != test.wantOK || !reflect.DeepEqual(got, test.want) {
+            t.Errorf("parseVCSSource(%q) = %+v, %v, want %+v, %v", test.source, got, ok, test.want, test.wantOK)
+        }
+    }
+}
+
+func TestVCSSourceRefs(t *testing.T) {
+    tests := []struct {
+        source     string
+        wantRef    string
+        wantPinned bool
+    }{
+        {"git+https://example.com/bar.git", "HEAD", false},
+        {"git+https://example.com/bar.git#branch=dev", "refs/heads/dev", false},
+        {"git+https://example.com/bar.git#tag=v1.0", "refs/tags/v1.0", false},
+        {"git+https://example.com/bar.git#commit=0123abc", "HEAD", true},
+    }
+    for _, test := range tests {
+        source, _ := parseVCSSource(test.source)
+        if ref := source.gitRef(); ref != test.wantRef {
+            t.Errorf("gitRef() of %q = %q, want %q", test.source, ref, test.wantRef)
+        }
+        if pinned := source.pinned(); pinned != test.wantPinned {
+            t.Errorf("pinned() of %q = %v, want %v", test.source, pinned, test.wantPinned)
+        }
+    }
+}
+
+func TestVCSSourcesOf(t *testing.T) {
+    srcinfo, err := parseSrcinfo(strings.NewReader("pkgbase = bar-git\n" +
+        "\tsource = bar::git+https://github.com/foo/bar.git\n" +
+        "\tsource = bar.patch\n" +
+        "\tsource = https://example.com/data.tar.gz\n" +
+        "\tsource_x86_64 = git+https://github.com/foo/blobs.git#branch=x86\n" +
+        "\tsource_x86_64 = git+https://github.com/foo/bar.git\n" +
+        "\tsha256sums = SKIP\n" +
+        "\npkgname = bar-git\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var got []string
+    for _, source := range vcsSourcesOf(srcinfo) {
+        got = append(got, source.Source)
+    }
+    want := []string{"git+https://github.com/foo/bar.git", "git+https://github.com/foo/blobs.git#branch=x86"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("vcsSourcesOf() = %q, want %q", got, want)
+    }
+}
+
+func TestAURVersionNeedsUpdate(t *testing.T) {
+    tests := []struct {
+        installed, aur string
+        vcs            bool
+        want           bool
+    }{
+        {"1.0-1", "1.1-1", false, true},
+        {"1.0-1", "1.0-1", false, false},
+        // A VCS package built from upstream is usually ahead of its AUR version
+        {"1.0.r42.gabc1234-1", "1.0.r1.g0000000-1", true, false},
+        {"1.0.r42.gabc1234-1", "1.0.r42.gabc1234-1", true, false},
+        {"1.0.r42.gabc1234-1", "1.1.r0.gdef5678-1", true, true},
+        {"1.0.r42.gabc1234-1", "1:0.9-1", true, true},
+    }
+    for _, test := range tests {
+        got := aurVersionNeedsUpdate(PackageInfo{Version: test.installed}, test.aur, test.vcs)
+        if got != test.want {
+            t.Errorf("aurVersionNeedsUpdate(%q, %q, %v) = %v, want %v", test.installed, test.aur, test.vcs, got, test.want)
+        }
+    }
+}
+
+func TestGitUpstreamHead(t *testing.T) {
+    if runningAsRoot() {
+        t.Skip("git runs as the build user when the tests run as root")
+    }
+    if _, err := exec.LookPath("git"); err != nil {
+        t.Skip("git is not installed")
+    }
+
+    repoDir := t.TempDir()
+    git := func(args ...string) string {
+        t.Helper()
+        args = append([]string{"-C", repoDir, "-c", "user.name=AllPac", "-c", "user.email=allpac@example.invalid"}, args...)
+        output, err := exec.Command("git", args...).CombinedOutput()
+        if err != nil {
+            t.Fatalf("git %s: %s, %v", strings.Join(args, " "), output, err)
+        }
+        return strings.TrimSpace(string(output))
+    }
+    git("init", "--quiet", "--initial-branch=main")
+    git("commit", "--quiet", "--allow-empty", "-m", "first")
+    first := git("rev-parse", "HEAD")
+    git("tag", "v1.0")
+    git("checkout", "--quiet", "-b", "dev")
+    git("commit", "--quiet", "--allow-empty", "-m", "dev")
+    dev := git("rev-parse", "HEAD")
+    git("checkout", "--quiet", "main")
+    git("commit", "--quiet", "--allow-empty", "-m", "second")
+    main := git("rev-parse", "HEAD")
+
+    tests := []struct {
+        source  string
+        want    string
+        wantErr bool
+    }{
+        {"git+file://" + repoDir, main, false},
+        {"git+file://" + repoDir + "#branch=dev", dev, false},
+        {"git+file://" + repoDir + "#tag=v1.0", first, false},
+        {"git+file://" + repoDir + "#branch=missing", "", true},
+    }
+    for _, test := range tests {
+        source, _ := parseVCSSource(test.source)
+        head, err := gitUpstreamHead(source)
+        if (err != nil) != test.wantErr {
+            t.Errorf("gitUpstreamHead(%q) error = %v, wantErr %v", test.source, err, test.wantErr)
+            continue
+        }
+        if head != test.want {
+            t.Errorf("gitUpstreamHead(%q) = %q, want %q", test.source, head, test.want)
+        }
+    }
+}
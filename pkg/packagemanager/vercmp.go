@@ -0,0 +1,147 @@
+package packagemanager
+
+// This file is responsible for comparing package versions the way pacman does. It is a port of
+// alpm_pkg_vercmp from libalpm, so "1.0-2" < "1.0.1-1" < "1:0.9-1", and "1.0rc1" < "1.0"
+
+import (
+    "strings"
+)
+
+// compares two versions in [epoch:]version[-release] form, returning -1 if a is older than b,
+// 0 if they are the same and 1 if a is newer, just like pacman's vercmp
+func vercmp(a, b string) int {
+    if a == b {
+        return 0
+    }
+
+    epochA, versionA, releaseA := splitVersion(a)
+    epochB, versionB, releaseB := splitVersion(b)
+    if result := rpmvercmp(epochA, epochB); result != 0 {
+        return result
+    }
+    if result := rpmvercmp(versionA, versionB); result != 0 {
+        return result
+    }
+    // A version without a release matches any release of the same version
+    if releaseA != "" && releaseB != "" {
+        return rpmvercmp(releaseA, releaseB)
+    }
+    return 0
+}
+
+// splits a version into its epoch, which defaults to 0, its version and its release
+func splitVersion(full string) (string, string, string) {
+    epoch := "0"
+    digits := 0
+    for digits < len(full) && isDigit(full[digits]) {
+        digits++
+    }
+    version := full
+    if digits < len(full) && full[digits] == ':' {
+        if digits > 0 {
+            epoch = full[:digits]
+        }
+        version = full[digits+1:]
+    }
+
+    release := ""
+    if index := strings.LastIndex(version, "-"); index >= 0 {
+        version, release = version[:index], version[index+1:]
+    }
+    return epoch, version, release
+}
+
+// compares two version strings segment by segment, where segments are runs of digits or letters
+// separated by anything else. Numeric segments compare as numbers and beat alphabetic ones
+func rpmvercmp(a, b string) int {
+    if a == b {
+        return 0
+    }
+
+    one, two := 0, 0
+    for one < len(a) && two < len(b) {
+        startOne, startTwo := one, two
+        for one < len(a) && !isAlnum(a[one]) {
+            one++
+        }
+        for two < len(b) && !isAlnum(b[two]) {
+            two++
+        }
+        if one >= len(a) || two >= len(b) {
+            break
+        }
+
+        // More separators in front of a segment means a newer version, e.g. 1.0..1 > 1.0.1
+        if one-startOne != two-startTwo {
+            if one-startOne < two-startTwo {
+                return -1
+            }
+            return 1
+        }
+
+        endOne, endTwo := one, two
+        isNumber := isDigit(a[one])
+        if isNumber {
+            for endOne < len(a) && isDigit(a[endOne]) {
+                endOne++
+            }
+            for endTwo < len(b) && isDigit(b[endTwo]) {
+                endTwo++
+            }
+        } else {
+            for endOne < len(a) && isAlpha(a[endOne]) {
+                endOne++
+            }
+            for endTwo < len(b) && isAlpha(b[endTwo]) {
+                endTwo++
+            }
+        }
+
+        segmentOne, segmentTwo := a[one:endOne], b[two:endTwo]
+        // The segments are of different types, and numbers are newer than letters
+        if segmentTwo == "" {
+            if isNumber {
+                return 1
+            }
+            return -1
+        }
+
+        if isNumber {
+            segmentOne = strings.TrimLeft(segmentOne, "0")
+            segmentTwo = strings.TrimLeft(segmentTwo, "0")
+            if len(segmentOne) != len(segmentTwo) {
+                if len(segmentOne) > len(segmentTwo) {
+                    return 1
+                }
+                return -1
+            }
+        }
+        if result := strings.Compare(segmentOne, segmentTwo); result != 0 {
+            return result
+        }
+
+        one, two = endOne, endTwo
+    }
+
+    if one >= len(a) && two >= len(b) {
+        return 0
+    }
+
+    // A remaining alphabetic segment never beats an empty one, e.g. 1.0rc1 < 1.0, but 1.0.1 > 1.0
+    if (one >= len(a) && !isAlpha(b[two])) || (one < len(a) && isAlpha(a[one])) {
+        return -1
+    }
+    return 1
+}
+
+func isDigit(c byte) bool {
+    return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+    return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+    return isDigit(c) || isAlpha(c)
+}
@@ -0,0 +1,52 @@
+package packagemanager
+
+import (
+    "testing"
+)
+
+func TestVercmp(t *testing.T) {
+    tests := []struct {
+        a, b string
+        want int
+    }{
+        {"1.0", "1.0", 0},
+        {"1.0", "1.0.1", -1},
+        {"1.0.1", "1.0", 1},
+        {"1.0-1", "1.0-2", -1},
+        {"1.0-2", "1.0.1-1", -1},
+        {"1.0", "1.0-5", 0},
+        {"1.10", "1.9", 1},
+        {"1.010", "1.10", 0},
+        {"1.0rc1", "1.0", -1},
+        {"1.0a", "1.0", -1},
+        {"1.0alpha", "1.0beta", -1},
+        {"1.0a", "1.0.1", -1},
+        {"1.0.a", "1.0.1", -1},
+        {"1.0..1", "1.0.1", 1},
+        {"1:0.9-1", "1.0-1", 1},
+        {"0:1.0-1", "1.0-1", 0},
+        {"2:1.0", "1:2.0", 1},
+        {"1.0.r12.gabcdef-1", "1.0.r9.g123456-1", 1},
+        {"6.8.arch1-1", "6.8.1.arch1-1", -1},
+        {"23.1.0-1", "23.1-1", 1},
+    }
+
+    for _, test := range tests {
+        if got := vercmp(test.a, test.b); got != test.want {
+            t.Errorf("vercmp(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+        }
+        if got := vercmp(test.b, test.a); got != -test.want {
+            t.Errorf("vercmp(%q, %q) = %d, want %d", test.b, test.a, got, -test.want)
+        }
+    }
+}
+
+func TestCompareVersions(t *testing.T) {
+    installed := map[string]string{"git": "2.43.0-1", "linux": "6.8.1.arch1-1", "mesa": "1:24.0.3-1", "zsh": "5.9-4"}
+    available := map[string]string{"git": "2.44.0-1", "linux": "6.8.arch1-1", "mesa": "24.0.4-1", "zsh": "5.9-4"}
+
+    got := compareVersions([]string{"git", "linux", "mesa", "zsh", "missing"}, installed, available)
+    if len(got) != 1 || got["git"] != (versionPair{"2.43.0-1", "2.44.0-1"}) {
+        t.Errorf("compareVersions() = %v, want only git", got)
+    }
+}
@@ -0,0 +1,439 @@
+package xz
+
+// This file is responsible for LZMA2, the chunked form of LZMA that xz blocks are compressed with.
+// The LZMA decoder follows the reference decoder of the LZMA SDK
+
+import (
+    "errors"
+    "fmt"
+)
+
+const (
+    states           = 12
+    posStatesMax     = 1 << 4
+    lenToPosStates   = 4
+    alignBits        = 4
+    startPosModel    = 4
+    endPosModel      = 14
+    fullDistances    = 1 << (endPosModel >> 1)
+    matchMinLength   = 2
+    probabilityBits  = 11
+    probabilityInit  = 1 << (probabilityBits - 1)
+    probabilityShift = 5
+    rangeTop         = 1 << 24
+)
+
+// the probability of a bit being 0, out of 1<<probabilityBits
+type probability uint16
+
+// returns the dictionary size LZMA2 properties describe, which bounds how far matches reach back
+func lzma2DictionarySize(properties byte) (uint32, error) {
+    if properties > 40 {
+        return 0, errors.New("xz: invalid LZMA2 dictionary size")
+    }
+    if properties == 40 {
+        return 0xffffffff, nil
+    }
+    return (2 | uint32(properties)&1) << (properties/2 + 11), nil
+}
+
+// decodes LZMA2 chunks until the end marker, appending to out and returning how much input was used.
+// Matches can reach back to the last dictionary reset, which every block starts with
+func decodeLZMA2(data []byte, out []byte, dictionarySize uint32) ([]byte, int, error) {
+    var decoder *lzmaDecoder
+    dictionaryStart := -1
+    pos := 0
+    for {
+        if len(data) < pos+1 {
+            return nil, 0, errTruncated
+        }
+        control := data[pos]
+        pos++
+
+        switch {
+        case control == 0x00:
+            return out, pos, nil
+        case control == 0x01 || control == 0x02:
+            // An uncompressed chunk, optionally resetting the dictionary first
+            if control == 0x01 {
+                dictionaryStart = len(out)
+            } else if dictionaryStart < 0 {
+                return nil, 0, errors.New("xz: LZMA2 data doesn't start with a dictionary reset")
+            }
+            if len(data) < pos+2 {
+                return nil, 0, errTruncated
+            }
+            size := int(data[pos])<<8 | int(data[pos+1]) + 1
+            pos += 2
+            if len(data) < pos+size {
+                return nil, 0, errTruncated
+            }
+            out = append(out, data[pos:pos+size]...)
+            pos += size
+        case control >= 0x80:
+            if len(data) < pos+4 {
+                return nil, 0, errTruncated
+            }
+            unpackedSize := int(control&0x1f)<<16 | int(data[pos])<<8 | int(data[pos+1]) + 1
+            packedSize := int(data[pos+2])<<8 | int(data[pos+3]) + 1
+            pos += 4
+
+            // Bits 5 and 6 reset nothing, the state, the state and properties, or everything including the dictionary
+            reset := (control >> 5) & 3
+            if reset == 3 {
+                dictionaryStart = len(out)
+            } else if dictionaryStart < 0 {
+                return nil, 0, errors.New("xz: LZMA2 data doesn't start with a dictionary reset")
+            }
+            if reset >= 2 {
+                if len(data) < pos+1 {
+                    return nil, 0, errTruncated
+                }
+                var err error
+                if decoder, err = newLZMADecoder(data[pos]); err != nil {
+                    return nil, 0, err
+                }
+                pos++
+            } else if decoder == nil {
+                return nil, 0, errors.New("xz: LZMA2 chunk without properties")
+            } else if reset == 1 {
+                decoder.reset()
+            }
+
+            if len(data) < pos+packedSize {
+                return nil, 0, errTruncated
+            }
+            var err error
+            out, err = decoder.decodeChunk(data[pos:pos+packedSize], out, dictionaryStart, dictionarySize, unpackedSize)
+            if err != nil {
+                return nil, 0, err
+            }
+            pos += packedSize
+        default:
+            return nil, 0, fmt.Errorf("xz: invalid LZMA2 control byte %#x", control)
+        }
+    }
+}
+
+// the range coder every LZMA chunk is coded with
+type rangeDecoder struct {
+    data    []byte
+    pos     int
+    rng     uint32
+    code    uint32
+    overrun bool
+}
+
+func newRangeDecoder(data []byte) (*rangeDecoder, error) {
+    if len(data) < 5 || data[0] != 0 {
+        return nil, errors.New("xz: invalid LZMA range coder header")
+    }
+    code := uint32(data[1])<<24 | uint32(data[2])<<16 | uint32(data[3])<<8 | uint32(data[4])
+    return &rangeDecoder{data: data, pos: 5, rng: 0xffffffff, code: code}, nil
+}
+
+func (rc *rangeDecoder) normalize() {
+    if rc.rng < rangeTop {
+        rc.rng <<= 8
+        var next byte
+        if rc.pos < len(rc.data) {
+            next = rc.data[rc.pos]
+        } else {
+            rc.overrun = true
+        }
+        rc.pos++
+        rc.code = rc.code<<8 | uint32(next)
+    }
+}
+
+// decodes a bit with the given probability, adapting it to the result
+func (rc *rangeDecoder) bit(prob *probability) uint32 {
+    bound := (rc.rng >> probabilityBits) * uint32(*prob)
+    var bit uint32
+    if rc.code < bound {
+        rc.rng = bound
+        *prob += (1<<probabilityBits - *prob) >> probabilityShift
+    } else {
+        rc.rng -= bound
+        rc.code -= bound
+        *prob -= *prob >> probabilityShift
+        bit = 1
+    }
+    rc.normalize()
+    return bit
+}
+
+// decodes bits that are equally likely to be 0 or 1
+func (rc *rangeDecoder) directBits(count int) uint32 {
+    var result uint32
+    for i := 0; i < count; i++ {
+        rc.rng >>= 1
+        var bit uint32
+        if rc.code >= rc.rng {
+            rc.code -= rc.rng
+            bit = 1
+        }
+        rc.normalize()
+        result = result<<1 | bit
+    }
+    return result
+}
+
+// decodes a number of the given width, highest bit first
+func (rc *rangeDecoder) bitTree(probs []probability, width int) uint32 {
+    m := uint32(1)
+    for i := 0; i < width; i++ {
+        m = m<<1 | rc.bit(&probs[m])
+    }
+    return m - 1<<width
+}
+
+// decodes a number of the given width, lowest bit first
+func (rc *rangeDecoder) reverseBitTree(probs []probability, width int) uint32 {
+    m := uint32(1)
+    var symbol uint32
+    for i := 0; i < width; i++ {
+        bit := rc.bit(&probs[m])
+        m = m<<1 | bit
+        symbol |= bit << i
+    }
+    return symbol
+}
+
+// decodes the lengths of matches
+type lengthDecoder struct {
+    choice  probability
+    choice2 probability
+    low     [posStatesMax][1 << 3]probability
+    mid     [posStatesMax][1 << 3]probability
+    high    [1 << 8]probability
+}
+
+func (l *lengthDecoder) reset() {
+    l.choice, l.choice2 = probabilityInit, probabilityInit
+    for posState := range l.low {
+        resetProbabilities(l.low[posState][:])
+        resetProbabilities(l.mid[posState][:])
+    }
+    resetProbabilities(l.high[:])
+}
+
+func (l *lengthDecoder) decode(rc *rangeDecoder, posState uint32) int {
+    if rc.bit(&l.choice) == 0 {
+        return int(rc.bitTree(l.low[posState][:], 3))
+    }
+    if rc.bit(&l.choice2) == 0 {
+        return 8 + int(rc.bitTree(l.mid[posState][:], 3))
+    }
+    return 16 + int(rc.bitTree(l.high[:], 8))
+}
+
+// holds the LZMA state, which carries over from one chunk to the next until a chunk resets it
+type lzmaDecoder struct {
+    lc, lp, pb uint
+    state      int
+    reps       [4]uint32
+
+    isMatch     [states * posStatesMax]probability
+    isRep       [states]probability
+    isRepG0     [states]probability
+    isRepG1     [states]probability
+    isRepG2     [states]probability
+    isRep0Long  [states * posStatesMax]probability
+    posSlot     [lenToPosStates][1 << 6]probability
+    posDecoders [1 + fullDistances - endPosModel]probability
+    align       [1 << alignBits]probability
+    lengths     lengthDecoder
+    repLengths  lengthDecoder
+    literals    []probability
+}
+
+// creates a decoder from an LZMA2 properties byte, which packs lc, lp and pb
+func newLZMADecoder(properties byte) (*lzmaDecoder, error) {
+    if properties >= 9*5*5 {
+        return nil, errors.New("xz: invalid LZMA properties")
+    }
+    lc := uint(properties % 9)
+    lp := uint(properties / 9 % 5)
+    pb := uint(properties / 45)
+    if lc+lp > 4 {
+        return nil, errors.New("xz: invalid LZMA2 properties")
+    }
+
+    d := &lzmaDecoder{lc: lc, lp: lp, pb: pb, literals: make([]probability, 0x300<<(lc+lp))}
+    d.reset()
+    return d, nil
+}
+
+// resets the state and every probability
+func (d *lzmaDecoder) reset() {
+    d.state = 0
+    d.reps = [4]uint32{}
+    resetProbabilities(d.isMatch[:])
+    resetProbabilities(d.isRep[:])
+    resetProbabilities(d.isRepG0[:])
+    resetProbabilities(d.isRepG1[:])
+    resetProbabilities(d.isRepG2[:])
+    resetProbabilities(d.isRep0Long[:])
+    for i := range d.posSlot {
+        resetProbabilities(d.posSlot[i][:])
+    }
+    resetProbabilities(d.posDecoders[:])
+    resetProbabilities(d.align[:])
+    d.lengths.reset()
+    d.repLengths.reset()
+    resetProbabilities(d.literals)
+}
+
+func resetProbabilities(probs []probability) {
+    for i := range probs {
+        probs[i] = probabilityInit
+    }
+}
+
+// decodes a chunk of exactly unpackedSize bytes, appending them to out
+func (d *lzmaDecoder) decodeChunk(data []byte, out []byte, dictionaryStart int, dictionarySize uint32, unpackedSize int) ([]byte, error) {
+    rc, err := newRangeDecoder(data)
+    if err != nil {
+        return nil, err
+    }
+
+    end := len(out) + unpackedSize
+    posMask := uint32(1)<<d.pb - 1
+    for len(out) < end {
+        position := uint32(len(out) - dictionaryStart)
+        posState := position & posMask
+        // Literals after a match and short repeats read the byte at the last distance
+        if d.state >= 7 && d.reps[0] >= position {
+            return nil, errors.New("xz: LZMA distance reaches before the dictionary")
+        }
+
+        if rc.bit(&d.isMatch[d.state*posStatesMax+int(posState)]) == 0 {
+            out = d.decodeLiteral(rc, out, position)
+            continue
+        }
+
+        var length int
+        if rc.bit(&d.isRep[d.state]) != 0 {
+            if d.reps[0] >= position {
+                return nil, errors.New("xz: LZMA distance reaches before the dictionary")
+            }
+            if rc.bit(&d.isRepG0[d.state]) == 0 {
+                // A single byte from the last distance
+                if rc.bit(&d.isRep0Long[d.state*posStatesMax+int(posState)]) == 0 {
+                    d.state = nextState(d.state, 9, 11)
+                    out = append(out, out[len(out)-int(d.reps[0])-1])
+                    continue
+                }
+            } else {
+                var distance uint32
+                if rc.bit(&d.isRepG1[d.state]) == 0 {
+                    distance = d.reps[1]
+                } else {
+                    if rc.bit(&d.isRepG2[d.state]) == 0 {
+                        distance = d.reps[2]
+                    } else {
+                        distance = d.reps[3]
+                        d.reps[3] = d.reps[2]
+                    }
+                    d.reps[2] = d.reps[1]
+                }
+                d.reps[1] = d.reps[0]
+                d.reps[0] = distance
+            }
+            length = d.repLengths.decode(rc, posState)
+            d.state = nextState(d.state, 8, 11)
+        } else {
+            d.reps[3], d.reps[2], d.reps[1] = d.reps[2], d.reps[1], d.reps[0]
+            length = d.lengths.decode(rc, posState)
+            d.state = nextState(d.state, 7, 10)
+            d.reps[0] = d.decodeDistance(rc, length)
+            if d.reps[0] == 0xffffffff {
+                return nil, errors.New("xz: LZMA end marker in LZMA2 data")
+            }
+        }
+
+        length += matchMinLength
+        distance := int(d.reps[0]) + 1
+        if uint32(distance-1) >= dictionarySize || distance > len(out)-dictionaryStart {
+            return nil, fmt.Errorf("xz: LZMA match distance %d reaches before the dictionary", distance)
+        }
+        if len(out)+length > end {
+            return nil, errors.New("xz: LZMA match crosses the chunk boundary")
+        }
+        start := len(out) - distance
+        for i := 0; i < length; i++ {
+            out = append(out, out[start+i])
+        }
+    }
+
+    if rc.overrun || rc.code != 0 {
+        return nil, errors.New("xz: corrupt LZMA chunk")
+    }
+    return out, nil
+}
+
+// decodes a literal byte, which after a match is coded relative to the byte at the last distance
+func (d *lzmaDecoder) decodeLiteral(rc *rangeDecoder, out []byte, position uint32) []byte {
+    var previous uint32
+    if position > 0 {
+        previous = uint32(out[len(out)-1])
+    }
+    literalState := (position&(1<<d.lp-1))<<d.lc + previous>>(8-d.lc)
+    probs := d.literals[0x300*literalState : 0x300*(literalState+1)]
+
+    symbol := uint32(1)
+    if d.state >= 7 {
+        matchByte := uint32(out[len(out)-int(d.reps[0])-1])
+        for symbol < 0x100 {
+            matchBit := (matchByte >> 7) & 1
+            matchByte <<= 1
+            bit := rc.bit(&probs[(1+matchBit)<<8+symbol])
+            symbol = symbol<<1 | bit
+            if matchBit != bit {
+                break
+            }
+        }
+    }
+    for symbol < 0x100 {
+        symbol = symbol<<1 | rc.bit(&probs[symbol])
+    }
+
+    switch {
+    case d.state < 4:
+        d.state = 0
+    case d.state < 10:
+        d.state -= 3
+    default:
+        d.state -= 6
+    }
+    return append(out, byte(symbol))
+}
+
+// decodes the distance of a new match, whose length picks the probabilities of its slot
+func (d *lzmaDecoder) decodeDistance(rc *rangeDecoder, length int) uint32 {
+    lengthState := length
+    if lengthState > lenToPosStates-1 {
+        lengthState = lenToPosStates - 1
+    }
+    slot := rc.bitTree(d.posSlot[lengthState][:], 6)
+    if slot < startPosModel {
+        return slot
+    }
+
+    directBits := int(slot>>1) - 1
+    distance := (2 | slot&1) << directBits
+    if slot < endPosModel {
+        return distance + rc.reverseBitTree(d.posDecoders[distance-slot:], directBits)
+    }
+    distance += rc.directBits(directBits-alignBits) << alignBits
+    return distance + rc.reverseBitTree(d.align[:], alignBits)
+}
+
+// returns the state after a match, repeated match or short repeat, which depends on whether a literal came before
+func nextState(state, afterLiteral, afterMatch int) int {
+    if state < 7 {
+        return afterLiteral
+    }
+    return afterMatch
+}
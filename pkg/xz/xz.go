@@ -0,0 +1,303 @@
+package xz
+
+// This package is responsible for decompressing xz data, so the sync databases can be read without
+// the xz command line tool. It understands the blocks xz and repo-add write, which are LZMA2 compressed
+// without any other filters, and only decompresses whole buffers at once
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "hash/crc64"
+)
+
+var (
+    headerMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+    footerMagic = []byte{'Y', 'Z'}
+)
+
+const (
+    checkNone   = 0x00
+    checkCRC32  = 0x01
+    checkCRC64  = 0x04
+    checkSHA256 = 0x0a
+)
+
+// the filter ID of LZMA2
+const filterLZMA2 = 0x21
+
+var errTruncated = errors.New("xz: unexpected end of input")
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// Decompress decompresses every stream in data and returns the concatenated content
+func Decompress(data []byte) ([]byte, error) {
+    var out []byte
+    for {
+        var err error
+        out, data, err = decodeStream(data, out)
+        if err != nil {
+            return nil, err
+        }
+
+        // Streams may be followed by padding, in multiples of four null bytes
+        for len(data) >= 4 && bytes.Equal(data[:4], []byte{0, 0, 0, 0}) {
+            data = data[4:]
+        }
+        if len(data) == 0 {
+            return out, nil
+        }
+    }
+}
+
+// a block as recorded in the index
+type indexRecord struct {
+    unpaddedSize     uint64
+    uncompressedSize uint64
+}
+
+// decodes a single stream, appending its content to out and returning the input after it
+func decodeStream(data []byte, out []byte) ([]byte, []byte, error) {
+    if len(data) < 12 {
+        return nil, nil, errTruncated
+    }
+    if !bytes.HasPrefix(data, headerMagic) {
+        return nil, nil, errors.New("xz: invalid stream header magic")
+    }
+    flags := data[6:8]
+    if crc32.ChecksumIEEE(flags) != binary.LittleEndian.Uint32(data[8:]) {
+        return nil, nil, errors.New("xz: stream header checksum mismatch")
+    }
+    if flags[0] != 0 || flags[1] > 0x0f {
+        return nil, nil, errors.New("xz: unsupported stream flags")
+    }
+    check := flags[1]
+    pos := 12
+
+    var records []indexRecord
+    for {
+        if len(data) < pos+1 {
+            return nil, nil, errTruncated
+        }
+        // A null byte where a block header would start is the index indicator
+        if data[pos] == 0 {
+            break
+        }
+
+        blockStart := len(out)
+        var record indexRecord
+        var err error
+        out, record, pos, err = decodeBlock(data, pos, out, check)
+        if err != nil {
+            return nil, nil, err
+        }
+        record.uncompressedSize = uint64(len(out) - blockStart)
+        records = append(records, record)
+    }
+
+    pos, err := readIndex(data, pos, records)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if len(data) < pos+12 {
+        return nil, nil, errTruncated
+    }
+    footer := data[pos : pos+12]
+    if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer) {
+        return nil, nil, errors.New("xz: stream footer checksum mismatch")
+    }
+    if !bytes.Equal(footer[8:10], flags) || !bytes.Equal(footer[10:], footerMagic) {
+        return nil, nil, errors.New("xz: invalid stream footer")
+    }
+    return out, data[pos+12:], nil
+}
+
+// decodes the block whose header starts at pos, returning the position after it
+func decodeBlock(data []byte, pos int, out []byte, check byte) ([]byte, indexRecord, int, error) {
+    headerSize := (int(data[pos]) + 1) * 4
+    if len(data) < pos+headerSize {
+        return nil, indexRecord{}, 0, errTruncated
+    }
+    header := data[pos : pos+headerSize]
+    if crc32.ChecksumIEEE(header[:headerSize-4]) != binary.LittleEndian.Uint32(header[headerSize-4:]) {
+        return nil, indexRecord{}, 0, errors.New("xz: block header checksum mismatch")
+    }
+
+    blockFlags := header[1]
+    if blockFlags&0x3c != 0 {
+        return nil, indexRecord{}, 0, errors.New("xz: unsupported block flags")
+    }
+    fields := header[2 : headerSize-4]
+    compressedSize, uncompressedSize := int64(-1), int64(-1)
+    var err error
+    if blockFlags&0x40 != 0 {
+        var size uint64
+        if size, fields, err = readVLI(fields); err != nil {
+            return nil, indexRecord{}, 0, err
+        }
+        compressedSize = int64(size)
+    }
+    if blockFlags&0x80 != 0 {
+        var size uint64
+        if size, fields, err = readVLI(fields); err != nil {
+            return nil, indexRecord{}, 0, err
+        }
+        uncompressedSize = int64(size)
+    }
+
+    // Only LZMA2 on its own is supported, without BCJ or delta filters in front of it
+    filters := int(blockFlags&0x03) + 1
+    var dictionarySize uint32
+    for i := 0; i < filters; i++ {
+        var id, propertiesSize uint64
+        if id, fields, err = readVLI(fields); err != nil {
+            return nil, indexRecord{}, 0, err
+        }
+        if propertiesSize, fields, err = readVLI(fields); err != nil {
+            return nil, indexRecord{}, 0, err
+        }
+        if uint64(len(fields)) < propertiesSize {
+            return nil, indexRecord{}, 0, errTruncated
+        }
+        if id != filterLZMA2 || i != filters-1 {
+            return nil, indexRecord{}, 0, fmt.Errorf("xz: unsupported filter %#x", id)
+        }
+        if propertiesSize != 1 {
+            return nil, indexRecord{}, 0, errors.New("xz: invalid LZMA2 properties")
+        }
+        if dictionarySize, err = lzma2DictionarySize(fields[0]); err != nil {
+            return nil, indexRecord{}, 0, err
+        }
+        fields = fields[1:]
+    }
+    for _, padding := range fields {
+        if padding != 0 {
+            return nil, indexRecord{}, 0, errors.New("xz: invalid block header padding")
+        }
+    }
+    pos += headerSize
+
+    blockStart := len(out)
+    out, consumed, err := decodeLZMA2(data[pos:], out, dictionarySize)
+    if err != nil {
+        return nil, indexRecord{}, 0, err
+    }
+    if (compressedSize >= 0 && int64(consumed) != compressedSize) || (uncompressedSize >= 0 && int64(len(out)-blockStart) != uncompressedSize) {
+        return nil, indexRecord{}, 0, errors.New("xz: block size doesn't match its header")
+    }
+    pos += consumed
+
+    // The compressed data is padded to a multiple of four bytes
+    for padded := consumed; padded%4 != 0; padded++ {
+        if len(data) < pos+1 {
+            return nil, indexRecord{}, 0, errTruncated
+        }
+        if data[pos] != 0 {
+            return nil, indexRecord{}, 0, errors.New("xz: invalid block padding")
+        }
+        pos++
+    }
+
+    checkSize := checkSizeOf(check)
+    if len(data) < pos+checkSize {
+        return nil, indexRecord{}, 0, errTruncated
+    }
+    if err := verifyCheck(check, out[blockStart:], data[pos:pos+checkSize]); err != nil {
+        return nil, indexRecord{}, 0, err
+    }
+    pos += checkSize
+
+    return out, indexRecord{unpaddedSize: uint64(headerSize + consumed + checkSize)}, pos, nil
+}
+
+// reads the index at pos and compares it with the blocks that were decoded, returning the position after it
+func readIndex(data []byte, pos int, records []indexRecord) (int, error) {
+    start := pos
+    fields := data[pos+1:]
+    count, fields, err := readVLI(fields)
+    if err != nil {
+        return 0, err
+    }
+    if count != uint64(len(records)) {
+        return 0, errors.New("xz: index doesn't match the blocks")
+    }
+    for _, record := range records {
+        var unpaddedSize, uncompressedSize uint64
+        if unpaddedSize, fields, err = readVLI(fields); err != nil {
+            return 0, err
+        }
+        if uncompressedSize, fields, err = readVLI(fields); err != nil {
+            return 0, err
+        }
+        if unpaddedSize != record.unpaddedSize || uncompressedSize != record.uncompressedSize {
+            return 0, errors.New("xz: index doesn't match the blocks")
+        }
+    }
+
+    pos = len(data) - len(fields)
+    for ; (pos-start)%4 != 0; pos++ {
+        if len(data) < pos+1 {
+            return 0, errTruncated
+        }
+        if data[pos] != 0 {
+            return 0, errors.New("xz: invalid index padding")
+        }
+    }
+    if len(data) < pos+4 {
+        return 0, errTruncated
+    }
+    if crc32.ChecksumIEEE(data[start:pos]) != binary.LittleEndian.Uint32(data[pos:]) {
+        return 0, errors.New("xz: index checksum mismatch")
+    }
+    return pos + 4, nil
+}
+
+// reads a variable length integer, seven bits per byte with the lowest bits first
+func readVLI(data []byte) (uint64, []byte, error) {
+    var value uint64
+    for i := 0; i < 9; i++ {
+        if i >= len(data) {
+            return 0, nil, errTruncated
+        }
+        value |= uint64(data[i]&0x7f) << (7 * i)
+        if data[i]&0x80 == 0 {
+            if i > 0 && data[i] == 0 {
+                return 0, nil, errors.New("xz: invalid variable length integer")
+            }
+            return value, data[i+1:], nil
+        }
+    }
+    return 0, nil, errors.New("xz: variable length integer too long")
+}
+
+// returns how many bytes the check of a block takes up, which is also known for unsupported checks
+func checkSizeOf(check byte) int {
+    if check == checkNone {
+        return 0
+    }
+    return 4 << ((check - 1) / 3)
+}
+
+// verifies the check of a block's content, skipping checks this package doesn't know
+func verifyCheck(check byte, content, stored []byte) error {
+    var matches bool
+    switch check {
+    case checkCRC32:
+        matches = crc32.ChecksumIEEE(content) == binary.LittleEndian.Uint32(stored)
+    case checkCRC64:
+        matches = crc64.Checksum(content, crc64Table) == binary.LittleEndian.Uint64(stored)
+    case checkSHA256:
+        sum := sha256.Sum256(content)
+        matches = bytes.Equal(sum[:], stored)
+    default:
+        return nil
+    }
+    if !matches {
+        return errors.New("xz: block check mismatch")
+    }
+    return nil
+}
@@ -0,0 +1,100 @@
+package xz
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestDecompress(t *testing.T) {
+    // The plain text is shared with the tests of the other decompressor
+    text, err := os.ReadFile(filepath.Join("..", "..", "internal", "testdata", "text.txt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        file string
+        want []byte
+    }{
+        // xz -6, with a CRC64 check
+        {"text.txt.xz", text},
+        // xz -0 -C crc32
+        {"text-crc32.txt.xz", text},
+        // xz -C sha256 --block-size=8000
+        {"text-blocks.txt.xz", text},
+        // xz -C none
+        {"text-none.txt.xz", text},
+        // the crc32 and the default stream with stream padding between them
+        {"text-streams.txt.xz", append(append([]byte{}, text...), text...)},
+    }
+
+    for _, test := range tests {
+        t.Run(test.file, func(t *testing.T) {
+            data, err := os.ReadFile(filepath.Join("testdata", test.file))
+            if err != nil {
+                t.Fatal(err)
+            }
+            got, err := Decompress(data)
+            if err != nil {
+                t.Fatalf("Decompress() error = %v", err)
+            }
+            if !bytes.Equal(got, test.want) {
+                t.Errorf("Decompress() returned %d bytes that don't match the %d expected", len(got), len(test.want))
+            }
+        })
+    }
+}
+
+func TestDecompressErrors(t *testing.T) {
+    data, err := os.ReadFile(filepath.Join("testdata", "text.txt.xz"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    x86, err := os.ReadFile(filepath.Join("testdata", "text-x86.txt.xz"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    flipped := append([]byte{}, data...)
+    flipped[len(flipped)/2] ^= 0x10
+    tests := map[string][]byte{
+        "empty":              nil,
+        "bad magic":          append([]byte{0x00}, data[1:]...),
+        "truncated":          data[:len(data)/2],
+        "flipped bit":        flipped,
+        "missing footer":     data[:len(data)-12],
+        "trailing garbage":   append(append([]byte{}, data...), 0x01, 0x02, 0x03, 0x04),
+        "unsupported filter": x86,
+    }
+
+    for name, corrupt := range tests {
+        t.Run(name, func(t *testing.T) {
+            if _, err := Decompress(corrupt); err == nil {
+                t.Error("Decompress() of unsupported or corrupt data succeeded")
+            }
+        })
+    }
+}
+
+func TestReadVLI(t *testing.T) {
+    tests := []struct {
+        data    []byte
+        want    uint64
+        wantErr bool
+    }{
+        {data: []byte{0x00}, want: 0},
+        {data: []byte{0x7f}, want: 127},
+        {data: []byte{0x80, 0x01}, want: 128},
+        {data: []byte{0xff, 0xff, 0x03}, want: 65535},
+        {data: []byte{0x80, 0x00}, wantErr: true},
+        {data: []byte{0x80}, wantErr: true},
+    }
+    for _, test := range tests {
+        got, _, err := readVLI(test.data)
+        if (err != nil) != test.wantErr || got != test.want {
+            t.Errorf("readVLI(%x) = %d, %v, want %d, error %v", test.data, got, err, test.want, test.wantErr)
+        }
+    }
+}
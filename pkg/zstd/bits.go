@@ -0,0 +1,94 @@
+package zstd
+
+// This file is responsible for reading the two kinds of bitstreams Zstandard uses: table descriptions
+// are read forwards from the lowest bit, while Huffman and FSE coded data is read backwards from its end
+
+import (
+    "errors"
+    "math/bits"
+)
+
+// reads a bitstream from its first byte on, lowest bits first
+type forwardBitReader struct {
+    data []byte
+    // the number of bits read so far
+    pos int
+}
+
+// reads the next n bits, which are zero past the end of the data
+func (b *forwardBitReader) read(n int) uint32 {
+    var value uint32
+    for i := 0; i < n; i++ {
+        bit := b.pos + i
+        if bit/8 < len(b.data) && b.data[bit/8]>>(bit%8)&1 != 0 {
+            value |= 1 << i
+        }
+    }
+    b.pos += n
+    return value
+}
+
+// returns the next n bits without consuming them
+func (b *forwardBitReader) peek(n int) uint32 {
+    value := b.read(n)
+    b.pos -= n
+    return value
+}
+
+// returns how many whole bytes the bits read so far take up
+func (b *forwardBitReader) bytesRead() int {
+    return (b.pos + 7) / 8
+}
+
+// reads a bitstream from its end, where the highest set bit of the last byte marks the start.
+// Reads past the beginning of the data return zeros and leave pos negative
+type backwardBitReader struct {
+    data []byte
+    // the number of bits that are left to read
+    pos int
+}
+
+func newBackwardBitReader(data []byte) (*backwardBitReader, error) {
+    if len(data) == 0 {
+        return nil, errTruncated
+    }
+    last := data[len(data)-1]
+    if last == 0 {
+        return nil, errors.New("zstd: bitstream without an end marker")
+    }
+    return &backwardBitReader{data: data, pos: (len(data)-1)*8 + bits.Len8(last) - 1}, nil
+}
+
+// returns the next n bits, at most 56, without consuming them
+func (b *backwardBitReader) peek(n uint8) uint64 {
+    end := b.pos
+    start := end - int(n)
+    padding := 0
+    if start < 0 {
+        padding = -start
+        start = 0
+    }
+    if end <= start {
+        return 0
+    }
+
+    var value uint64
+    for i := (end - 1) >> 3; i >= start>>3; i-- {
+        value = value<<8 | uint64(b.data[i])
+    }
+    value >>= uint(start & 7)
+    value &= 1<<uint(end-start) - 1
+    return value << uint(padding)
+}
+
+// reads the next n bits, at most 56
+func (b *backwardBitReader) read(n uint8) uint64 {
+    value := b.peek(n)
+    b.pos -= int(n)
+    return value
+}
+
+// reports whether more bits were read than the stream holds
+func (b *backwardBitReader) overflowed() bool {
+    return b.pos < 0
+}
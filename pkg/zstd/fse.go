@@ -0,0 +1,163 @@
+package zstd
+
+// This file is responsible for the finite state entropy (FSE) tables that code the sequences
+// and the weights of Huffman tables
+
+import (
+    "errors"
+    "math/bits"
+)
+
+// a state of an FSE table: the symbol it decodes to and how to find the next state
+type fseEntry struct {
+    symbol uint8
+    bits   uint8
+    base   uint16
+}
+
+type fseTable struct {
+    accuracyLog uint8
+    entries     []fseEntry
+}
+
+// returns the initial state, read from the stream
+func (t *fseTable) initialState(br *backwardBitReader) int {
+    return int(br.read(t.accuracyLog))
+}
+
+// returns the state that follows the given one
+func (t *fseTable) nextState(state int, br *backwardBitReader) int {
+    entry := t.entries[state]
+    return int(entry.base) + int(br.read(entry.bits))
+}
+
+// reads a table description, returning the table and the number of bytes it took up
+func readFSETable(data []byte, maxAccuracyLog, maxSymbol int) (*fseTable, int, error) {
+    br := &forwardBitReader{data: data}
+    accuracyLog := int(br.read(4)) + 5
+    if accuracyLog > maxAccuracyLog {
+        return nil, 0, errors.New("zstd: FSE table accuracy too large")
+    }
+
+    // The probabilities add up to 1<<accuracyLog, where -1 stands for "less than 1" and counts as 1
+    remaining := 1<<accuracyLog + 1
+    threshold := 1 << accuracyLog
+    width := accuracyLog + 1
+    var counts []int16
+    for remaining > 1 {
+        if len(counts) > maxSymbol {
+            return nil, 0, errors.New("zstd: FSE table has too many symbols")
+        }
+
+        // Small values take one bit less, see RFC 8878 section 4.1.1
+        max := 2*threshold - 1 - remaining
+        value := int(br.peek(width - 1))
+        if value < max {
+            br.read(width - 1)
+        } else {
+            value = int(br.read(width))
+            if value >= threshold {
+                value -= max
+            }
+        }
+
+        count := value - 1
+        counts = append(counts, int16(count))
+        if count < 0 {
+            remaining--
+        } else {
+            remaining -= count
+        }
+        if remaining < 1 {
+            return nil, 0, errors.New("zstd: FSE table probabilities overflow")
+        }
+        for remaining < threshold {
+            width--
+            threshold >>= 1
+        }
+
+        // Zero probabilities are followed by 2 bit flags repeating them, where 3 means another flag follows
+        if count == 0 {
+            for {
+                repeat := int(br.read(2))
+                for i := 0; i < repeat; i++ {
+                    counts = append(counts, 0)
+                }
+                if len(counts) > maxSymbol+1 {
+                    return nil, 0, errors.New("zstd: FSE table has too many symbols")
+                }
+                if repeat != 3 {
+                    break
+                }
+            }
+        }
+    }
+    if br.bytesRead() > len(data) {
+        return nil, 0, errTruncated
+    }
+
+    table, err := buildFSETable(counts, accuracyLog)
+    if err != nil {
+        return nil, 0, err
+    }
+    return table, br.bytesRead(), nil
+}
+
+// spreads the symbols over the states of a table by their normalized counts
+func buildFSETable(counts []int16, accuracyLog int) (*fseTable, error) {
+    size := 1 << accuracyLog
+    entries := make([]fseEntry, size)
+    next := make([]int, len(counts))
+
+    // Symbols with a "less than 1" probability get a state each at the end of the table
+    high := size - 1
+    for symbol, count := range counts {
+        if count == -1 {
+            entries[high].symbol = uint8(symbol)
+            high--
+            next[symbol] = 1
+        } else {
+            next[symbol] = int(count)
+        }
+    }
+
+    step := size>>1 + size>>3 + 3
+    mask := size - 1
+    position := 0
+    for symbol, count := range counts {
+        for i := 0; i < int(count); i++ {
+            entries[position].symbol = uint8(symbol)
+            position = (position + step) & mask
+            for position > high {
+                position = (position + step) & mask
+            }
+        }
+    }
+    if position != 0 {
+        return nil, errors.New("zstd: invalid FSE table probabilities")
+    }
+
+    for i := range entries {
+        symbol := entries[i].symbol
+        state := next[symbol]
+        next[symbol]++
+        width := accuracyLog - (bits.Len(uint(state)) - 1)
+        entries[i].bits = uint8(width)
+        entries[i].base = uint16(state<<width - size)
+    }
+    return &fseTable{accuracyLog: uint8(accuracyLog), entries: entries}, nil
+}
+
+// returns a table that always decodes to the same symbol, without reading any bits
+func rleFSETable(symbol uint8) *fseTable {
+    return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+// builds a table from one of the predefined distributions, which can't fail
+func predefinedFSETable(counts []int16, accuracyLog int) *fseTable {
+    table, err := buildFSETable(counts, accuracyLog)
+    if err != nil {
+        panic(err)
+    }
+    return table
+}
@@ -0,0 +1,289 @@
+package zstd
+
+// This file is responsible for the literals section of a compressed block, the bytes that
+// aren't copied from earlier output. They are stored as they are, as a single repeated byte,
+// or Huffman coded in one or four streams
+
+import (
+    "errors"
+    "math/bits"
+)
+
+const (
+    literalsRaw = iota
+    literalsRLE
+    literalsCompressed
+    literalsTreeless
+)
+
+// Huffman codes are at most this long
+const maxHuffmanBits = 11
+
+type huffmanEntry struct {
+    symbol uint8
+    bits   uint8
+}
+
+// a Huffman decoding table, indexed by the next maxBits bits of a stream
+type huffmanTable struct {
+    maxBits uint8
+    entries []huffmanEntry
+}
+
+// decodes the literals section at the start of a block, returning the literals and the rest of the block
+func (d *frameDecoder) decodeLiterals(block []byte) ([]byte, []byte, error) {
+    if len(block) < 1 {
+        return nil, nil, errTruncated
+    }
+    literalsType := block[0] & 3
+    sizeFormat := (block[0] >> 2) & 3
+
+    if literalsType == literalsRaw || literalsType == literalsRLE {
+        var size, headerSize int
+        switch sizeFormat {
+        case 0, 2:
+            size, headerSize = int(block[0]>>3), 1
+        case 1:
+            headerSize = 2
+        case 3:
+            headerSize = 3
+        }
+        if len(block) < headerSize {
+            return nil, nil, errTruncated
+        }
+        if headerSize > 1 {
+            size = int(readLittleEndian(block[:headerSize]) >> 4)
+        }
+
+        if literalsType == literalsRaw {
+            if len(block) < headerSize+size {
+                return nil, nil, errTruncated
+            }
+            return block[headerSize : headerSize+size], block[headerSize+size:], nil
+        }
+        if len(block) < headerSize+1 {
+            return nil, nil, errTruncated
+        }
+        literals := d.literalBuffer(size)
+        for i := range literals {
+            literals[i] = block[headerSize]
+        }
+        return literals, block[headerSize+1:], nil
+    }
+
+    streams, headerSize, sizeBits := 4, 0, 0
+    switch sizeFormat {
+    case 0:
+        streams, headerSize, sizeBits = 1, 3, 10
+    case 1:
+        headerSize, sizeBits = 3, 10
+    case 2:
+        headerSize, sizeBits = 4, 14
+    case 3:
+        headerSize, sizeBits = 5, 18
+    }
+    if len(block) < headerSize {
+        return nil, nil, errTruncated
+    }
+    header := readLittleEndian(block[:headerSize])
+    mask := uint64(1)<<sizeBits - 1
+    size := int(header >> 4 & mask)
+    compressedSize := int(header >> (4 + sizeBits) & mask)
+    if len(block) < headerSize+compressedSize {
+        return nil, nil, errTruncated
+    }
+    data := block[headerSize : headerSize+compressedSize]
+
+    // Treeless literals reuse the Huffman table of an earlier block
+    if literalsType == literalsCompressed {
+        table, n, err := readHuffmanTable(data)
+        if err != nil {
+            return nil, nil, err
+        }
+        d.huffman = table
+        data = data[n:]
+    } else if d.huffman == nil {
+        return nil, nil, errors.New("zstd: treeless literals without an earlier Huffman table")
+    }
+
+    literals := d.literalBuffer(size)
+    if streams == 1 {
+        if err := d.huffman.decodeStream(data, literals); err != nil {
+            return nil, nil, err
+        }
+        return literals, block[headerSize+compressedSize:], nil
+    }
+
+    // Four streams start with a jump table of the sizes of the first three
+    if len(data) < 6 {
+        return nil, nil, errTruncated
+    }
+    streamSizes := [4]int{int(readLittleEndian(data[0:2])), int(readLittleEndian(data[2:4])), int(readLittleEndian(data[4:6]))}
+    data = data[6:]
+    streamSizes[3] = len(data) - streamSizes[0] - streamSizes[1] - streamSizes[2]
+    segment := (size + 3) / 4
+    if streamSizes[3] < 0 || 3*segment > size {
+        return nil, nil, errors.New("zstd: invalid literal stream sizes")
+    }
+    for i, streamSize := range streamSizes {
+        output := literals[i*segment:]
+        if i < 3 {
+            output = output[:segment]
+        }
+        if err := d.huffman.decodeStream(data[:streamSize], output); err != nil {
+            return nil, nil, err
+        }
+        data = data[streamSize:]
+    }
+    return literals, block[headerSize+compressedSize:], nil
+}
+
+// returns a buffer for the literals of a block, which are used up before the next block is decoded
+func (d *frameDecoder) literalBuffer(size int) []byte {
+    if cap(d.literals) < size {
+        d.literals = make([]byte, size)
+    }
+    return d.literals[:size]
+}
+
+// reads a Huffman tree description, returning the table and the number of bytes it took up
+func readHuffmanTable(data []byte) (*huffmanTable, int, error) {
+    if len(data) < 1 {
+        return nil, 0, errTruncated
+    }
+
+    // The weights are either FSE coded or stored directly, four bits each
+    var weights []uint8
+    var consumed int
+    if header := int(data[0]); header < 128 {
+        if len(data) < 1+header {
+            return nil, 0, errTruncated
+        }
+        var err error
+        weights, err = decodeHuffmanWeights(data[1 : 1+header])
+        if err != nil {
+            return nil, 0, err
+        }
+        consumed = 1 + header
+    } else {
+        count := header - 127
+        consumed = 1 + (count+1)/2
+        if len(data) < consumed {
+            return nil, 0, errTruncated
+        }
+        weights = make([]uint8, count)
+        for i := range weights {
+            if i%2 == 0 {
+                weights[i] = data[1+i/2] >> 4
+            } else {
+                weights[i] = data[1+i/2] & 0x0f
+            }
+        }
+    }
+
+    table, err := buildHuffmanTable(weights)
+    if err != nil {
+        return nil, 0, err
+    }
+    return table, consumed, nil
+}
+
+// decodes FSE coded Huffman weights, which use two interleaved states
+func decodeHuffmanWeights(data []byte) ([]uint8, error) {
+    table, n, err := readFSETable(data, 6, 255)
+    if err != nil {
+        return nil, err
+    }
+    br, err := newBackwardBitReader(data[n:])
+    if err != nil {
+        return nil, err
+    }
+
+    states := [2]int{table.initialState(br), table.initialState(br)}
+    var weights []uint8
+    for current := 0; ; current ^= 1 {
+        if len(weights) >= 255 {
+            return nil, errors.New("zstd: too many Huffman weights")
+        }
+        weights = append(weights, table.entries[states[current]].symbol)
+        states[current] = table.nextState(states[current], br)
+        // Once the stream runs out, the other state holds the last weight
+        if br.overflowed() {
+            weights = append(weights, table.entries[states[current^1]].symbol)
+            break
+        }
+    }
+    return weights, nil
+}
+
+// builds the decoding table from the weights of every symbol but the last, whose weight
+// follows from the others since all codes add up to a power of two
+func buildHuffmanTable(weights []uint8) (*huffmanTable, error) {
+    if len(weights) > 255 {
+        return nil, errors.New("zstd: too many Huffman weights")
+    }
+    var total uint32
+    for _, weight := range weights {
+        if weight > maxHuffmanBits {
+            return nil, errors.New("zstd: invalid Huffman weight")
+        }
+        if weight > 0 {
+            total += 1 << (weight - 1)
+        }
+    }
+    if total == 0 {
+        return nil, errors.New("zstd: empty Huffman table")
+    }
+
+    maxBits := bits.Len32(total)
+    leftover := uint32(1)<<maxBits - total
+    if maxBits > maxHuffmanBits || leftover&(leftover-1) != 0 {
+        return nil, errors.New("zstd: invalid Huffman weights")
+    }
+    weights = append(weights, uint8(bits.Len32(leftover)))
+
+    // Codes are handed out from the lowest weight, so the longest codes get the lowest indices
+    var rankStart [maxHuffmanBits + 2]int
+    for _, weight := range weights {
+        if weight > 0 {
+            rankStart[weight] += 1 << (weight - 1)
+        }
+    }
+    next := 0
+    for weight := 1; weight <= maxBits; weight++ {
+        count := rankStart[weight]
+        rankStart[weight] = next
+        next += count
+    }
+
+    entries := make([]huffmanEntry, 1<<maxBits)
+    for symbol, weight := range weights {
+        if weight == 0 {
+            continue
+        }
+        length := 1 << (weight - 1)
+        entry := huffmanEntry{symbol: uint8(symbol), bits: uint8(maxBits + 1 - int(weight))}
+        for i := rankStart[weight]; i < rankStart[weight]+length; i++ {
+            entries[i] = entry
+        }
+        rankStart[weight] += length
+    }
+    return &huffmanTable{maxBits: uint8(maxBits), entries: entries}, nil
+}
+
+// decodes a Huffman coded stream until the output is full, which must use up the stream exactly
+func (t *huffmanTable) decodeStream(data []byte, out []byte) error {
+    br, err := newBackwardBitReader(data)
+    if err != nil {
+        return err
+    }
+    for i := range out {
+        entry := t.entries[br.peek(t.maxBits)]
+        out[i] = entry.symbol
+        br.pos -= int(entry.bits)
+    }
+    if br.pos != 0 {
+        return errors.New("zstd: corrupt Huffman stream")
+    }
+    return nil
+}
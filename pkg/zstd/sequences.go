@@ -0,0 +1,242 @@
+package zstd
+
+// This file is responsible for the sequences section of a compressed block. Every sequence copies
+// some literals to the output, followed by a match copied from earlier output
+
+import (
+    "errors"
+    "fmt"
+)
+
+const (
+    modePredefined = iota
+    modeRLE
+    modeCompressed
+    modeRepeat
+)
+
+// the sequence codes, in the order their tables are stored in a block
+const (
+    literalLengthCodes = iota
+    offsetCodes
+    matchLengthCodes
+)
+
+// the largest symbol and accuracy each kind of code allows
+var (
+    maxSequenceSymbols  = [3]int{35, 31, 52}
+    maxSequenceAccuracy = [3]int{9, 8, 9}
+)
+
+// the baselines and extra bits of the literal length codes
+var (
+    literalLengthBase = [36]uint32{
+        0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+        16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+        8192, 16384, 32768, 65536,
+    }
+    literalLengthBits = [36]uint8{
+        0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+        1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+        13, 14, 15, 16,
+    }
+)
+
+// the baselines and extra bits of the match length codes
+var (
+    matchLengthBase = [53]uint32{
+        3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+        19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+        35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+        4099, 8195, 16387, 32771, 65539,
+    }
+    matchLengthBits = [53]uint8{
+        0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+        0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+        1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+        12, 13, 14, 15, 16,
+    }
+)
+
+// the tables of the predefined mode, RFC 8878 section 3.1.1.3.2.2
+var predefinedTables = [3]*fseTable{
+    predefinedFSETable([]int16{
+        4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+        2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+        -1, -1, -1, -1,
+    }, 6),
+    predefinedFSETable([]int16{
+        1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+        1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+    }, 5),
+    predefinedFSETable([]int16{
+        1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+        1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+        1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+        -1, -1, -1, -1, -1,
+    }, 6),
+}
+
+// decodes the sequences section of a block and executes the sequences
+func (d *frameDecoder) decodeSequences(data []byte, literals []byte) error {
+    if len(data) < 1 {
+        return errTruncated
+    }
+    count := int(data[0])
+    pos := 1
+    switch {
+    case count == 0:
+        d.out = append(d.out, literals...)
+        return nil
+    case count == 255:
+        if len(data) < 3 {
+            return errTruncated
+        }
+        count = int(data[1]) + int(data[2])<<8 + 0x7f00
+        pos = 3
+    case count >= 128:
+        if len(data) < 2 {
+            return errTruncated
+        }
+        count = (count-128)<<8 + int(data[1])
+        pos = 2
+    }
+
+    if len(data) < pos+1 {
+        return errTruncated
+    }
+    modes := data[pos]
+    pos++
+    if modes&3 != 0 {
+        return errors.New("zstd: reserved bits set in the sequence modes")
+    }
+
+    var tables [3]*fseTable
+    for kind := range tables {
+        mode := modes >> (6 - 2*kind) & 3
+        table, n, err := d.sequenceTable(kind, int(mode), data[pos:])
+        if err != nil {
+            return err
+        }
+        tables[kind] = table
+        pos += n
+    }
+
+    br, err := newBackwardBitReader(data[pos:])
+    if err != nil {
+        return err
+    }
+    literalLengthState := tables[literalLengthCodes].initialState(br)
+    offsetState := tables[offsetCodes].initialState(br)
+    matchLengthState := tables[matchLengthCodes].initialState(br)
+
+    for i := 0; i < count; i++ {
+        offsetCode := tables[offsetCodes].entries[offsetState].symbol
+        matchLengthCode := tables[matchLengthCodes].entries[matchLengthState].symbol
+        literalLengthCode := tables[literalLengthCodes].entries[literalLengthState].symbol
+        if offsetCode > 31 {
+            return errors.New("zstd: invalid offset code")
+        }
+
+        // The extra bits are stored offset first, the states are updated literal length first
+        offsetValue := 1<<offsetCode + int(br.read(offsetCode))
+        matchLength := int(matchLengthBase[matchLengthCode]) + int(br.read(matchLengthBits[matchLengthCode]))
+        literalLength := int(literalLengthBase[literalLengthCode]) + int(br.read(literalLengthBits[literalLengthCode]))
+        if i < count-1 {
+            literalLengthState = tables[literalLengthCodes].nextState(literalLengthState, br)
+            matchLengthState = tables[matchLengthCodes].nextState(matchLengthState, br)
+            offsetState = tables[offsetCodes].nextState(offsetState, br)
+        }
+        if br.overflowed() {
+            return errors.New("zstd: corrupt sequence bitstream")
+        }
+
+        if literalLength > len(literals) {
+            return errors.New("zstd: sequence uses more literals than the block has")
+        }
+        d.out = append(d.out, literals[:literalLength]...)
+        literals = literals[literalLength:]
+
+        offset := d.resolveOffset(offsetValue, literalLength)
+        if offset <= 0 || offset > len(d.out)-d.start {
+            return fmt.Errorf("zstd: match offset %d reaches before the start of the frame", offset)
+        }
+        d.copyMatch(offset, matchLength)
+    }
+    if br.pos != 0 {
+        return errors.New("zstd: corrupt sequence bitstream")
+    }
+
+    d.out = append(d.out, literals...)
+    return nil
+}
+
+// returns the table a block uses for a kind of code, returning the number of bytes its description took up
+func (d *frameDecoder) sequenceTable(kind, mode int, data []byte) (*fseTable, int, error) {
+    var table *fseTable
+    consumed := 0
+    switch mode {
+    case modePredefined:
+        table = predefinedTables[kind]
+    case modeRLE:
+        if len(data) < 1 {
+            return nil, 0, errTruncated
+        }
+        if int(data[0]) > maxSequenceSymbols[kind] {
+            return nil, 0, errors.New("zstd: invalid RLE sequence code")
+        }
+        table, consumed = rleFSETable(data[0]), 1
+    case modeCompressed:
+        var err error
+        table, consumed, err = readFSETable(data, maxSequenceAccuracy[kind], maxSequenceSymbols[kind])
+        if err != nil {
+            return nil, 0, err
+        }
+    case modeRepeat:
+        if d.tables[kind] == nil {
+            return nil, 0, errors.New("zstd: repeated sequence table without an earlier one")
+        }
+        table = d.tables[kind]
+    }
+    d.tables[kind] = table
+    return table, consumed, nil
+}
+
+// turns the offset value of a sequence into a match offset, keeping track of the repeat offsets.
+// Values up to 3 pick a repeat offset, shifted by one when the sequence has no literals
+func (d *frameDecoder) resolveOffset(offsetValue, literalLength int) int {
+    if offsetValue > 3 {
+        offset := offsetValue - 3
+        d.reps = [3]int{offset, d.reps[0], d.reps[1]}
+        return offset
+    }
+
+    index := offsetValue - 1
+    if literalLength == 0 {
+        index++
+    }
+    switch index {
+    case 0:
+        return d.reps[0]
+    case 1:
+        d.reps[0], d.reps[1] = d.reps[1], d.reps[0]
+        return d.reps[0]
+    case 2:
+        d.reps = [3]int{d.reps[2], d.reps[0], d.reps[1]}
+        return d.reps[0]
+    }
+    d.reps = [3]int{d.reps[0] - 1, d.reps[0], d.reps[1]}
+    return d.reps[0]
+}
+
+// copies a match from earlier output, which may overlap what it produces
+func (d *frameDecoder) copyMatch(offset, length int) {
+    start := len(d.out) - offset
+    if offset >= length {
+        d.out = append(d.out, d.out[start:start+length]...)
+        return
+    }
+    for i := 0; i < length; i++ {
+        d.out = append(d.out, d.out[start+i])
+    }
+}
@@ -0,0 +1,73 @@
+package zstd
+
+// This file is responsible for XXH64, the hash the content checksum of a frame is taken from
+
+import (
+    "encoding/binary"
+    "math/bits"
+)
+
+const (
+    prime64x1 uint64 = 11400714785074694791
+    prime64x2 uint64 = 14029467366897019727
+    prime64x3 uint64 = 1609587929392839161
+    prime64x4 uint64 = 9650029242287828579
+    prime64x5 uint64 = 2870177450012600261
+)
+
+// returns the XXH64 hash of data with a seed of 0
+func xxhash64(data []byte) uint64 {
+    length := uint64(len(data))
+    var hash uint64
+
+    if len(data) >= 32 {
+        // The constants wrap around on purpose, which Go only allows at run time
+        primes := [2]uint64{prime64x1, prime64x2}
+        v1 := primes[0] + primes[1]
+        v2 := primes[1]
+        v3 := uint64(0)
+        v4 := -primes[0]
+        for len(data) >= 32 {
+            v1 = xxhashRound(v1, binary.LittleEndian.Uint64(data[0:]))
+            v2 = xxhashRound(v2, binary.LittleEndian.Uint64(data[8:]))
+            v3 = xxhashRound(v3, binary.LittleEndian.Uint64(data[16:]))
+            v4 = xxhashRound(v4, binary.LittleEndian.Uint64(data[24:]))
+            data = data[32:]
+        }
+        hash = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+        for _, v := range []uint64{v1, v2, v3, v4} {
+            hash ^= xxhashRound(0, v)
+            hash = hash*prime64x1 + prime64x4
+        }
+    } else {
+        hash = prime64x5
+    }
+    hash += length
+
+    for ; len(data) >= 8; data = data[8:] {
+        hash ^= xxhashRound(0, binary.LittleEndian.Uint64(data))
+        hash = bits.RotateLeft64(hash, 27)*prime64x1 + prime64x4
+    }
+    if len(data) >= 4 {
+        hash ^= uint64(binary.LittleEndian.Uint32(data)) * prime64x1
+        hash = bits.RotateLeft64(hash, 23)*prime64x2 + prime64x3
+        data = data[4:]
+    }
+    for _, b := range data {
+        hash ^= uint64(b) * prime64x5
+        hash = bits.RotateLeft64(hash, 11) * prime64x1
+    }
+
+    hash ^= hash >> 33
+    hash *= prime64x2
+    hash ^= hash >> 29
+    hash *= prime64x3
+    hash ^= hash >> 32
+    return hash
+}
+
+func xxhashRound(acc, input uint64) uint64 {
+    acc += input * prime64x2
+    acc = bits.RotateLeft64(acc, 31)
+    return acc * prime64x1
+}
@@ -0,0 +1,201 @@
+package zstd
+
+// This package is responsible for decompressing Zstandard data (RFC 8878), so the sync databases
+// can be read without the zstd command line tool. It only decompresses whole buffers at once,
+// which is all a sync database needs, and doesn't support dictionaries
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+)
+
+const (
+    frameMagic         = 0xfd2fb528
+    skippableMagic     = 0x184d2a50
+    skippableMagicMask = 0xfffffff0
+    // the largest block a frame may contain
+    maxBlockSize = 128 << 10
+    // how much of a declared content size is allocated up front, so corrupt headers can't exhaust memory
+    maxPreallocation = 64 << 20
+)
+
+const (
+    blockRaw = iota
+    blockRLE
+    blockCompressed
+    blockReserved
+)
+
+var errTruncated = errors.New("zstd: unexpected end of input")
+
+// holds what carries over from one block of a frame to the next
+type frameDecoder struct {
+    // everything decompressed so far, earlier frames included
+    out []byte
+    // where the current frame starts in out, matches can't reach further back
+    start int
+    // the repeat offsets
+    reps [3]int
+    // the Huffman table of the last block with compressed literals
+    huffman *huffmanTable
+    // the sequence tables of the last block, reused by the repeat mode
+    tables [3]*fseTable
+    // the buffer Huffman coded literals are decoded into
+    literals []byte
+}
+
+// Decompress decompresses every frame in data and returns the concatenated content.
+// Skippable frames are skipped
+func Decompress(data []byte) ([]byte, error) {
+    var out []byte
+    for len(data) > 0 {
+        if len(data) < 4 {
+            return nil, errTruncated
+        }
+        magic := binary.LittleEndian.Uint32(data)
+        if magic&skippableMagicMask == skippableMagic {
+            if len(data) < 8 {
+                return nil, errTruncated
+            }
+            size := uint64(binary.LittleEndian.Uint32(data[4:]))
+            if uint64(len(data)-8) < size {
+                return nil, errTruncated
+            }
+            data = data[8+size:]
+            continue
+        }
+        if magic != frameMagic {
+            return nil, fmt.Errorf("zstd: invalid frame magic %#x", magic)
+        }
+
+        d := &frameDecoder{out: out, start: len(out), reps: [3]int{1, 4, 8}}
+        rest, err := d.decodeFrame(data[4:])
+        if err != nil {
+            return nil, err
+        }
+        out, data = d.out, rest
+    }
+    return out, nil
+}
+
+// decodes a frame following its magic number, returning the input after it
+func (d *frameDecoder) decodeFrame(data []byte) ([]byte, error) {
+    if len(data) < 1 {
+        return nil, errTruncated
+    }
+    descriptor := data[0]
+    pos := 1
+    if descriptor&0x08 != 0 {
+        return nil, errors.New("zstd: reserved bit set in frame header")
+    }
+    singleSegment := descriptor&0x20 != 0
+    hasChecksum := descriptor&0x04 != 0
+
+    // The window size only bounds how far matches reach back, which the frame start already does
+    if !singleSegment {
+        pos++
+    }
+
+    dictionaryIDSize := []int{0, 1, 2, 4}[descriptor&0x03]
+    contentSizeSize := []int{0, 2, 4, 8}[descriptor>>6]
+    if descriptor>>6 == 0 && singleSegment {
+        contentSizeSize = 1
+    }
+    if len(data) < pos+dictionaryIDSize+contentSizeSize {
+        return nil, errTruncated
+    }
+
+    if readLittleEndian(data[pos:pos+dictionaryIDSize]) != 0 {
+        return nil, errors.New("zstd: frames compressed with a dictionary are not supported")
+    }
+    pos += dictionaryIDSize
+
+    contentSize := readLittleEndian(data[pos : pos+contentSizeSize])
+    if contentSizeSize == 2 {
+        contentSize += 256
+    }
+    pos += contentSizeSize
+    if contentSizeSize > 0 && contentSize <= maxPreallocation && cap(d.out)-len(d.out) < int(contentSize) {
+        grown := make([]byte, len(d.out), len(d.out)+int(contentSize))
+        copy(grown, d.out)
+        d.out = grown
+    }
+
+    for {
+        if len(data) < pos+3 {
+            return nil, errTruncated
+        }
+        header := readLittleEndian(data[pos : pos+3])
+        pos += 3
+        last := header&1 != 0
+        size := int(header >> 3)
+
+        switch (header >> 1) & 3 {
+        case blockRaw:
+            if len(data) < pos+size {
+                return nil, errTruncated
+            }
+            d.out = append(d.out, data[pos:pos+size]...)
+            pos += size
+        case blockRLE:
+            if len(data) < pos+1 {
+                return nil, errTruncated
+            }
+            for i := 0; i < size; i++ {
+                d.out = append(d.out, data[pos])
+            }
+            pos++
+        case blockCompressed:
+            if size > maxBlockSize {
+                return nil, fmt.Errorf("zstd: block of %d bytes exceeds the maximum block size", size)
+            }
+            if len(data) < pos+size {
+                return nil, errTruncated
+            }
+            if err := d.decodeCompressedBlock(data[pos : pos+size]); err != nil {
+                return nil, err
+            }
+            pos += size
+        default:
+            return nil, errors.New("zstd: reserved block type")
+        }
+
+        if last {
+            break
+        }
+    }
+
+    content := d.out[d.start:]
+    if contentSizeSize > 0 && uint64(len(content)) != contentSize {
+        return nil, fmt.Errorf("zstd: frame decompressed to %d bytes instead of %d", len(content), contentSize)
+    }
+    if hasChecksum {
+        if len(data) < pos+4 {
+            return nil, errTruncated
+        }
+        if uint32(xxhash64(content)) != binary.LittleEndian.Uint32(data[pos:]) {
+            return nil, errors.New("zstd: content checksum mismatch")
+        }
+        pos += 4
+    }
+    return data[pos:], nil
+}
+
+// decodes a compressed block, which holds the literals followed by the sequences
+func (d *frameDecoder) decodeCompressedBlock(block []byte) error {
+    literals, rest, err := d.decodeLiterals(block)
+    if err != nil {
+        return err
+    }
+    return d.decodeSequences(rest, literals)
+}
+
+// reads an unsigned little endian number of up to eight bytes
+func readLittleEndian(data []byte) uint64 {
+    var value uint64
+    for i := len(data) - 1; i >= 0; i-- {
+        value = value<<8 | uint64(data[i])
+    }
+    return value
+}
@@ -0,0 +1,128 @@
+package zstd
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestDecompress(t *testing.T) {
+    // The plain text is shared with the tests of the other decompressor
+    text, err := os.ReadFile(filepath.Join("..", "..", "internal", "testdata", "text.txt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        file string
+        want []byte
+    }{
+        // zstd -19, with a content checksum
+        {"text.txt.zst", text},
+        // zstd -1 --no-check
+        {"text-fast.txt.zst", text},
+        // zstd -6 -B4096, where later blocks repeat the tables of earlier ones
+        {"text-blocks.txt.zst", text},
+        // the fast and the -19 frame with a skippable frame between them
+        {"text-frames.txt.zst", append(append([]byte{}, text...), text...)},
+    }
+
+    for _, test := range tests {
+        t.Run(test.file, func(t *testing.T) {
+            data, err := os.ReadFile(filepath.Join("testdata", test.file))
+            if err != nil {
+                t.Fatal(err)
+            }
+            got, err := Decompress(data)
+            if err != nil {
+                t.Fatalf("Decompress() error = %v", err)
+            }
+            if !bytes.Equal(got, test.want) {
+                t.Errorf("Decompress() returned %d bytes that don't match the %d expected", len(got), len(test.want))
+            }
+        })
+    }
+}
+
+func TestDecompressSmallFrames(t *testing.T) {
+    tests := []struct {
+        name string
+        data []byte
+        want []byte
+    }{
+        {
+            name: "empty frame",
+            data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x00, 0x01, 0x00, 0x00},
+            want: nil,
+        },
+        {
+            name: "raw block",
+            data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x03, 0x19, 0x00, 0x00, 'a', 'b', 'c'},
+            want: []byte("abc"),
+        },
+        {
+            name: "RLE block",
+            data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x20, 0x05, 0x2b, 0x00, 0x00, 'z'},
+            want: []byte("zzzzz"),
+        },
+        {
+            name: "no input",
+            data: nil,
+            want: nil,
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := Decompress(test.data)
+            if err != nil {
+                t.Fatalf("Decompress() error = %v", err)
+            }
+            if !bytes.Equal(got, test.want) {
+                t.Errorf("Decompress() = %q, want %q", got, test.want)
+            }
+        })
+    }
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+    data, err := os.ReadFile(filepath.Join("testdata", "text.txt.zst"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    flipped := append([]byte{}, data...)
+    flipped[len(flipped)/2] ^= 0x10
+    tests := map[string][]byte{
+        "bad magic":        append([]byte{0x00}, data[1:]...),
+        "truncated":        data[:len(data)/2],
+        "flipped bit":      flipped,
+        "missing checksum": data[:len(data)-4],
+    }
+
+    for name, corrupt := range tests {
+        t.Run(name, func(t *testing.T) {
+            if _, err := Decompress(corrupt); err == nil {
+                t.Error("Decompress() of corrupt data succeeded")
+            }
+        })
+    }
+}
+
+func TestXXHash64(t *testing.T) {
+    tests := []struct {
+        input string
+        want  uint64
+    }{
+        {"", 0xef46db3751d8e999},
+        {"a", 0xd24ec4f1a98c6e5b},
+        {"abc", 0x44bc2cf5ad770999},
+        {"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+    }
+    for _, test := range tests {
+        if got := xxhash64([]byte(test.input)); got != test.want {
+            t.Errorf("xxhash64(%q) = %#x, want %#x", test.input, got, test.want)
+        }
+    }
+}